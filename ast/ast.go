@@ -3,6 +3,7 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"lisp/token"
 )
 
@@ -13,6 +14,7 @@ import (
 type Expression interface {
 	expression()
 	String() string
+	Pos() token.Position // The position in the source where the Expression begins.
 }
 
 // Used as an alias to identify the
@@ -35,6 +37,15 @@ func (p *Program) String() string {
 
 func (p *Program) expression() {}
 
+// The position of a Program is the position of its first expression.
+func (p *Program) Pos() token.Position {
+	if len(p.Expressions) == 0 {
+		return token.Position{}
+	}
+
+	return p.Expressions[0].Pos()
+}
+
 // Identifiers are variable names.
 type Identifier struct {
 	Token token.Token
@@ -46,6 +57,10 @@ func (i *Identifier) String() string {
 
 func (i *Identifier) expression() {}
 
+func (i *Identifier) Pos() token.Position {
+	return i.Token.Pos
+}
+
 type FloatLiteral struct {
 	Token token.Token
 	Value float64
@@ -57,6 +72,10 @@ func (fl *FloatLiteral) String() string {
 
 func (fl *FloatLiteral) expression() {}
 
+func (fl *FloatLiteral) Pos() token.Position {
+	return fl.Token.Pos
+}
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -68,6 +87,10 @@ func (sl *StringLiteral) String() string {
 
 func (sl *StringLiteral) expression() {}
 
+func (sl *StringLiteral) Pos() token.Position {
+	return sl.Token.Pos
+}
+
 // SExpressions are the lisp representation of a function call.
 //
 // Fn represents the function `func` and Args represents the
@@ -78,11 +101,21 @@ func (sl *StringLiteral) expression() {}
 // SExpression fulfills the Expression interface, so both Fn
 // and any arg can also be an SExpression.
 type SExpression struct {
-	Fn   Expression
-	Args []Expression
+	// Token is the token that opened the expression, usually '('.
+	Token token.Token
+	Fn    Expression
+	Args  []Expression
 	// Name is only used in the compiler. The purpose is to associate a name
 	// with a lambda expression to detect recursive calls.
 	Name string
+	// Inline is only used in the compiler. It marks a lambda expression
+	// built to run the body of a let expression, which is left out of stack
+	// traces.
+	Inline bool
+}
+
+func (se *SExpression) Pos() token.Position {
+	return se.Token.Pos
 }
 
 // Recursively print the values in the SExpression.
@@ -105,3 +138,49 @@ func (se *SExpression) String() string {
 }
 
 func (se *SExpression) expression() {}
+
+// BadExpression is a placeholder for source code that failed to parse, so
+// that a parsed AST never contains nil Expressions.
+type BadExpression struct {
+	Token token.Token // The Token at which the error was found.
+}
+
+func (be *BadExpression) String() string {
+	return be.Token.Literal
+}
+
+func (be *BadExpression) expression() {}
+
+func (be *BadExpression) Pos() token.Position {
+	return be.Token.Pos
+}
+
+// Module is a program read from a file so that other programs can import it.
+type Module struct {
+	Path    string        // The path of the file the module was read from.
+	Program *Program      // The program of the module, with its macros expanded.
+	Exports []*Identifier // The names the module makes available to programs importing it.
+}
+
+// Import is an import expression, which makes the exports of a module
+// available to the program importing it as namespace/name:
+//
+// (import "path/to/lib.lsp" :as namespace)
+//
+// Modules are read when a program is expanded, so every Import of the same
+// file shares one Module.
+type Import struct {
+	Token     token.Token // The '(' token of the import expression.
+	Module    *Module
+	Namespace string
+}
+
+func (i *Import) String() string {
+	return fmt.Sprintf("(import %q :as %s)", i.Module.Path, i.Namespace)
+}
+
+func (i *Import) expression() {}
+
+func (i *Import) Pos() token.Position {
+	return i.Token.Pos
+}
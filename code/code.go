@@ -65,9 +65,8 @@ const (
 	// and place it on top of the stack.
 	OpGetBuiltin
 	// Lambda equivalent of OpConstant: retrieve the constant at the specified
-	// index. The aditional argument is the number of free variables to capture
-	// from the enclosing scope, which are placed on the stack above the
-	// closure object.
+	// index and create a closure from it, capturing the variables described by
+	// the lambda from the enclosing scope.
 	OpClosure
 	// Retrieve the free variable at the provided index from the free
 	// variables slice associated with the closure object.
@@ -75,6 +74,50 @@ const (
 	// Push another instance of the currently executing closure on to the
 	// stack.
 	OpCurrentClosure
+	// Move the instruction pointer to the specified instruction index if the
+	// value on top of the stack evaluates as false, leaving the value on the
+	// stack. Otherwise remove the value from the stack and move on to the next
+	// instruction.
+	OpJumpWhenFalseOrPop
+	// Move the instruction pointer to the specified instruction index if the
+	// value on top of the stack evaluates as true, leaving the value on the
+	// stack. Otherwise remove the value from the stack and move on to the next
+	// instruction.
+	OpJumpWhenTrueOrPop
+	// Compare the value on top of the stack with the constant at the first
+	// index. If they are equal, remove the value from the stack and move the
+	// instruction pointer to the second index. Otherwise leave the value on
+	// the stack and move on to the next instruction.
+	OpJumpWhenEqual
+	// Set the free variable at the provided index, of the closure object of
+	// the current function, to the value on top of the stack without removing
+	// it from the stack.
+	OpSetFree
+	// Move the instruction pointer to the second index if the parameter in
+	// the local slot at the first index was passed an argument by the call to
+	// the current function.
+	OpJumpWhenBound
+	// Equivalent to OpCall for a call in tail position, whose result is
+	// returned by the current function. Calls to closures replace the current
+	// function in its frame instead of adding a new one.
+	OpTailCall
+	// Begin the body of a try expression, so that an error thrown before the
+	// matching OpEndTry moves the instruction pointer to the specified
+	// instruction index, after removing everything the body added to the
+	// stack.
+	OpTry
+	// End the body of the most recent try expression, after which errors are
+	// no longer handled by it.
+	OpEndTry
+	// Throw the value on top of the stack as an error.
+	OpThrow
+	// Push the value caught by the most recently handled error on to the top
+	// of the stack.
+	OpCaught
+	// Copy the value on top of the stack into the specified index of the
+	// globals slice, as OpSetGlobal does, for a global that has already been
+	// defined.
+	OpAssignGlobal
 )
 
 // definitions contains a map from an Opcode to its Definition. The Definition
@@ -82,24 +125,35 @@ const (
 // in the slice represents an argument for the Opcode, with the value itself
 // being the number of bytes long the argument will be.
 var definitions = map[Opcode]*Definition{
-	OpConstant:       {"OpConstant", []int{2}},
-	OpPop:            {"OpPop", []int{}},
-	OpTrue:           {"OpTrue", []int{}},
-	OpFalse:          {"OpFalse", []int{}},
-	OpJumpWhenFalse:  {"OpJumpWhenFalse", []int{2}},
-	OpJump:           {"OpJump", []int{2}},
-	OpNull:           {"OpNull", []int{}},
-	OpGetGlobal:      {"OpGetGlobal", []int{2}},
-	OpSetGlobal:      {"OpSetGlobal", []int{2}},
-	OpCall:           {"OpCall", []int{1}},
-	OpReturn:         {"OpReturn", []int{}},
-	OpGetLocal:       {"OpGetLocal", []int{1}},
-	OpSetLocal:       {"OpSetLocal", []int{1}},
-	OpEmptyList:      {"OpEmptyList", []int{}},
-	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
-	OpClosure:        {"OpClosure", []int{2, 1}},
-	OpGetFree:        {"OpGetFree", []int{1}},
-	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpConstant:           {"OpConstant", []int{2}},
+	OpPop:                {"OpPop", []int{}},
+	OpTrue:               {"OpTrue", []int{}},
+	OpFalse:              {"OpFalse", []int{}},
+	OpJumpWhenFalse:      {"OpJumpWhenFalse", []int{2}},
+	OpJump:               {"OpJump", []int{2}},
+	OpNull:               {"OpNull", []int{}},
+	OpGetGlobal:          {"OpGetGlobal", []int{2}},
+	OpSetGlobal:          {"OpSetGlobal", []int{2}},
+	OpCall:               {"OpCall", []int{1}},
+	OpReturn:             {"OpReturn", []int{}},
+	OpGetLocal:           {"OpGetLocal", []int{1}},
+	OpSetLocal:           {"OpSetLocal", []int{1}},
+	OpEmptyList:          {"OpEmptyList", []int{}},
+	OpGetBuiltin:         {"OpGetBuiltin", []int{1}},
+	OpClosure:            {"OpClosure", []int{2}},
+	OpGetFree:            {"OpGetFree", []int{1}},
+	OpCurrentClosure:     {"OpCurrentClosure", []int{}},
+	OpJumpWhenFalseOrPop: {"OpJumpWhenFalseOrPop", []int{2}},
+	OpJumpWhenTrueOrPop:  {"OpJumpWhenTrueOrPop", []int{2}},
+	OpJumpWhenEqual:      {"OpJumpWhenEqual", []int{2, 2}},
+	OpSetFree:            {"OpSetFree", []int{1}},
+	OpJumpWhenBound:      {"OpJumpWhenBound", []int{1, 2}},
+	OpTailCall:           {"OpTailCall", []int{1}},
+	OpTry:                {"OpTry", []int{2}},
+	OpEndTry:             {"OpEndTry", []int{}},
+	OpThrow:              {"OpThrow", []int{}},
+	OpCaught:             {"OpCaught", []int{}},
+	OpAssignGlobal:       {"OpAssignGlobal", []int{2}},
 }
 
 // Make builds an instruction from the provided Opcode and operands, using the
@@ -17,7 +17,8 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpPop, []int{}, []byte{byte(OpPop)}},
 		{OpSetLocal, []int{255}, []byte{byte(OpSetLocal), 255}},
-		{OpClosure, []int{65534, 255}, []byte{byte(OpClosure), 255, 254, 255}},
+		{OpClosure, []int{65534}, []byte{byte(OpClosure), 255, 254}},
+		{OpJumpWhenEqual, []int{65534, 258}, []byte{byte(OpJumpWhenEqual), 255, 254, 1, 2}},
 	}
 
 	for _, tt := range tests {
@@ -42,14 +43,14 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpSetLocal, 1),
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
-		Make(OpClosure, 65535, 255),
+		Make(OpJumpWhenEqual, 65535, 255),
 	}
 
 	expected := `0000 OpPop
 0001 OpSetLocal 1
 0003 OpConstant 2
 0006 OpConstant 65535
-0009 OpClosure 65535 255
+0009 OpJumpWhenEqual 65535 255
 `
 
 	concatted := slices.Concat(instructions...)
@@ -69,7 +70,7 @@ func TestReadOperands(t *testing.T) {
 	}{
 		{OpConstant, []int{65535}, 2},
 		{OpSetLocal, []int{255}, 1},
-		{OpClosure, []int{65535, 255}, 3},
+		{OpJumpWhenEqual, []int{65535, 255}, 4},
 	}
 
 	for _, tt := range tests {
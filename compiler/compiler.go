@@ -7,6 +7,8 @@ import (
 	"lisp/ast"
 	"lisp/code"
 	"lisp/object"
+	"lisp/token"
+	"slices"
 )
 
 // A representation of an instruction.
@@ -22,6 +24,7 @@ type CompilationScope struct {
 	instructions        code.Instructions //instructions generated from Compile
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	lines               object.LineTable // the position in the source of the instructions
 }
 
 // The Compiler is a struct that holds the result of calls to the Compile
@@ -31,6 +34,8 @@ type Compiler struct {
 	symbolTable *SymbolTable       // a map from a source code symbol to its memory address
 	scopes      []CompilationScope // a stack of currently used scopes
 	scopeIndex  int                // the currently active scope
+	position    token.Position     // the position of the expression being compiled
+	lambdas     int                // the number of lambdas enclosing the expression, other than let bodies
 }
 
 // Bytecode is a struct containing the instructions produced by a Compiler and
@@ -38,6 +43,8 @@ type Compiler struct {
 type Bytecode struct {
 	Instructions code.Instructions // a collection of OpCodes stored as a slice of bytes
 	Constants    []object.Object   // each of the constant values found in the program
+	GlobalNames  []string          // the name of each global, by index, for errors
+	Lines        object.LineTable  // the position in the source of the instructions
 }
 
 // Return the address of a new Compiler instance.
@@ -83,17 +90,47 @@ func NewWithState(constants []object.Object, symbolTable *SymbolTable) *Compiler
 // Compile an AST Expression into bytecode instructions. Return an error if there is
 // a problem during the compilation step.
 func (c *Compiler) Compile(expr ast.Expression) error {
+	return c.compile(expr, false)
+}
+
+// Compile an AST Expression, where tail reports whether the expression is in
+// tail position: its value is returned directly by the lambda it is in, so a
+// call made by it can replace the lambda instead of returning to it.
+func (c *Compiler) compile(expr ast.Expression, tail bool) error {
+	// Instructions are recorded as coming from the innermost expression with
+	// a known position.
+	if pos := expr.Pos(); pos.IsValid() {
+		defer func(outer token.Position) { c.position = outer }(c.position)
+		c.position = pos
+	}
+
 	switch expr := expr.(type) {
 	case *ast.Program:
+		// A program that fails to compile never runs, so the globals it
+		// declared are forgotten rather than left undefined for later programs.
+		saved := c.symbolTable.save()
+
+		// Declare every top-level definition before compiling any expression,
+		// so that expressions can refer to globals defined after them, such
+		// as mutually recursive functions.
+		for _, e := range expr.Expressions {
+			if def, ok := e.(*ast.SExpression); ok && isForm(def, "def") && len(def.Args) == 2 {
+				if name, ok := def.Args[0].(*ast.Identifier); ok {
+					c.symbolTable.Define(name.String())
+				}
+			}
+		}
+
 		for _, e := range expr.Expressions {
 			err := c.Compile(e)
 
 			if err != nil {
+				c.symbolTable.restore(saved)
 				return err
 			}
 
 			// Pop the top element of the stack after each top-level expression.
-			c.emit(code.OpPop)
+			c.emitPop(e)
 		}
 	case *ast.SExpression:
 		// Conditionally compile an SExpression based on the first element.
@@ -104,19 +141,55 @@ func (c *Compiler) Compile(expr ast.Expression) error {
 
 			switch expr.Fn.String() {
 			case "if":
-				err = c.compileIfExpression(expr)
+				err = c.compileIfExpression(expr, tail)
 			case "def":
 				err = c.compileDefExpression(expr)
+			case "set!":
+				err = c.compileSetExpression(expr)
 			case "lambda":
 				err = c.compileLambdaExpression(expr)
+			case "let":
+				err = c.compileLetExpression(expr, tail)
+			case "let*":
+				err = c.compileLetStarExpression(expr, tail)
+			case "letrec":
+				err = c.compileLetrecExpression(expr, tail)
+			case "cond":
+				err = c.compileCondExpression(expr, tail)
+			case "case":
+				err = c.compileCaseExpression(expr, tail)
+			case "when":
+				err = c.compileWhenExpression(expr, false, tail)
+			case "unless":
+				err = c.compileWhenExpression(expr, true, tail)
+			case "and":
+				err = c.compileLogicalExpression(expr, code.OpTrue, code.OpJumpWhenFalseOrPop, tail)
+			case "or":
+				err = c.compileLogicalExpression(expr, code.OpFalse, code.OpJumpWhenTrueOrPop, tail)
+			case "quote":
+				err = c.compileQuoteExpression(expr)
+			case "quasiquote":
+				err = c.compileQuasiquoteExpression(expr)
+			case "unquote", "unquote-splicing":
+				err = fmt.Errorf("%s: %s outside of quasiquote", expr.Pos(), expr.Fn.String())
+			case "try":
+				err = c.compileTryExpression(expr, tail)
+			case "catch", "finally":
+				err = fmt.Errorf("%s: %s clause outside of try expression", expr.Pos(), expr.Fn.String())
+			case "throw":
+				err = c.compileThrowExpression(expr)
 			default:
-				err = c.compileCallExpression(expr)
+				err = c.compileCallExpression(expr, tail)
 			}
 
 			if err != nil {
 				return err
 			}
 		}
+	case *ast.Import:
+		return c.compileImport(expr)
+	case *ast.BadExpression:
+		return fmt.Errorf("%s: cannot compile invalid expression %q", expr.Pos(), expr.String())
 	case *ast.FloatLiteral:
 		float := &object.Number{Value: expr.Value}
 
@@ -134,10 +207,24 @@ func (c *Compiler) Compile(expr ast.Expression) error {
 		case "null":
 			c.emit(code.OpNull)
 		default:
+			if object.IsKeyword(expr.String()) {
+				keyword := &object.Symbol{Name: expr.String()}
+
+				c.emit(code.OpConstant, c.addConstant(keyword))
+				return nil
+			}
+
 			sym, ok := c.symbolTable.Resolve(expr.Token.Literal)
 
+			// The body of a lambda runs only when it is called, by which time
+			// a later program, such as the next input of the repl, may have
+			// defined the global it refers to.
+			if !ok && c.lambdas > 0 {
+				sym, ok = c.symbolTable.global().Define(expr.Token.Literal), true
+			}
+
 			if !ok {
-				return fmt.Errorf("undefined variable %s", expr.Token.Literal)
+				return fmt.Errorf("%s: undefined variable %s", expr.Pos(), expr.Token.Literal)
 			}
 
 			c.getSymbol(sym)
@@ -158,6 +245,8 @@ func (c *Compiler) Bytecode() *Bytecode {
 	return &Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		GlobalNames:  c.symbolTable.GlobalNames(),
+		Lines:        c.scopes[c.scopeIndex].lines,
 	}
 }
 
@@ -177,6 +266,20 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	return pos
 }
 
+// Emit an instruction removing the value of the provided expression from the
+// stack, which is recorded as coming from the expression rather than the
+// expression around it.
+func (c *Compiler) emitPop(expr ast.Expression) {
+	outer := c.position
+
+	if pos := expr.Pos(); pos.IsValid() {
+		c.position = pos
+	}
+
+	c.emit(code.OpPop)
+	c.position = outer
+}
+
 // Set the value of the last instruction emitted in the current scope. Also
 // update the previous instruction emitted.
 func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
@@ -188,13 +291,18 @@ func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
 	}
 }
 
-// Append the provided instruction to the instructions of the current scope.
+// Append the provided instruction to the instructions of the current scope,
+// recording the position of the expression being compiled when it differs
+// from that of the previous instruction.
 func (c *Compiler) addInstruction(ins []byte) int {
 	posNewInstruction := len(c.currentInstructions())
+	scope := &c.scopes[c.scopeIndex]
+
+	if c.position.IsValid() && (len(scope.lines) == 0 || scope.lines[len(scope.lines)-1].Pos != c.position) {
+		scope.lines = append(scope.lines, object.LineEntry{Offset: posNewInstruction, Pos: c.position})
+	}
 
-	c.scopes[c.scopeIndex].instructions = append(
-		c.scopes[c.scopeIndex].instructions, ins...,
-	)
+	scope.instructions = append(scope.instructions, ins...)
 
 	return posNewInstruction
 }
@@ -222,10 +330,10 @@ func (c *Compiler) changeOperand(opPos int, operand int) {
 
 // Compile an if expression to instructions, adding in a false path if one is
 // not provided.
-func (c *Compiler) compileIfExpression(expr *ast.SExpression) error {
+func (c *Compiler) compileIfExpression(expr *ast.SExpression, tail bool) error {
 	// args should consist of condition, consequence, and optional alternative
 	if len(expr.Args) < 2 || len(expr.Args) > 3 {
-		return fmt.Errorf("incorrect number of values in if expression")
+		return fmt.Errorf("%s: incorrect number of values in if expression", expr.Pos())
 	}
 
 	condition := expr.Args[0]
@@ -242,7 +350,7 @@ func (c *Compiler) compileIfExpression(expr *ast.SExpression) error {
 
 	consequence := expr.Args[1]
 
-	err = c.Compile(consequence)
+	err = c.compile(consequence, tail)
 
 	if err != nil {
 		return err
@@ -264,7 +372,7 @@ func (c *Compiler) compileIfExpression(expr *ast.SExpression) error {
 	} else {
 		alternative := expr.Args[2]
 
-		err = c.Compile(alternative)
+		err = c.compile(alternative, tail)
 
 		if err != nil {
 			return err
@@ -279,18 +387,309 @@ func (c *Compiler) compileIfExpression(expr *ast.SExpression) error {
 	return nil
 }
 
+// Compile the provided SExpression as a cond expression, of the form:
+//
+//	(cond (test body...) ... (else body...))
+//
+// Each test is followed by a jump to the next clause when it is false, and
+// each body by a jump to the end of the expression. A clause without a body
+// results in the value of its test when it is true. When no test is true, the
+// result is the else body, or null if there is no else clause.
+func (c *Compiler) compileCondExpression(expr *ast.SExpression, tail bool) error {
+	endJumps := []int{}
+	hasElse := false
+
+	for i, arg := range expr.Args {
+		clause, ok := arg.(*ast.SExpression)
+
+		if !ok || clause.Fn == nil {
+			return fmt.Errorf("%s: cond clause must be a non-empty list, got=%s", arg.Pos(), arg)
+		}
+
+		if isForm(clause, "else") {
+			if i != len(expr.Args)-1 {
+				return fmt.Errorf("%s: else must be the last clause of cond", clause.Pos())
+			}
+
+			hasElse = true
+
+			err := c.compileSequence(clause.Args, tail)
+
+			if err != nil {
+				return err
+			}
+
+			break
+		}
+
+		err := c.Compile(clause.Fn)
+
+		if err != nil {
+			return err
+		}
+
+		if len(clause.Args) == 0 {
+			// Keep the value of the test as the result when it is true.
+			endJumps = append(endJumps, c.emit(code.OpJumpWhenTrueOrPop, 9999))
+			continue
+		}
+
+		// Emit conditional jump with erroneous destination, to be updated to
+		// the start of the next clause once it is known.
+		nextJump := c.emit(code.OpJumpWhenFalse, 9999)
+
+		err = c.compileSequence(clause.Args, tail)
+
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		c.changeOperand(nextJump, len(c.currentInstructions()))
+	}
+
+	if !hasElse {
+		c.emit(code.OpNull)
+	}
+
+	end := len(c.currentInstructions())
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+// Compile the provided SExpression as a case expression, of the form:
+//
+//	(case key ((datum ...) body...) ... (else body...))
+//
+// The key is evaluated once, then compared with each datum in turn, jumping
+// to the body of the first clause containing an equal datum. The datums are
+// quoted data, which are never evaluated. The bodies are placed after all the
+// comparisons, starting with the else body, or null if there is no else
+// clause.
+func (c *Compiler) compileCaseExpression(expr *ast.SExpression, tail bool) error {
+	if len(expr.Args) < 1 {
+		return fmt.Errorf("%s: not enough arguments for case expression", expr.Pos())
+	}
+
+	err := c.Compile(expr.Args[0])
+
+	if err != nil {
+		return err
+	}
+
+	// The position of each comparison emitted, along with the datum it
+	// compares against, so the jump can be updated once its body is placed.
+	type datumJump struct {
+		pos      int
+		constant int
+	}
+
+	clauses := []*ast.SExpression{}
+	bodyJumps := [][]datumJump{}
+	var elseClause *ast.SExpression
+
+	for i, arg := range expr.Args[1:] {
+		clause, ok := arg.(*ast.SExpression)
+
+		if !ok || clause.Fn == nil {
+			return fmt.Errorf("%s: case clause must be a non-empty list, got=%s", arg.Pos(), arg)
+		}
+
+		if isForm(clause, "else") {
+			if i != len(expr.Args)-2 {
+				return fmt.Errorf("%s: else must be the last clause of case", clause.Pos())
+			}
+
+			elseClause = clause
+			break
+		}
+
+		datums, ok := clause.Fn.(*ast.SExpression)
+
+		if !ok {
+			return fmt.Errorf("%s: case datums must be a list, got=%s", clause.Fn.Pos(), clause.Fn)
+		}
+
+		jumps := []datumJump{}
+
+		if datums.Fn != nil {
+			for _, datum := range append([]ast.Expression{datums.Fn}, datums.Args...) {
+				constant := c.addConstant(object.Quote(datum))
+				pos := c.emit(code.OpJumpWhenEqual, constant, 9999)
+
+				jumps = append(jumps, datumJump{pos, constant})
+			}
+		}
+
+		clauses = append(clauses, clause)
+		bodyJumps = append(bodyJumps, jumps)
+	}
+
+	// No datum matched the key, so it is still on the stack.
+	c.emit(code.OpPop)
+
+	if elseClause != nil {
+		err = c.compileSequence(elseClause.Args, tail)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	endJumps := []int{c.emit(code.OpJump, 9999)}
+
+	for i, clause := range clauses {
+		start := len(c.currentInstructions())
+
+		for _, jump := range bodyJumps[i] {
+			c.replaceInstruction(jump.pos, code.Make(code.OpJumpWhenEqual, jump.constant, start))
+		}
+
+		err = c.compileSequence(clause.Args, tail)
+
+		if err != nil {
+			return err
+		}
+
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+	}
+
+	end := len(c.currentInstructions())
+
+	for _, pos := range endJumps {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
+// Compile the provided SExpression as a when expression, of the form
+// (when test body...), which results in its body when the test is true and
+// null otherwise. When unless is true, it is compiled as an unless
+// expression, which results in its body when the test is false instead.
+func (c *Compiler) compileWhenExpression(expr *ast.SExpression, unless bool, tail bool) error {
+	if len(expr.Args) < 1 {
+		return fmt.Errorf("%s: not enough arguments for %s expression", expr.Pos(), expr.Fn)
+	}
+
+	err := c.Compile(expr.Args[0])
+
+	if err != nil {
+		return err
+	}
+
+	// Emit jumps with erroneous destinations, to be updated to the start of
+	// the second branch and the end of the expression once they are known.
+	conditionalJumpPos := c.emit(code.OpJumpWhenFalse, 9999)
+
+	if unless {
+		c.emit(code.OpNull)
+	} else {
+		err = c.compileSequence(expr.Args[1:], tail)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(conditionalJumpPos, len(c.currentInstructions()))
+
+	if unless {
+		err = c.compileSequence(expr.Args[1:], tail)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		c.emit(code.OpNull)
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// Compile the provided expressions in order, removing the result of each
+// from the stack except for the last, so that the sequence results in the
+// value of the last expression, or null if there are none.
+func (c *Compiler) compileSequence(exprs []ast.Expression, tail bool) error {
+	if len(exprs) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	for i, e := range exprs {
+		err := c.compile(e, tail && i == len(exprs)-1)
+
+		if err != nil {
+			return err
+		}
+
+		if i < len(exprs)-1 {
+			c.emitPop(e)
+		}
+	}
+
+	return nil
+}
+
+// Compile the provided SExpression as an and/or expression, which evaluates
+// its arguments in order until one decides the result, and results in the
+// last value evaluated.
+//
+// empty is the Opcode for the result when there are no arguments, and jump
+// is the conditional jump used to skip the remaining arguments once the
+// result is decided.
+func (c *Compiler) compileLogicalExpression(expr *ast.SExpression, empty code.Opcode, jump code.Opcode, tail bool) error {
+	if len(expr.Args) == 0 {
+		c.emit(empty)
+		return nil
+	}
+
+	jumpPositions := []int{}
+
+	for i, arg := range expr.Args {
+		err := c.compile(arg, tail && i == len(expr.Args)-1)
+
+		if err != nil {
+			return err
+		}
+
+		if i < len(expr.Args)-1 {
+			// Emit jump with erroneous destination, to be updated to the end
+			// of the expression once it is known.
+			jumpPositions = append(jumpPositions, c.emit(jump, 9999))
+		}
+	}
+
+	end := len(c.currentInstructions())
+
+	for _, pos := range jumpPositions {
+		c.changeOperand(pos, end)
+	}
+
+	return nil
+}
+
 // Compile the provided SExpression as a def expression, defining a variable
 // in the current scope as the result of the internal Expression provided as the
 // second argument.
 func (c *Compiler) compileDefExpression(expr *ast.SExpression) error {
 	if len(expr.Args) != 2 {
-		return fmt.Errorf("incorrect number of values in def expression")
+		return fmt.Errorf("%s: incorrect number of values in def expression", expr.Pos())
 	}
 
 	name, ok := expr.Args[0].(*ast.Identifier)
 
 	if !ok {
-		return fmt.Errorf("first argument to def must be identifier")
+		return fmt.Errorf("%s: first argument to def must be identifier", expr.Args[0].Pos())
 	}
 
 	symbol := c.symbolTable.Define(name.Token.Literal)
@@ -318,52 +717,89 @@ func (c *Compiler) compileDefExpression(expr *ast.SExpression) error {
 // Closure object (all lambdas are treated as closures).
 func (c *Compiler) compileLambdaExpression(expr *ast.SExpression) error {
 	if len(expr.Args) < 1 {
-		return fmt.Errorf("not enough arguments for lambda definition")
+		return fmt.Errorf("%s: not enough arguments for lambda definition", expr.Pos())
 	}
 
-	c.enterScope()
+	params, err := object.ParseParameters(expr.Args[0])
+
+	if err != nil {
+		return err
+	}
 
-	if expr.Name != "" {
-		c.symbolTable.DefineFunctionName(expr.Name)
+	if !expr.Inline {
+		c.lambdas++
+		defer func() { c.lambdas-- }()
 	}
 
-	paramList, ok := expr.Args[0].(*ast.SExpression)
+	lambda, err := c.compileLambda(expr.Name, params, expr.Args[1:], nil)
 
-	if !ok {
-		return fmt.Errorf("provided args must be a list")
+	if err != nil {
+		return err
+	}
+
+	lambda.Inline = expr.Inline
+
+	return nil
+}
+
+// Compile a lambda with the provided parameters and body in a new scope,
+// resulting in a Closure object.
+//
+// The name, if not empty, lets the body call the lambda recursively. locals
+// are defined in the new scope before the body is compiled, so that the body
+// can refer to them before the expressions that set them.
+//
+// Return the CompiledLambda, which the Closure is created from.
+func (c *Compiler) compileLambda(name string, params *object.Parameters, body []ast.Expression, locals []string) (*object.CompiledLambda, error) {
+	c.enterScope()
+
+	if name != "" {
+		c.symbolTable.DefineFunctionName(name)
 	}
 
-	params := []ast.Expression{}
+	for _, param := range params.Names {
+		c.symbolTable.Define(param)
+	}
 
-	if paramList.Fn != nil {
-		params = append([]ast.Expression{paramList.Fn}, paramList.Args...)
+	for _, local := range locals {
+		c.symbolTable.Define(local)
 	}
 
-	for _, p := range params {
-		param, ok := p.(*ast.Identifier)
+	// Set each parameter that has a default and was not passed an argument,
+	// in order, so that defaults can refer to the parameters before them.
+	for i, def := range params.Defaults {
+		if def == nil {
+			continue
+		}
+
+		jumpPos := c.emit(code.OpJumpWhenBound, i, 9999)
 
-		if !ok {
-			return fmt.Errorf("function parameters must be identifiers, got=%T(%+v)", p, params)
+		err := c.Compile(def)
+
+		if err != nil {
+			return nil, err
 		}
 
-		c.symbolTable.Define(param.String())
-	}
+		c.emit(code.OpSetLocal, i)
+		c.emit(code.OpPop)
 
-	expressions := expr.Args[1:]
+		c.replaceInstruction(jumpPos, code.Make(code.OpJumpWhenBound, i, len(c.currentInstructions())))
+	}
 
-	if len(expressions) == 0 {
+	if len(body) == 0 {
 		// Result in null when lambda contians no expressions.
 		c.emit(code.OpNull)
 		c.emit(code.OpReturn)
 	} else {
-		for _, arg := range expressions {
-			err := c.Compile(arg)
+		for i, arg := range body {
+			// The last expression of the body is in tail position.
+			err := c.compile(arg, i == len(body)-1)
 
 			if err != nil {
-				return err
+				return nil, err
 			}
 
-			c.emit(code.OpPop)
+			c.emitPop(arg)
 		}
 
 		// Change the final pop instruction into a return instruction.
@@ -377,21 +813,512 @@ func (c *Compiler) compileLambdaExpression(expr *ast.SExpression) error {
 	// so the values can be added to the produced Closure.
 	freeSymbols := c.symbolTable.FreeSymbols
 	localsCount := c.symbolTable.count
+	localNames := c.symbolTable.LocalNames()
+	lines := c.scopes[c.scopeIndex].lines
 	ins := c.leaveScope()
 
 	compiledLambda := &object.CompiledLambda{
-		Instructions:   ins,
-		LocalsCount:    localsCount,
-		ParameterCount: len(params),
+		Instructions: ins,
+		LocalsCount:  localsCount,
+		Signature:    params.Signature,
+		Name:         name,
+		Lines:        lines,
+		Captures:     captures(freeSymbols),
+		LocalNames:   localNames,
+		FreeNames:    freeNames(freeSymbols),
+	}
+
+	c.emit(code.OpClosure, c.addConstant(compiledLambda))
+
+	return compiledLambda, nil
+}
+
+// Describe where the variable of each of the provided free Symbols is found
+// in the enclosing scope, so that it can be captured by the Closure.
+func captures(freeSymbols []Symbol) []object.Capture {
+	captures := []object.Capture{}
+
+	for _, sym := range freeSymbols {
+		switch sym.Scope {
+		case LocalScope:
+			captures = append(captures, object.Capture{Scope: object.CaptureLocal, Index: sym.Index})
+		case FreeScope:
+			captures = append(captures, object.Capture{Scope: object.CaptureFree, Index: sym.Index})
+		case FunctionScope:
+			captures = append(captures, object.Capture{Scope: object.CaptureCurrentClosure})
+		}
 	}
 
-	// Put values associated with free symbols on the stack in front of the
-	// Closure.
+	return captures
+}
+
+// Return the name of each of the provided free Symbols, in order.
+func freeNames(freeSymbols []Symbol) []string {
+	names := []string{}
+
 	for _, sym := range freeSymbols {
-		c.getSymbol(sym)
+		names = append(names, sym.Name)
+	}
+
+	return names
+}
+
+// Compile the provided SExpression as a set! expression, of the form
+// (set! name value), which changes the value of an existing variable and
+// results in the new value.
+//
+// The variable can be a global, a local, or a free variable captured from an
+// enclosing scope, in which case the change is seen by the scope it was
+// captured from and every other Closure that captured it.
+func (c *Compiler) compileSetExpression(expr *ast.SExpression) error {
+	if len(expr.Args) != 2 {
+		return fmt.Errorf("%s: incorrect number of values in set! expression", expr.Pos())
+	}
+
+	name, ok := expr.Args[0].(*ast.Identifier)
+
+	if !ok {
+		return fmt.Errorf("%s: first argument to set! must be identifier", expr.Args[0].Pos())
+	}
+
+	sym, ok := c.symbolTable.Resolve(name.String())
+
+	// As when reading a variable, a lambda can set a global defined after
+	// it, which is an error only if the global is still undefined when the
+	// lambda sets it.
+	if !ok && c.lambdas > 0 {
+		sym, ok = c.symbolTable.global().Define(name.String()), true
+	}
+
+	if !ok {
+		return fmt.Errorf("%s: cannot set! undefined variable %s", name.Pos(), name)
+	}
+
+	// The name of the function being compiled refers to the function itself,
+	// but the variable it was defined as can still be set if it is global.
+	if sym.Scope == FunctionScope {
+		outer, ok := c.symbolTable.outer.Resolve(name.String())
+
+		if !ok || outer.Scope != GlobalScope {
+			return fmt.Errorf("%s: cannot set! %s within its own definition", name.Pos(), name)
+		}
+
+		sym = outer
+	}
+
+	if sym.Scope == BuiltinScope {
+		return fmt.Errorf("%s: cannot set! builtin %s", name.Pos(), name)
+	}
+
+	if sym.Scope == GlobalScope && c.symbolTable.IsImported(name.String()) {
+		return fmt.Errorf("%s: cannot set! imported variable %s", name.Pos(), name)
+	}
+
+	err := c.Compile(expr.Args[1])
+
+	if err != nil {
+		return err
+	}
+
+	switch sym.Scope {
+	case GlobalScope:
+		c.emit(code.OpAssignGlobal, sym.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, sym.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, sym.Index)
 	}
 
-	c.emit(code.OpClosure, c.addConstant(compiledLambda), len(freeSymbols))
+	return nil
+}
+
+// Compile the provided SExpression as a let expression, of the form:
+//
+//	(let ((name value) ...) body...)
+//
+// This is compiled as the equivalent call to a lambda:
+//
+//	((lambda (name ...) body...) value ...)
+//
+// so that the names are locals of a new scope, and are captured by closures in
+// the body like any other parameter. A named let, which takes the form
+// (let loop ((name value) ...) body...), can call itself from its body by the
+// provided name.
+func (c *Compiler) compileLetExpression(expr *ast.SExpression, tail bool) error {
+	if len(expr.Args) < 1 {
+		return fmt.Errorf("%s: not enough arguments for let expression", expr.Pos())
+	}
+
+	name := ""
+	bindings, body := expr.Args[0], expr.Args[1:]
+
+	if ident, ok := expr.Args[0].(*ast.Identifier); ok {
+		if len(expr.Args) < 2 {
+			return fmt.Errorf("%s: not enough arguments for named let expression", expr.Pos())
+		}
+
+		name = ident.String()
+		bindings, body = expr.Args[1], expr.Args[2:]
+	}
+
+	names, values, err := letBindings("let", bindings)
+
+	if err != nil {
+		return err
+	}
+
+	return c.compile(letCall(expr.Token, name, names, values, body), tail)
+}
+
+// Compile the provided SExpression as a let* expression, which is the same as
+// a let expression except that each value can refer to the names bound before
+// it. This is compiled as a let expression for each name, nested in order.
+func (c *Compiler) compileLetStarExpression(expr *ast.SExpression, tail bool) error {
+	if len(expr.Args) < 1 {
+		return fmt.Errorf("%s: not enough arguments for let* expression", expr.Pos())
+	}
+
+	names, values, err := letBindings("let*", expr.Args[0])
+
+	if err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return c.compile(letCall(expr.Token, "", nil, nil, expr.Args[1:]), tail)
+	}
+
+	body := expr.Args[1:]
+
+	for i := len(names) - 1; i >= 0; i-- {
+		body = []ast.Expression{
+			letCall(expr.Token, "", names[i:i+1], values[i:i+1], body),
+		}
+	}
+
+	return c.compile(body[0], tail)
+}
+
+// Compile the provided SExpression as a letrec expression, in which every
+// value can refer to any of the bound names, so that the lambdas bound can be
+// recursive.
+//
+// This is compiled as a call to a lambda without parameters, with the names
+// defined as locals before its body, which sets each name in order before
+// evaluating the body of the letrec expression.
+func (c *Compiler) compileLetrecExpression(expr *ast.SExpression, tail bool) error {
+	if len(expr.Args) < 1 {
+		return fmt.Errorf("%s: not enough arguments for letrec expression", expr.Pos())
+	}
+
+	names, values, err := letBindings("letrec", expr.Args[0])
+
+	if err != nil {
+		return err
+	}
+
+	locals := []string{}
+	body := []ast.Expression{}
+
+	for i, name := range names {
+		locals = append(locals, name.String())
+		body = append(body, &ast.SExpression{
+			Token: expr.Token,
+			Fn:    identifier("def", expr.Token.Pos),
+			Args:  []ast.Expression{name, values[i]},
+		})
+	}
+
+	lambda, err := c.compileLambda("", object.NewParameters(nil), append(body, expr.Args[1:]...), locals)
+
+	if err != nil {
+		return err
+	}
+
+	lambda.Inline = true
+
+	c.emitCall(0, tail)
+
+	return nil
+}
+
+// Split the bindings of a let expression, which take the form
+// ((name value) ...), into the bound names and their value expressions.
+func letBindings(form string, expr ast.Expression) ([]*ast.Identifier, []ast.Expression, error) {
+	list, ok := expr.(*ast.SExpression)
+
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: %s bindings must be a list, got=%s", expr.Pos(), form, expr)
+	}
+
+	names := []*ast.Identifier{}
+	values := []ast.Expression{}
+
+	if list.Fn == nil {
+		return names, values, nil
+	}
+
+	for _, b := range append([]ast.Expression{list.Fn}, list.Args...) {
+		binding, ok := b.(*ast.SExpression)
+
+		if !ok || len(binding.Args) != 1 {
+			return nil, nil, fmt.Errorf("%s: %s binding must be of the form (name value), got=%s", b.Pos(), form, b)
+		}
+
+		name, ok := binding.Fn.(*ast.Identifier)
+
+		if !ok {
+			return nil, nil, fmt.Errorf("%s: %s binding name must be identifier, got=%s", binding.Fn.Pos(), form, binding.Fn)
+		}
+
+		// let* is the only form that can bind the same name more than once,
+		// as each name is bound in its own scope.
+		if form != "let*" && slices.ContainsFunc(names, func(n *ast.Identifier) bool { return n.String() == name.String() }) {
+			return nil, nil, fmt.Errorf("%s: %s binds %s more than once", name.Pos(), form, name)
+		}
+
+		names = append(names, name)
+		values = append(values, binding.Args[0])
+	}
+
+	return names, values, nil
+}
+
+// Build the AST for a call to a lambda with the provided name, parameters and
+// body, passing in the provided arguments.
+func letCall(tok token.Token, name string, params []*ast.Identifier, args []ast.Expression, body []ast.Expression) *ast.SExpression {
+	paramList := &ast.SExpression{Token: tok}
+
+	if len(params) > 0 {
+		paramList.Fn = params[0]
+
+		for _, p := range params[1:] {
+			paramList.Args = append(paramList.Args, p)
+		}
+	}
+
+	lambda := &ast.SExpression{
+		Token:  tok,
+		Fn:     identifier("lambda", tok.Pos),
+		Args:   append([]ast.Expression{paramList}, body...),
+		Name:   name,
+		Inline: name == "",
+	}
+
+	return &ast.SExpression{
+		Token: tok,
+		Fn:    lambda,
+		Args:  args,
+	}
+}
+
+// Build an Identifier with the provided name at the provided position.
+func identifier(name string, pos token.Position) *ast.Identifier {
+	return &ast.Identifier{
+		Token: token.Token{
+			Type:    token.IDENT,
+			Literal: name,
+			Pos:     pos,
+		},
+	}
+}
+
+// Compile the provided SExpression as a try expression, of the form:
+//
+//	(try body... (catch name handler...) (finally cleanup...))
+//
+// where at least one of the catch and finally clauses is provided.
+//
+// The body is wrapped in OpTry and OpEndTry, so that an error in the body
+// jumps to the handler with the stack as it was before the body. The catch
+// handler is compiled as a call to a lambda taking the caught value as name.
+// The cleanup is compiled twice: once on the path where the body and handler
+// succeed, and once on the path where they fail, which throws the caught
+// value again afterwards.
+func (c *Compiler) compileTryExpression(expr *ast.SExpression, tail bool) error {
+	body, catch, finally, err := tryClauses(expr)
+
+	if err != nil {
+		return err
+	}
+
+	// A tail call from the body would leave the try before the call is made,
+	// so only the catch handler can be in tail position, and only without a
+	// cleanup to run after it.
+	tail = tail && finally == nil
+
+	var finallyPos int
+
+	if finally != nil {
+		finallyPos = c.emit(code.OpTry, 9999)
+	}
+
+	if catch != nil {
+		catchPos := c.emit(code.OpTry, 9999)
+
+		err = c.compileSequence(body, false)
+
+		if err != nil {
+			return err
+		}
+
+		c.emit(code.OpEndTry)
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(catchPos, len(c.currentInstructions()))
+
+		params := object.NewParameters([]string{catch.Args[0].String()})
+		handler, err := c.compileLambda("", params, catch.Args[1:], nil)
+
+		if err != nil {
+			return err
+		}
+
+		handler.Inline = true
+
+		c.emit(code.OpCaught)
+		c.emitCall(1, tail)
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	} else {
+		err = c.compileSequence(body, false)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if finally == nil {
+		return nil
+	}
+
+	c.emit(code.OpEndTry)
+
+	// The cleanup runs after the result of the try, which is kept beneath
+	// it on the stack.
+	err = c.compileSequence(finally.Args, false)
+
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpPop)
+	jumpPos := c.emit(code.OpJump, 9999)
+
+	// When the body or handler fail, the caught value is kept beneath the
+	// cleanup on the stack, then thrown again.
+	c.changeOperand(finallyPos, len(c.currentInstructions()))
+	c.emit(code.OpCaught)
+
+	err = c.compileSequence(finally.Args, false)
+
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpPop)
+	c.emit(code.OpThrow)
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	return nil
+}
+
+// Split the arguments of a try expression into its body and its catch and
+// finally clauses, which must come after the body in that order. The clauses
+// are nil when not provided.
+func tryClauses(expr *ast.SExpression) ([]ast.Expression, *ast.SExpression, *ast.SExpression, error) {
+	body := []ast.Expression{}
+	var catch, finally *ast.SExpression
+
+	for _, arg := range expr.Args {
+		clause, ok := arg.(*ast.SExpression)
+
+		switch {
+		case ok && isForm(clause, "catch"):
+			if catch != nil || finally != nil {
+				return nil, nil, nil, fmt.Errorf("%s: catch clause must come before finally and only once in try expression", clause.Pos())
+			}
+
+			if len(clause.Args) < 1 {
+				return nil, nil, nil, fmt.Errorf("%s: catch clause must be of the form (catch name body...)", clause.Pos())
+			}
+
+			if _, ok := clause.Args[0].(*ast.Identifier); !ok {
+				return nil, nil, nil, fmt.Errorf("%s: catch clause must be of the form (catch name body...)", clause.Pos())
+			}
+
+			catch = clause
+		case ok && isForm(clause, "finally"):
+			if finally != nil {
+				return nil, nil, nil, fmt.Errorf("%s: finally clause must come last and only once in try expression", clause.Pos())
+			}
+
+			finally = clause
+		case catch != nil || finally != nil:
+			return nil, nil, nil, fmt.Errorf("%s: catch and finally clauses must be at the end of try expression", arg.Pos())
+		default:
+			body = append(body, arg)
+		}
+	}
+
+	if catch == nil && finally == nil {
+		return nil, nil, nil, fmt.Errorf("%s: try expression must have a catch or finally clause", expr.Pos())
+	}
+
+	return body, catch, finally, nil
+}
+
+// Compile the provided SExpression as a throw expression, which throws the
+// value of its argument as an error.
+func (c *Compiler) compileThrowExpression(expr *ast.SExpression) error {
+	if len(expr.Args) != 1 {
+		return fmt.Errorf("%s: incorrect number of values in throw expression", expr.Pos())
+	}
+
+	err := c.Compile(expr.Args[0])
+
+	if err != nil {
+		return err
+	}
+
+	c.emit(code.OpThrow)
+
+	return nil
+}
+
+// Compile an import of a module, making its exports available as
+// namespace/name and resulting in the namespace as a symbol.
+//
+// The first import of a module in the program compiles the module in place,
+// with a global SymbolTable of its own, so that the module runs once, when it
+// is first imported. Later imports only refer to the globals it defined.
+func (c *Compiler) compileImport(imp *ast.Import) error {
+	module, ok := c.symbolTable.Module(imp.Module.Path)
+
+	if !ok {
+		module = c.symbolTable.NewModuleSymbolTable()
+
+		importer := c.symbolTable
+		c.symbolTable = module
+		err := c.Compile(imp.Module.Program)
+		c.symbolTable = importer
+
+		if err != nil {
+			return err
+		}
+
+		c.symbolTable.SetModule(imp.Module.Path, module)
+	}
+
+	for _, export := range imp.Module.Exports {
+		if !c.symbolTable.DefineImport(imp.Namespace, module, export.String()) {
+			return fmt.Errorf("%s: module %s exports undefined variable %s", export.Pos(), imp.Module.Path, export)
+		}
+	}
+
+	namespace := &object.Symbol{Name: imp.Namespace}
+	c.emit(code.OpConstant, c.addConstant(namespace))
 
 	return nil
 }
@@ -399,7 +1326,7 @@ func (c *Compiler) compileLambdaExpression(expr *ast.SExpression) error {
 // Compile the provided SExpression as a call to a function, resulting in a call
 // instruction with an operand representing the number of arguments passed in,
 // which sit on the stack above the function to be called.
-func (c *Compiler) compileCallExpression(expr *ast.SExpression) error {
+func (c *Compiler) compileCallExpression(expr *ast.SExpression, tail bool) error {
 	err := c.Compile(expr.Fn)
 
 	if err != nil {
@@ -414,7 +1341,135 @@ func (c *Compiler) compileCallExpression(expr *ast.SExpression) error {
 		}
 	}
 
-	c.emit(code.OpCall, len(expr.Args))
+	c.emitCall(len(expr.Args), tail)
+
+	return nil
+}
+
+// Emit a call instruction with the provided number of arguments, which is a
+// tail call when the call is in tail position.
+func (c *Compiler) emitCall(argCount int, tail bool) {
+	if tail {
+		c.emit(code.OpTailCall, argCount)
+	} else {
+		c.emit(code.OpCall, argCount)
+	}
+}
+
+// Compile the provided SExpression as a quote expression, placing the data
+// represented by its argument on the stack.
+func (c *Compiler) compileQuoteExpression(expr *ast.SExpression) error {
+	if len(expr.Args) != 1 {
+		return fmt.Errorf("%s: incorrect number of values in quote expression", expr.Pos())
+	}
+
+	c.emitQuoted(object.Quote(expr.Args[0]))
+
+	return nil
+}
+
+// Emit the instruction that places an already quoted Object on the stack.
+func (c *Compiler) emitQuoted(obj object.Object) {
+	switch obj {
+	case object.TRUE:
+		c.emit(code.OpTrue)
+	case object.FALSE:
+		c.emit(code.OpFalse)
+	case object.NULL:
+		c.emit(code.OpNull)
+	default:
+		c.emit(code.OpConstant, c.addConstant(obj))
+	}
+}
+
+// Compile the provided SExpression as a quasiquote expression, which builds
+// the data represented by its argument while evaluating the unquoted parts.
+func (c *Compiler) compileQuasiquoteExpression(expr *ast.SExpression) error {
+	if len(expr.Args) != 1 {
+		return fmt.Errorf("%s: incorrect number of values in quasiquote expression", expr.Pos())
+	}
+
+	return c.compileQuasiquote(expr.Args[0], 1)
+}
+
+// Recursively compile a quasiquoted expression.
+//
+// depth is the number of quasiquotes the expression is nested within, less
+// the number of unquotes. Unquoted expressions are only compiled as code when
+// they bring the depth back to 0, otherwise they are kept as data.
+//
+// Lists are built by calling the list builtin for each run of elements, then
+// joining the runs with any spliced lists by calling the concat builtin.
+func (c *Compiler) compileQuasiquote(expr ast.Expression, depth int) error {
+	sExpr, ok := expr.(*ast.SExpression)
+
+	if !ok || sExpr.Fn == nil {
+		c.emitQuoted(object.Quote(expr))
+		return nil
+	}
+
+	switch {
+	case isForm(sExpr, "unquote"), isForm(sExpr, "unquote-splicing"):
+		if len(sExpr.Args) != 1 {
+			return fmt.Errorf("%s: incorrect number of values in %s expression", sExpr.Pos(), sExpr.Fn.String())
+		}
+
+		if depth == 1 {
+			if isForm(sExpr, "unquote-splicing") {
+				return fmt.Errorf("%s: unquote-splicing used outside of a list", sExpr.Pos())
+			}
+
+			return c.Compile(sExpr.Args[0])
+		}
+
+		depth--
+	case isForm(sExpr, "quasiquote"):
+		depth++
+	}
+
+	elements := append([]ast.Expression{sExpr.Fn}, sExpr.Args...)
+
+	c.emit(code.OpGetBuiltin, builtinIndex("concat"))
+
+	segments := 0
+	runLength := 0
+
+	c.emit(code.OpGetBuiltin, builtinIndex("list"))
+
+	for _, element := range elements {
+		if spliced, ok := element.(*ast.SExpression); ok && depth == 1 && isForm(spliced, "unquote-splicing") {
+			if len(spliced.Args) != 1 {
+				return fmt.Errorf("%s: incorrect number of values in unquote-splicing expression", spliced.Pos())
+			}
+
+			// Finish the current run, then place the spliced list after it.
+			c.emit(code.OpCall, runLength)
+
+			err := c.Compile(spliced.Args[0])
+
+			if err != nil {
+				return err
+			}
+
+			segments += 2
+			runLength = 0
+
+			c.emit(code.OpGetBuiltin, builtinIndex("list"))
+
+			continue
+		}
+
+		err := c.compileQuasiquote(element, depth)
+
+		if err != nil {
+			return err
+		}
+
+		runLength++
+	}
+
+	c.emit(code.OpCall, runLength)
+	c.emit(code.OpCall, segments+1)
 
 	return nil
 }
@@ -465,3 +1520,24 @@ func (c *Compiler) getSymbol(sym Symbol) {
 		c.emit(code.OpCurrentClosure)
 	}
 }
+
+// Check whether the SExpression is a special form with the given name, such
+// as (unquote x).
+func isForm(expr *ast.SExpression, name string) bool {
+	ident, ok := expr.Fn.(*ast.Identifier)
+
+	return ok && ident.String() == name
+}
+
+// Return the index of the named builtin function. Used when the compiler
+// needs to call a builtin directly, regardless of what the name is bound to
+// in the program.
+func builtinIndex(name string) int {
+	for i, builtin := range object.Builtins {
+		if builtin.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -7,6 +7,7 @@ import (
 	"lisp/lexer"
 	"lisp/object"
 	"lisp/parser"
+	"lisp/token"
 	"slices"
 	"testing"
 )
@@ -21,6 +22,13 @@ type compilerTestCase struct {
 	expectedInstructions []code.Instructions
 }
 
+// The expected instructions and captured variables of a CompiledLambda
+// constant, for lambdas that capture variables from their enclosing scope.
+type compiledLambda struct {
+	instructions []code.Instructions
+	captures     []object.Capture
+}
+
 // Ensure integer and float literals are comiled correctly.
 func TestNumberLiterals(t *testing.T) {
 	tests := []compilerTestCase{
@@ -136,6 +144,169 @@ func TestConditionals(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+// Ensure and/or compile to jumps that skip the remaining arguments once the
+// result is decided.
+func TestAndOrExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "(and)",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(and 1 2 3)",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpWhenFalseOrPop, 15),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpJumpWhenFalseOrPop, 15),
+				// 0012
+				code.Make(code.OpConstant, 2),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(or false 1)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpWhenTrueOrPop, 7),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Test that multi-way conditionals compile to chains of jumps.
+func TestMultiWayConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "(cond (false 1) (else 2))",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpFalse),
+				// 0001
+				code.Make(code.OpJumpWhenFalse, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 13),
+				// 0010
+				code.Make(code.OpConstant, 1),
+				// 0013
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(cond (1) (2 3))",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpWhenTrueOrPop, 19),
+				// 0006
+				code.Make(code.OpConstant, 1),
+				// 0009
+				code.Make(code.OpJumpWhenFalse, 18),
+				// 0012
+				code.Make(code.OpConstant, 2),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(case 1 ((1 2) 'a) (else 'b))",
+			expectedConstants: []interface{}{
+				1, 1, 2, &object.Symbol{Name: "b"}, &object.Symbol{Name: "a"},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJumpWhenEqual, 1, 20),
+				// 0008
+				code.Make(code.OpJumpWhenEqual, 2, 20),
+				// 0013
+				code.Make(code.OpPop),
+				// 0014
+				code.Make(code.OpConstant, 3),
+				// 0017
+				code.Make(code.OpJump, 26),
+				// 0020
+				code.Make(code.OpConstant, 4),
+				// 0023
+				code.Make(code.OpJump, 26),
+				// 0026
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(when true 1 2)",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpWhenFalse, 14),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpConstant, 1),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(unless true 1)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpWhenFalse, 8),
+				// 0004
+				code.Make(code.OpNull),
+				// 0005
+				code.Make(code.OpJump, 11),
+				// 0008
+				code.Make(code.OpConstant, 0),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 // Test that variables defined in the global scope are compiled correctly.
 func TestGlobalDefExpressions(t *testing.T) {
 	tests := []compilerTestCase{
@@ -172,6 +343,26 @@ func TestGlobalDefExpressions(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			// Globals are declared before any expression is compiled, so
+			// they can be referred to before their definition.
+			input: "(def a (lambda () b)) (def b 10)",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetGlobal, 1),
+					code.Make(code.OpReturn),
+				},
+				10,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
 	}
 
 	runCompilerTests(t, tests)
@@ -197,7 +388,7 @@ func TestLocalDefExpressions(t *testing.T) {
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -218,7 +409,7 @@ func TestLocalDefExpressions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -244,7 +435,7 @@ func TestLocalDefExpressions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -282,7 +473,7 @@ func TestLambdaExpressions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -299,7 +490,7 @@ func TestLambdaExpressions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -312,7 +503,175 @@ func TestLambdaExpressions(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(lambda (a &optional (b 2)) b)",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpJumpWhenBound, 1, 10),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Test that set! compiles to the set instruction for the scope of the
+// variable, including free variables captured from an enclosing scope and
+// globals defined after the lambda setting them.
+func TestSetExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "(def a 1) (set! a 2)",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAssignGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(lambda (a) (set! a 2))",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// A lambda can set a global that has not been defined yet.
+			input: "(lambda () (set! a 2))",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAssignGlobal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(lambda (a) (lambda () (set! a 2)))",
+			expectedConstants: []interface{}{
+				2,
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpSetFree, 0),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 0},
+					},
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Test that let expressions compile to calls of lambdas that take the bound
+// names as parameters, and that letrec defines its names before its values.
+func TestLetExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "(let ((a 1) (b 2)) (+ a b))",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpTailCall, 2),
+					code.Make(code.OpReturn),
+				},
+				1,
+				2,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(let* ((a 1) (b a)) b)",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturn),
+				},
+				1,
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "(letrec ((a 1) (b a)) b)",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpGetLocal, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -334,7 +693,7 @@ func TestLambdaCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpCall, 0),
 				code.Make(code.OpPop),
 			},
@@ -349,7 +708,7 @@ func TestLambdaCalls(t *testing.T) {
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 0),
@@ -370,7 +729,7 @@ func TestLambdaCalls(t *testing.T) {
 				9,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 0),
@@ -396,7 +755,7 @@ func TestLambdaCalls(t *testing.T) {
 				1, 2, 3,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpClosure, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 0),
@@ -412,6 +771,150 @@ func TestLambdaCalls(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+// Test that calls in tail position of a lambda body, including through the
+// branches of an if expression, compile to tail calls, while calls for the
+// arguments of another call and calls outside of any lambda do not.
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "(lambda (f) (if f (f) (+ 1 (f))))",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpJumpWhenFalse, 12),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpTailCall, 0),
+					code.Make(code.OpJump, 23),
+					code.Make(code.OpGetBuiltin, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpTailCall, 2),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(+ 1 2)",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Test that try expressions wrap their body in a handler that jumps to the
+// catch handler or cleanup, and that throw expressions throw their value.
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "(try 1 (catch e e))",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 10),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpJump, 16),
+				// 0010
+				code.Make(code.OpClosure, 1),
+				// 0013
+				code.Make(code.OpCaught),
+				// 0014
+				code.Make(code.OpCall, 1),
+				// 0016
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(try 1 (finally 2))",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTry, 14),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpEndTry),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpJump, 20),
+				// 0014
+				code.Make(code.OpCaught),
+				// 0015
+				code.Make(code.OpConstant, 2),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpThrow),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "(throw 1)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpThrow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// Calls in the body of a try are never tail calls, while the call
+			// to the catch handler is.
+			input: "(lambda (f) (try (f) (catch e e)))",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpReturn),
+				},
+				[]code.Instructions{
+					code.Make(code.OpTry, 11),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCall, 0),
+					code.Make(code.OpEndTry),
+					code.Make(code.OpJump, 17),
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpCaught),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
 // Test that references to builtin functions are compiled correctly.
 func TestBuiltinReferences(t *testing.T) {
 	tests := []compilerTestCase{
@@ -447,12 +950,12 @@ func TestBuiltinReferences(t *testing.T) {
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpConstant, 1),
-					code.Make(code.OpCall, 2),
+					code.Make(code.OpTailCall, 2),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -471,21 +974,25 @@ func TestClosures(t *testing.T) {
                 (+ a b)))
             `,
 			expectedConstants: []interface{}{
-				[]code.Instructions{
-					code.Make(code.OpGetBuiltin, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpCall, 2),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetBuiltin, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpTailCall, 2),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 0},
+					},
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 0, 1),
+					code.Make(code.OpClosure, 0),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpPop),
 			},
 		},
@@ -497,28 +1004,36 @@ func TestClosures(t *testing.T) {
                   (+ a b c))))
             `,
 			expectedConstants: []interface{}{
-				[]code.Instructions{
-					code.Make(code.OpGetBuiltin, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetFree, 1),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpCall, 3),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetBuiltin, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetFree, 1),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpTailCall, 3),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureFree, Index: 0},
+						{Scope: object.CaptureLocal, Index: 0},
+					},
 				},
-				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 0, 2),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpClosure, 0),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 0},
+					},
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpClosure, 1),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpPop),
 			},
 		},
@@ -538,33 +1053,41 @@ func TestClosures(t *testing.T) {
 				2,
 				3,
 				4,
-				[]code.Instructions{
-					code.Make(code.OpConstant, 3),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpPop),
-					code.Make(code.OpGetBuiltin, 0),
-					code.Make(code.OpGetGlobal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetFree, 1),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpCall, 4),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpConstant, 3),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpPop),
+						code.Make(code.OpGetBuiltin, 0),
+						code.Make(code.OpGetGlobal, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetFree, 1),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpTailCall, 4),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureFree, Index: 0},
+						{Scope: object.CaptureLocal, Index: 0},
+					},
 				},
-				[]code.Instructions{
-					code.Make(code.OpConstant, 2),
-					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpPop),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 4, 2),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpConstant, 2),
+						code.Make(code.OpSetLocal, 0),
+						code.Make(code.OpPop),
+						code.Make(code.OpClosure, 4),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 0},
+					},
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpPop),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpClosure, 5, 1),
+					code.Make(code.OpClosure, 5),
 					code.Make(code.OpReturn),
 				},
 			},
@@ -572,7 +1095,7 @@ func TestClosures(t *testing.T) {
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpClosure, 6, 0),
+				code.Make(code.OpClosure, 6),
 				code.Make(code.OpPop),
 			},
 		},
@@ -596,12 +1119,12 @@ func TestRecursiveClosures(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpCall, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 			},
@@ -622,22 +1145,22 @@ func TestRecursiveClosures(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpCall, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturn),
 				},
 				10,
 				[]code.Instructions{
-					code.Make(code.OpClosure, 1, 0),
+					code.Make(code.OpClosure, 1),
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpPop),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 3),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 0),
@@ -688,14 +1211,14 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0031
 					code.Make(code.OpCall, 1),
 					// 0033
-					code.Make(code.OpCall, 2),
+					code.Make(code.OpTailCall, 2),
 					// 0035
 					code.Make(code.OpReturn),
 				},
 				4,
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpClosure, 2),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 0),
@@ -725,7 +1248,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0002
 					code.Make(code.OpConstant, 0),
 					// 0005
-					code.Make(code.OpGetBuiltin, 16),
+					code.Make(code.OpGetBuiltin, 14),
 					// 0007
 					code.Make(code.OpGetLocal, 0),
 					// 0009
@@ -741,7 +1264,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0021
 					code.Make(code.OpCurrentClosure),
 					// 0022
-					code.Make(code.OpGetBuiltin, 14),
+					code.Make(code.OpGetBuiltin, 12),
 					// 0024
 					code.Make(code.OpGetLocal, 0),
 					// 0026
@@ -753,7 +1276,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0032
 					code.Make(code.OpGetLocal, 2),
 					// 0034
-					code.Make(code.OpGetBuiltin, 13),
+					code.Make(code.OpGetBuiltin, 11),
 					// 0036
 					code.Make(code.OpGetLocal, 0),
 					// 0038
@@ -761,61 +1284,59 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0040
 					code.Make(code.OpCall, 2),
 					// 0042
-					code.Make(code.OpCall, 3),
+					code.Make(code.OpTailCall, 3),
 					// 0044
 					code.Make(code.OpReturn),
 				},
-				[]code.Instructions{
-					code.Make(code.OpGetBuiltin, 17),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpCall, 1),
-					code.Make(code.OpCall, 2),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetBuiltin, 15),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetLocal, 1),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpTailCall, 2),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 1},
+					},
 				},
+				[]interface{}{},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpClosure, 2, 1),
+					code.Make(code.OpClosure, 2),
 					code.Make(code.OpSetLocal, 2),
 					code.Make(code.OpPop),
 					code.Make(code.OpGetGlobal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpGetLocal, 2),
-					code.Make(code.OpGetBuiltin, 11),
-					code.Make(code.OpCall, 0),
-					code.Make(code.OpCall, 3),
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpTailCall, 3),
 					code.Make(code.OpReturn),
 				},
-				1,
-				2,
-				3,
+				[]interface{}{1, 2, 3},
 				2,
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 1),
-					code.Make(code.OpConstant, 7),
+					code.Make(code.OpConstant, 6),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpCall, 2),
+					code.Make(code.OpTailCall, 2),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 4),
 				code.Make(code.OpSetGlobal, 1),
 				code.Make(code.OpPop),
-				code.Make(code.OpGetBuiltin, 11),
-				code.Make(code.OpConstant, 4),
 				code.Make(code.OpConstant, 5),
-				code.Make(code.OpConstant, 6),
-				code.Make(code.OpCall, 3),
 				code.Make(code.OpSetGlobal, 2),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 1),
 				code.Make(code.OpGetGlobal, 2),
-				code.Make(code.OpClosure, 8, 0),
+				code.Make(code.OpClosure, 7),
 				code.Make(code.OpCall, 2),
 				code.Make(code.OpPop),
 			},
@@ -839,7 +1360,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0002
 					code.Make(code.OpConstant, 0),
 					// 0005
-					code.Make(code.OpGetBuiltin, 16),
+					code.Make(code.OpGetBuiltin, 14),
 					// 0007
 					code.Make(code.OpGetLocal, 0),
 					// 0009
@@ -855,7 +1376,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0021
 					code.Make(code.OpCurrentClosure),
 					// 0022
-					code.Make(code.OpGetBuiltin, 14),
+					code.Make(code.OpGetBuiltin, 12),
 					// 0024
 					code.Make(code.OpGetLocal, 0),
 					// 0026
@@ -867,7 +1388,7 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0032
 					code.Make(code.OpGetLocal, 2),
 					// 0034
-					code.Make(code.OpGetBuiltin, 13),
+					code.Make(code.OpGetBuiltin, 11),
 					// 0036
 					code.Make(code.OpGetLocal, 0),
 					// 0038
@@ -875,58 +1396,56 @@ func TestRecursiveClosures(t *testing.T) {
 					// 0040
 					code.Make(code.OpCall, 2),
 					// 0042
-					code.Make(code.OpCall, 3),
+					code.Make(code.OpTailCall, 3),
 					// 0044
 					code.Make(code.OpReturn),
 				},
-				[]code.Instructions{
-					code.Make(code.OpGetBuiltin, 17),
-					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpCall, 1),
-					code.Make(code.OpCall, 2),
-					code.Make(code.OpReturn),
+				compiledLambda{
+					instructions: []code.Instructions{
+						code.Make(code.OpGetBuiltin, 15),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpGetFree, 0),
+						code.Make(code.OpGetLocal, 1),
+						code.Make(code.OpCall, 1),
+						code.Make(code.OpTailCall, 2),
+						code.Make(code.OpReturn),
+					},
+					captures: []object.Capture{
+						{Scope: object.CaptureLocal, Index: 1},
+					},
 				},
+				[]interface{}{},
 				[]code.Instructions{
 					code.Make(code.OpGetGlobal, 0),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpGetLocal, 1),
-					code.Make(code.OpClosure, 2, 1),
-					code.Make(code.OpGetBuiltin, 11),
-					code.Make(code.OpCall, 0),
-					code.Make(code.OpCall, 3),
+					code.Make(code.OpClosure, 2),
+					code.Make(code.OpConstant, 3),
+					code.Make(code.OpTailCall, 3),
 					code.Make(code.OpReturn),
 				},
-				1,
-				2,
-				3,
+				[]interface{}{1, 2, 3},
 				2,
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 1),
-					code.Make(code.OpConstant, 7),
+					code.Make(code.OpConstant, 6),
 					code.Make(code.OpGetLocal, 0),
-					code.Make(code.OpCall, 2),
+					code.Make(code.OpTailCall, 2),
 					code.Make(code.OpReturn),
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpClosure, 1),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpPop),
-				code.Make(code.OpClosure, 3, 0),
+				code.Make(code.OpClosure, 4),
 				code.Make(code.OpSetGlobal, 1),
 				code.Make(code.OpPop),
-				code.Make(code.OpGetBuiltin, 11),
-				code.Make(code.OpConstant, 4),
 				code.Make(code.OpConstant, 5),
-				code.Make(code.OpConstant, 6),
-				code.Make(code.OpCall, 3),
 				code.Make(code.OpSetGlobal, 2),
 				code.Make(code.OpPop),
 				code.Make(code.OpGetGlobal, 1),
 				code.Make(code.OpGetGlobal, 2),
-				code.Make(code.OpClosure, 8, 0),
+				code.Make(code.OpClosure, 7),
 				code.Make(code.OpCall, 2),
 				code.Make(code.OpPop),
 			},
@@ -1049,6 +1568,153 @@ func runCompilerTests(t *testing.T, tests []compilerTestCase) {
 	}
 }
 
+// Ensure quoted data is compiled as constants, and that quasiquote builds
+// lists from runs of elements joined with spliced lists.
+func TestQuoting(t *testing.T) {
+	list := builtinIndex("list")
+	concat := builtinIndex("concat")
+
+	tests := []compilerTestCase{
+		{
+			input:             "'a",
+			expectedConstants: []interface{}{&object.Symbol{Name: "a"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "'(1 \"two\")",
+			expectedConstants: []interface{}{[]interface{}{1, "two"}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "`(1 ,(+ 1 1) ,@(list 3))",
+			expectedConstants: []interface{}{1, 1, 1, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, concat),
+				code.Make(code.OpGetBuiltin, list),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpCall, 2),
+				code.Make(code.OpGetBuiltin, list),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpGetBuiltin, list),
+				code.Make(code.OpCall, 0),
+				code.Make(code.OpCall, 3),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+// Ensure compiler errors report where in the source they occurred.
+func TestCompilerErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(def a 1)\n(+ a\n   b)", "test.lsp:3:4: undefined variable b"},
+		{"(if true)", "test.lsp:1:1: incorrect number of values in if expression"},
+		{"(def 1 2)", "test.lsp:1:6: first argument to def must be identifier"},
+		{"(let ((a 1)\n      (b)) b)", "test.lsp:2:7: let binding must be of the form (name value), got=(b)"},
+		{"(let ((a 1) (a 2)) a)", "test.lsp:1:14: let binds a more than once"},
+		{"(lambda (x x) x)", "test.lsp:1:12: duplicate parameter x"},
+		{"(lambda (a &rest) a)", "test.lsp:1:9: rest parameter must be named"},
+		{"(lambda (&key a &optional b) a)", "test.lsp:1:17: &optional is out of place in parameters"},
+		{"(set! a 1)", "test.lsp:1:7: cannot set! undefined variable a"},
+		{"(set! + 1)", "test.lsp:1:7: cannot set! builtin +"},
+		{"(cond (else 1) (true 2))", "test.lsp:1:7: else must be the last clause of cond"},
+		{"(case 1 (1 2))", "test.lsp:1:10: case datums must be a list, got=1"},
+		{"(let ((a 1)) a) a", "test.lsp:1:17: undefined variable a"},
+		{"(try 1)", "test.lsp:1:1: try expression must have a catch or finally clause"},
+		{"(try 1 (catch 2 3))", "test.lsp:1:8: catch clause must be of the form (catch name body...)"},
+		{"(try (finally 1) 2)", "test.lsp:1:18: catch and finally clauses must be at the end of try expression"},
+		{"(try 1 (finally 2) (catch e 3))", "test.lsp:1:20: catch clause must come before finally and only once in try expression"},
+		{"(catch e 1)", "test.lsp:1:1: catch clause outside of try expression"},
+		{"(throw)", "test.lsp:1:1: incorrect number of values in throw expression"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("test.lsp", tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		err := New().Compile(program)
+
+		if err == nil {
+			t.Fatalf("expected compiler error for %q, got none", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error: expected=%q got=%q", tt.expected, err)
+		}
+	}
+}
+
+// Test that lambdas record the name they are defined with and the position
+// in the source of their instructions, and that the lambdas used to run let
+// bodies are marked as inline.
+func TestNamesAndLineTables(t *testing.T) {
+	program := parse("(def f (lambda (x)\n  (+ x 1)))\n(let ((y 2)) y)")
+	compiler := New()
+
+	err := compiler.Compile(program)
+
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	bytecode := compiler.Bytecode()
+	f, ok := bytecode.Constants[1].(*object.CompiledLambda)
+
+	if !ok || f.Name != "f" || f.Inline {
+		t.Fatalf("constant 1 is not the lambda f: %+v", bytecode.Constants[1])
+	}
+
+	// The return at offset 9 replaces the pop of the last expression of the
+	// body, so it has the position of that expression rather than an entry
+	// of its own, and stepping through the lambda does not stop at its first
+	// line again as it returns.
+	expected := object.LineTable{
+		{Offset: 0, Pos: token.Position{Line: 2, Column: 4}},
+		{Offset: 2, Pos: token.Position{Line: 2, Column: 6}},
+		{Offset: 4, Pos: token.Position{Line: 2, Column: 8}},
+		{Offset: 7, Pos: token.Position{Line: 2, Column: 3}},
+	}
+
+	if !slices.Equal(f.Lines, expected) {
+		t.Errorf("wrong lines for f: want=%v got=%v", expected, f.Lines)
+	}
+
+	if pos := f.Lines.Lookup(8); pos != (token.Position{Line: 2, Column: 3}) {
+		t.Errorf("wrong position for the call in f: got=%s", pos)
+	}
+
+	if pos := f.Lines.Lookup(9); pos != (token.Position{Line: 2, Column: 3}) {
+		t.Errorf("wrong position for the return of f: got=%s", pos)
+	}
+
+	let, ok := bytecode.Constants[2].(*object.CompiledLambda)
+
+	if !ok || !let.Inline {
+		t.Fatalf("constant 2 is not the inline lambda of the let: %+v", bytecode.Constants[2])
+	}
+
+	if pos := bytecode.Lines.Lookup(0); pos != (token.Position{Line: 1, Column: 8}) {
+		t.Errorf("wrong position for the closure of f: got=%s", pos)
+	}
+}
+
 // Helper function for getting a parsed program for testing.
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
@@ -1116,6 +1782,25 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testStringObject failed: %s", i, err)
 			}
+		case *object.Symbol:
+			symbol, ok := actual[i].(*object.Symbol)
+
+			if !ok || symbol.Name != constant.Name {
+				return fmt.Errorf("constant %d - wrong symbol: want=%s got=%T(%+v)", i, constant.Name, actual[i], actual[i])
+			}
+			// Test that the constant List contains the expected values.
+		case []interface{}:
+			list, ok := actual[i].(*object.List)
+
+			if !ok {
+				return fmt.Errorf("constant %d - not a list: %T", i, actual[i])
+			}
+
+			err := testConstants(constant, list.Items())
+
+			if err != nil {
+				return fmt.Errorf("constant %d - testConstants failed: %s", i, err)
+			}
 			// Test that the constant CompiledLambda matches the expected instructions.
 		case []code.Instructions:
 			lambda, ok := actual[i].(*object.CompiledLambda)
@@ -1129,6 +1814,26 @@ func testConstants(
 			if err != nil {
 				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
 			}
+
+			if len(lambda.Captures) != 0 {
+				return fmt.Errorf("constant %d - unexpected captures: %+v", i, lambda.Captures)
+			}
+		case compiledLambda:
+			lambda, ok := actual[i].(*object.CompiledLambda)
+
+			if !ok {
+				return fmt.Errorf("constant %d - not a function: %T", i, actual[i])
+			}
+
+			err := testInstructions(constant.instructions, lambda.Instructions)
+
+			if err != nil {
+				return fmt.Errorf("constant %d - testInstructions failed: %s", i, err)
+			}
+
+			if !slices.Equal(lambda.Captures, constant.captures) {
+				return fmt.Errorf("constant %d - wrong captures: want=%+v got=%+v", i, constant.captures, lambda.Captures)
+			}
 		}
 	}
 
@@ -1,5 +1,7 @@
 package compiler
 
+import "maps"
+
 // The scope which the Symbol is defined for.
 type SymbolScope string
 
@@ -37,6 +39,16 @@ type SymbolTable struct {
 	count       int               // the number of Symbols in the store
 	outer       *SymbolTable      // address of enclosing SymbolTable
 	FreeSymbols []Symbol          // tracks variables required from enclosing scope
+	imports     map[string]Symbol // maps namespace/name to the global Symbol exported by a module
+	program     *programSymbols   // shared by the global SymbolTables of every module in the program
+}
+
+// programSymbols holds the state shared by the global SymbolTable of each
+// module in a program, which all define their globals in the same globals
+// of the VM.
+type programSymbols struct {
+	globals []string                // the name of each global defined by every module, by index
+	modules map[string]*SymbolTable // the global SymbolTable of each module compiled, by path
 }
 
 // Create a new empty SymbolTable.
@@ -44,6 +56,8 @@ func NewSymbolTable() *SymbolTable {
 	st := &SymbolTable{
 		store:       make(map[string]Symbol),
 		FreeSymbols: []Symbol{},
+		imports:     make(map[string]Symbol),
+		program:     &programSymbols{modules: make(map[string]*SymbolTable)},
 	}
 
 	return st
@@ -53,18 +67,129 @@ func NewSymbolTable() *SymbolTable {
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
 	st := NewSymbolTable()
 	st.outer = outer
+	st.program = outer.program
+	return st
+}
+
+// Create the global SymbolTable of a new module in the same program as this
+// SymbolTable, with the builtins defined. The globals the module defines are
+// separate from those of every other module, but share the globals of the VM.
+func (st *SymbolTable) NewModuleSymbolTable() *SymbolTable {
+	module := NewSymbolTable()
+	module.program = st.program
+
+	for name, sym := range st.global().store {
+		if sym.Scope == BuiltinScope {
+			module.store[name] = sym
+		}
+	}
+
+	return module
+}
+
+// symbolTableState is a snapshot of the definitions in a global SymbolTable
+// and the program it belongs to.
+type symbolTableState struct {
+	store   map[string]Symbol
+	count   int
+	imports map[string]Symbol
+	globals int
+	modules map[string]*SymbolTable
+}
+
+// Take a snapshot of the definitions in this global SymbolTable.
+func (st *SymbolTable) save() symbolTableState {
+	return symbolTableState{
+		store:   maps.Clone(st.store),
+		count:   st.count,
+		imports: maps.Clone(st.imports),
+		globals: len(st.program.globals),
+		modules: maps.Clone(st.program.modules),
+	}
+}
+
+// Return this global SymbolTable to a previously saved snapshot, removing the
+// globals defined and modules compiled since.
+func (st *SymbolTable) restore(state symbolTableState) {
+	st.store = state.store
+	st.count = state.count
+	st.imports = state.imports
+	st.program.globals = st.program.globals[:state.globals]
+	st.program.modules = state.modules
+}
+
+// Return the name of each global defined in the program, by index.
+func (st *SymbolTable) GlobalNames() []string {
+	return st.program.globals
+}
+
+// Return the global SymbolTable of the module compiled from the provided
+// path, if it has been compiled in this program.
+func (st *SymbolTable) Module(path string) (*SymbolTable, bool) {
+	module, ok := st.program.modules[path]
+	return module, ok
+}
+
+// Record the global SymbolTable of the module compiled from the provided path.
+func (st *SymbolTable) SetModule(path string, module *SymbolTable) {
+	st.program.modules[path] = module
+}
+
+// Make the global Symbol the provided name is defined as in the provided
+// module available in this SymbolTable as namespace/name. Report whether the
+// module defines the name as a global.
+func (st *SymbolTable) DefineImport(namespace string, module *SymbolTable, name string) bool {
+	sym, ok := module.store[name]
+
+	if !ok || sym.Scope != GlobalScope {
+		return false
+	}
+
+	st.global().imports[namespace+"/"+name] = sym
+
+	return true
+}
+
+// Report whether the provided name refers to a variable imported from another
+// module, rather than one defined by this module.
+func (st *SymbolTable) IsImported(name string) bool {
+	global := st.global()
+
+	_, defined := global.store[name]
+	_, imported := global.imports[name]
+
+	return !defined && imported
+}
+
+// Return the global SymbolTable enclosing this SymbolTable.
+func (st *SymbolTable) global() *SymbolTable {
+	for st.outer != nil {
+		st = st.outer
+	}
+
 	return st
 }
 
 // Define a symbol within the SymbolTable associated with the given identifier.
+//
+// Redefining an identifier already defined in this SymbolTable reuses its
+// index, so that code compiled before the redefinition refers to the same
+// value.
 func (st *SymbolTable) Define(s string) Symbol {
+	if sym, ok := st.store[s]; ok && (sym.Scope == GlobalScope || sym.Scope == LocalScope) {
+		return sym
+	}
+
 	sym := Symbol{
 		Name:  s,
 		Index: st.count,
 	}
 
 	if st.outer == nil {
+		// Globals are numbered across every module of the program.
 		sym.Scope = GlobalScope
+		sym.Index = len(st.program.globals)
+		st.program.globals = append(st.program.globals, s)
 	} else {
 		sym.Scope = LocalScope
 	}
@@ -75,6 +200,19 @@ func (st *SymbolTable) Define(s string) Symbol {
 	return sym
 }
 
+// Return the name of each local defined in the SymbolTable, by index.
+func (st *SymbolTable) LocalNames() []string {
+	names := make([]string, st.count)
+
+	for _, sym := range st.store {
+		if sym.Scope == LocalScope {
+			names[sym.Index] = sym.Name
+		}
+	}
+
+	return names
+}
+
 // Define a symbol within the SymbolTable associated with the provided builtin
 // function name.
 func (st *SymbolTable) DefineBuiltin(index int, name string) Symbol {
@@ -93,6 +231,10 @@ func (st *SymbolTable) DefineBuiltin(index int, name string) Symbol {
 func (st *SymbolTable) Resolve(s string) (sym Symbol, ok bool) {
 	sym, ok = st.store[s]
 
+	if !ok && st.outer == nil {
+		sym, ok = st.imports[s]
+	}
+
 	if !ok && st.outer != nil {
 		sym, ok = st.outer.Resolve(s)
 
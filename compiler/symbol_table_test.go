@@ -281,3 +281,52 @@ func TestShadowFunctionName(t *testing.T) {
 			expectedSymbol, sym)
 	}
 }
+
+// Test that each module has its own globals, numbered across the program, and
+// that only the names imported from a module resolve as namespace/name.
+func TestModuleSymbolTables(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "+")
+	global.Define("a")
+
+	module := global.NewModuleSymbolTable()
+	b := module.Define("b")
+	module.Define("c")
+
+	expected := Symbol{Name: "b", Scope: GlobalScope, Index: 1}
+
+	if b != expected {
+		t.Errorf("expected=%+v, got=%+v", expected, b)
+	}
+
+	if _, ok := module.Resolve("a"); ok {
+		t.Errorf("global a of another module resolved in module")
+	}
+
+	if _, ok := module.Resolve("+"); !ok {
+		t.Errorf("builtin + not resolved in module")
+	}
+
+	if !global.DefineImport("lib", module, "b") {
+		t.Fatalf("failed to import b")
+	}
+
+	if global.DefineImport("lib", module, "missing") {
+		t.Errorf("imported undefined name missing")
+	}
+
+	local := NewEnclosedSymbolTable(global)
+	sym, ok := local.Resolve("lib/b")
+
+	if !ok || sym != expected {
+		t.Errorf("expected lib/b to resolve to %+v, got=%+v", expected, sym)
+	}
+
+	if _, ok := local.Resolve("lib/c"); ok {
+		t.Errorf("name lib/c that was not imported resolved")
+	}
+
+	if !local.IsImported("lib/b") || local.IsImported("a") {
+		t.Errorf("wrong names reported as imported")
+	}
+}
@@ -0,0 +1,501 @@
+// The debugger package runs a program on the VM, stopping it at breakpoints
+// or step by step so that its functions and variables can be inspected.
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"lisp/ast"
+	"lisp/compiler"
+	"lisp/expander"
+	"lisp/lexer"
+	"lisp/object"
+	"lisp/parser"
+	"lisp/token"
+	"lisp/vm"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const PROMPT = "(debug) "
+
+// The error the program is stopped with when the debugger quits.
+var errQuit = errors.New("quit")
+
+// The ways execution can carry on from where it stopped.
+type mode int
+
+const (
+	// Stop only at breakpoints.
+	modeContinue mode = iota
+	// Stop at the next line, including those of the functions called.
+	modeStep
+	// Stop at the next line of the current function or its callers.
+	modeNext
+	// Stop once the current function returns.
+	modeOut
+)
+
+// A breakpoint stops execution when it reaches a line of the source, or
+// when a function is called.
+type breakpoint struct {
+	id       int
+	file     string // the file of the line, any file when empty
+	line     int
+	function string // the name of the function, empty for a line breakpoint
+}
+
+// Return where the breakpoint stops execution.
+func (b breakpoint) String() string {
+	switch {
+	case b.function != "":
+		return fmt.Sprintf("function %s", b.function)
+	case b.file != "":
+		return fmt.Sprintf("line %s:%d", b.file, b.line)
+	default:
+		return fmt.Sprintf("line %d", b.line)
+	}
+}
+
+// location is the function running at a depth of the call stack and the
+// position it last reached.
+type location struct {
+	name string
+	pos  token.Position
+}
+
+// Debugger runs a program on a VM, stopping where it is told to and reading
+// commands to inspect the program while it is stopped.
+type Debugger struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+	machine *vm.VM
+	source  map[string][]string // the lines of each source file read, by name
+
+	breakpoints []breakpoint
+	nextID      int
+	mode        mode
+
+	// The depth of the call stack when execution last stopped.
+	stopDepth int
+	// The location last reached at each depth of the call stack.
+	locations []location
+	// The functions running when execution last stopped, innermost first,
+	// and the index of the one commands refer to.
+	frames   []vm.CallFrame
+	selected int
+
+	// The state of the program, for compiling expressions to evaluate.
+	expander  *expander.Expander
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+}
+
+// Run the program in the provided source under a debugger, reading commands
+// from in and writing to out. Execution stops before the program starts, so
+// that breakpoints can be set.
+func Start(filename string, source string, in io.Reader, out io.Writer) {
+	l := lexer.NewWithFile(filename, source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	for _, d := range p.Diagnostics {
+		fmt.Fprintln(out, d)
+	}
+
+	if len(p.Errors()) > 0 {
+		return
+	}
+
+	d := &Debugger{
+		scanner:   bufio.NewScanner(in),
+		out:       out,
+		source:    map[string][]string{filename: strings.Split(source, "\n")},
+		nextID:    1,
+		mode:      modeStep,
+		expander:  expander.New(),
+		symbols:   compiler.NewSymbolTable(),
+		constants: []object.Object{},
+	}
+
+	for i, v := range object.Builtins {
+		d.symbols.DefineBuiltin(i, v.Name)
+	}
+
+	expanded, err := d.expander.Expand(program)
+
+	if err != nil {
+		fmt.Fprintf(out, "expander error: %s\n", err)
+		return
+	}
+
+	c := compiler.NewWithState(d.constants, d.symbols)
+	err = c.Compile(expanded)
+
+	if err != nil {
+		fmt.Fprintf(out, "compiler error: %s\n", err)
+		return
+	}
+
+	d.constants = c.Bytecode().Constants
+	d.machine = vm.New(c.Bytecode())
+	d.machine.SetHook(d.reached)
+
+	err = d.machine.Run()
+
+	if errors.Is(err, errQuit) {
+		return
+	}
+
+	if err != nil {
+		fmt.Fprintf(out, "vm error: %s\n", err)
+
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(out, runtimeErr.Trace)
+		}
+
+		return
+	}
+
+	fmt.Fprintf(out, "program finished: %s\n", d.machine.LastPoppedStackElem().Inspect())
+}
+
+// Called by the VM as execution reaches a new position. Stop when the
+// position is one the debugger was told to stop at, and read commands until
+// one carries on with execution.
+func (d *Debugger) reached() error {
+	frames := d.machine.CallFrames()
+	depth := len(frames)
+	current := location{name: frames[0].Name, pos: frames[0].Pos}
+
+	if !current.pos.IsValid() {
+		return nil
+	}
+
+	// A function is entered when the depth has not been reached since the
+	// function below it was, or when a tail call replaces the function at
+	// the depth. A line is arrived at when the function at the depth was at
+	// another line before.
+	var previous *location
+
+	if depth <= len(d.locations) {
+		previous = &d.locations[depth-1]
+	}
+
+	entered := previous == nil || previous.name != current.name
+	arrived := entered || previous.pos.Line != current.pos.Line || previous.pos.File != current.pos.File
+
+	d.locations = d.locations[:min(depth-1, len(d.locations))]
+
+	for len(d.locations) < depth-1 {
+		d.locations = append(d.locations, location{})
+	}
+
+	d.locations = append(d.locations, current)
+
+	if !d.shouldStop(depth, current, entered, arrived) {
+		return nil
+	}
+
+	d.stopDepth = depth
+	d.frames, d.selected = frames, 0
+
+	fmt.Fprintf(d.out, "stopped at %s\n", object.StackFrame{Name: current.name, Pos: current.pos})
+	d.printLine(current.pos)
+
+	return d.prompt()
+}
+
+// Report whether execution should stop at the provided location, reached at
+// the provided depth of the call stack.
+func (d *Debugger) shouldStop(depth int, current location, entered bool, arrived bool) bool {
+	for _, b := range d.breakpoints {
+		if b.function != "" {
+			if entered && current.name == b.function {
+				return true
+			}
+
+			continue
+		}
+
+		if arrived && current.pos.Line == b.line && (b.file == "" || b.file == current.pos.File) {
+			return true
+		}
+	}
+
+	switch d.mode {
+	case modeStep:
+		return arrived
+	case modeNext:
+		return arrived && depth <= d.stopDepth
+	case modeOut:
+		return depth < d.stopDepth
+	default:
+		return false
+	}
+}
+
+// Read and run commands until one carries on with execution. Return errQuit
+// when told to quit, or when there are no commands left.
+func (d *Debugger) prompt() error {
+	for {
+		fmt.Fprint(d.out, PROMPT)
+
+		if !d.scanner.Scan() {
+			return errQuit
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimSpace(d.scanner.Text()), " ")
+		arg = strings.TrimSpace(arg)
+
+		switch command {
+		case "":
+		case "continue", "c":
+			d.mode = modeContinue
+			return nil
+		case "step", "s":
+			d.mode = modeStep
+			return nil
+		case "next", "n":
+			d.mode = modeNext
+			return nil
+		case "out", "o":
+			d.mode = modeOut
+			return nil
+		case "quit", "q":
+			return errQuit
+		case "break", "b":
+			d.addBreakpoint(arg)
+		case "delete", "d":
+			d.deleteBreakpoint(arg)
+		case "breakpoints":
+			for _, b := range d.breakpoints {
+				fmt.Fprintf(d.out, "%d: %s\n", b.id, b)
+			}
+		case "backtrace", "bt":
+			d.printFrames()
+		case "frame", "f":
+			d.selectFrame(arg)
+		case "locals", "l":
+			d.printVariables()
+		case "print", "p":
+			d.evaluate(arg)
+		case "help", "h":
+			fmt.Fprint(d.out, help)
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, enter help for the commands\n", command)
+		}
+	}
+}
+
+const help = `continue, c          run until a breakpoint
+step, s              run to the next line, stepping into calls
+next, n              run to the next line, stepping over calls
+out, o               run until the current function returns
+break, b LOCATION    stop at a line, file:line or the calls of a function
+delete, d ID         remove a breakpoint
+breakpoints          list the breakpoints
+backtrace, bt        list the functions running, innermost first
+frame, f N           select the function to inspect, from backtrace
+locals, l            list the variables of the selected function
+print, p EXPRESSION  evaluate an expression in the selected function
+quit, q              stop the program and the debugger
+`
+
+// Add a breakpoint at the provided location, which is a line number, a file
+// and line number in the form file:line, or the name of a function.
+func (d *Debugger) addBreakpoint(arg string) {
+	if arg == "" {
+		fmt.Fprintln(d.out, "break needs a line, file:line or function name")
+		return
+	}
+
+	b := breakpoint{id: d.nextID}
+	file, line, found := strings.Cut(arg, ":")
+
+	if !found {
+		file, line = "", arg
+	}
+
+	if n, err := strconv.Atoi(line); err == nil && n > 0 {
+		b.file, b.line = file, n
+	} else {
+		b.function = arg
+	}
+
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, b)
+
+	fmt.Fprintf(d.out, "breakpoint %d at %s\n", b.id, b)
+}
+
+// Remove the breakpoint with the provided id.
+func (d *Debugger) deleteBreakpoint(arg string) {
+	id, err := strconv.Atoi(arg)
+	i := slices.IndexFunc(d.breakpoints, func(b breakpoint) bool { return b.id == id })
+
+	if err != nil || i == -1 {
+		fmt.Fprintf(d.out, "no breakpoint %s\n", arg)
+		return
+	}
+
+	d.breakpoints = slices.Delete(d.breakpoints, i, i+1)
+
+	fmt.Fprintf(d.out, "deleted breakpoint %d\n", id)
+}
+
+// Print the functions running, innermost first, marking the one selected.
+func (d *Debugger) printFrames() {
+	for i, frame := range d.frames {
+		marker := " "
+
+		if i == d.selected {
+			marker = "*"
+		}
+
+		fmt.Fprintf(d.out, "%s %d %s\n", marker, i, object.StackFrame{Name: frame.Name, Pos: frame.Pos})
+	}
+}
+
+// Select the function at the provided index of the backtrace.
+func (d *Debugger) selectFrame(arg string) {
+	i, err := strconv.Atoi(arg)
+
+	if err != nil || i < 0 || i >= len(d.frames) {
+		fmt.Fprintf(d.out, "no frame %s\n", arg)
+		return
+	}
+
+	d.selected = i
+	frame := d.frames[i]
+
+	fmt.Fprintf(d.out, "%d %s\n", i, object.StackFrame{Name: frame.Name, Pos: frame.Pos})
+	d.printLine(frame.Pos)
+}
+
+// Print the local and free variables of the selected function.
+func (d *Debugger) printVariables() {
+	frame := d.frames[d.selected]
+	locals, free := frame.Locals(), frame.Free()
+
+	if len(locals) == 0 && len(free) == 0 {
+		fmt.Fprintln(d.out, "no variables")
+		return
+	}
+
+	for _, v := range locals {
+		fmt.Fprintf(d.out, "%s = %s\n", v.Name, v.Value.Inspect())
+	}
+
+	for _, v := range free {
+		fmt.Fprintf(d.out, "%s = %s (free)\n", v.Name, v.Value.Inspect())
+	}
+}
+
+// Evaluate the expressions in the provided source in the selected function
+// and print the result.
+//
+// The expressions are compiled as the body of a lambda taking the variables
+// of the function as parameters, which is called with their values on a VM
+// sharing the globals of the program. Changes to globals are seen by the
+// program, but changes to the variables of the function are not.
+func (d *Debugger) evaluate(source string) {
+	l := lexer.New(source)
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) > 0 {
+		for _, e := range p.Errors() {
+			fmt.Fprintln(d.out, e)
+		}
+
+		return
+	}
+
+	expanded, err := d.expander.Expand(program)
+
+	if err != nil {
+		fmt.Fprintf(d.out, "expander error: %s\n", err)
+		return
+	}
+
+	frame := d.frames[d.selected]
+	params := &ast.SExpression{Token: token.Token{Type: token.LPAREN, Literal: "("}}
+	args := []object.Object{}
+
+	// Locals shadow the free variables of the same name.
+	variables := frame.Free()
+
+	for _, local := range frame.Locals() {
+		variables = slices.DeleteFunc(variables, func(v vm.Variable) bool { return v.Name == local.Name })
+		variables = append(variables, local)
+	}
+
+	for _, v := range variables {
+		name := &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: v.Name}}
+
+		if params.Fn == nil {
+			params.Fn = name
+		} else {
+			params.Args = append(params.Args, name)
+		}
+
+		args = append(args, v.Value)
+	}
+
+	lambda := &ast.SExpression{
+		Token: params.Token,
+		Fn:    &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: "lambda"}},
+		Args:  append([]ast.Expression{params}, expanded.Expressions...),
+	}
+
+	c := compiler.NewWithState(d.constants, d.symbols)
+	err = c.Compile(&ast.Program{Expressions: []ast.Expression{lambda}})
+
+	if err != nil {
+		fmt.Fprintf(d.out, "compiler error: %s\n", err)
+		return
+	}
+
+	d.constants = c.Bytecode().Constants
+	globals := d.machine.Globals()
+	machine := vm.NewWithState(c.Bytecode(), globals)
+
+	// The globals are extended in a copy when the expressions declare new
+	// ones, so changes made to those of the program are copied back to it.
+	defer func() { copy(globals, machine.Globals()) }()
+
+	err = machine.Run()
+
+	if err != nil {
+		fmt.Fprintf(d.out, "vm error: %s\n", err)
+		return
+	}
+
+	result := machine.Call(machine.LastPoppedStackElem(), args...)
+
+	fmt.Fprintln(d.out, result.Inspect())
+}
+
+// Print the line of the source at the provided position, if it can be read.
+func (d *Debugger) printLine(pos token.Position) {
+	lines, ok := d.source[pos.File]
+
+	if !ok {
+		contents, err := os.ReadFile(pos.File)
+
+		if err == nil {
+			lines = strings.Split(string(contents), "\n")
+		}
+
+		d.source[pos.File] = lines
+	}
+
+	if pos.Line <= len(lines) {
+		fmt.Fprintf(d.out, "%4d | %s\n", pos.Line, lines[pos.Line-1])
+	}
+}
@@ -0,0 +1,115 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const source = `(def scale 10)
+(def add (lambda (a b)
+  (let ((sum (+ a b)))
+    (* sum scale))))
+(def make-adder (lambda (n)
+  (lambda (x)
+    (list (add x n)))))
+((make-adder 5) 1)`
+
+// Test that the debugger stops at breakpoints and after each kind of step,
+// and shows the functions running, their variables, and the values of
+// expressions evaluated in them.
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		commands []string
+		expected string
+	}{
+		{
+			[]string{"c"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) program finished: (60)
+`,
+		},
+		{
+			[]string{"b add", "c", "bt", "l", "n", "l", "p (+ sum scale)", "f 1", "l", "p (* x n)", "c"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) breakpoint 1 at function add
+(debug) stopped at add (test.lsp:3:3)
+   3 |   (let ((sum (+ a b)))
+(debug) * 0 add (test.lsp:3:3)
+  1 lambda (test.lsp:7:11)
+  2 <main> (test.lsp:8:1)
+(debug) a = 1
+b = 5
+(debug) stopped at add (test.lsp:4:6)
+   4 |     (* sum scale))))
+(debug) sum = 6
+(debug) 16
+(debug) 1 lambda (test.lsp:7:11)
+   7 |     (list (add x n)))))
+(debug) x = 1
+n = 5 (free)
+(debug) 5
+(debug) program finished: (60)
+`,
+		},
+		{
+			[]string{"b 4", "c", "o", "bt", "s"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) breakpoint 1 at line 4
+(debug) stopped at add (test.lsp:4:6)
+   4 |     (* sum scale))))
+(debug) stopped at lambda (test.lsp:7:5)
+   7 |     (list (add x n)))))
+(debug) * 0 lambda (test.lsp:7:5)
+  1 <main> (test.lsp:8:1)
+(debug) program finished: (60)
+`,
+		},
+		{
+			[]string{"b add", "c", "p nothing", "p (set! scale 2)", "c"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) breakpoint 1 at function add
+(debug) stopped at add (test.lsp:3:3)
+   3 |   (let ((sum (+ a b)))
+(debug) ERROR: undefined variable nothing
+(debug) 2
+(debug) program finished: (12)
+`,
+		},
+		{
+			[]string{"q"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) `,
+		},
+		{
+			[]string{"b 2", "b nothing", "breakpoints", "d 1", "d 1", "breakpoints", "c"},
+			`stopped at <main> (test.lsp:1:12)
+   1 | (def scale 10)
+(debug) breakpoint 1 at line 2
+(debug) breakpoint 2 at function nothing
+(debug) 1: line 2
+2: function nothing
+(debug) deleted breakpoint 1
+(debug) no breakpoint 1
+(debug) 2: function nothing
+(debug) program finished: (60)
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		in := strings.NewReader(strings.Join(tt.commands, "\n") + "\n")
+		var out bytes.Buffer
+
+		Start("test.lsp", source, in, &out)
+
+		if out.String() != tt.expected {
+			t.Errorf("wrong output for %q:\nwant=%s\ngot=%s", tt.commands, tt.expected, out.String())
+		}
+	}
+}
@@ -7,28 +7,50 @@ import (
 
 // A map of all the built in functions in the interpreter
 var builtins = map[string]*object.FunctionObject{
-	"+":     object.GetBuiltinByName("+"),
-	"*":     object.GetBuiltinByName("*"),
-	"-":     object.GetBuiltinByName("-"),
-	"/":     object.GetBuiltinByName("/"),
-	"rem":   object.GetBuiltinByName("rem"),
-	"=":     object.GetBuiltinByName("="),
-	"<":     object.GetBuiltinByName("<"),
-	">":     object.GetBuiltinByName(">"),
-	"not":   object.GetBuiltinByName("not"),
-	"and":   object.GetBuiltinByName("and"),
-	"or":    object.GetBuiltinByName("or"),
-	"list":  object.GetBuiltinByName("list"),
-	"dict":  object.GetBuiltinByName("dict"),
-	"first": object.GetBuiltinByName("first"),
-	"rest":  object.GetBuiltinByName("rest"),
-	"last":  object.GetBuiltinByName("last"),
-	"len":   object.GetBuiltinByName("len"),
-	"push":  object.GetBuiltinByName("push"),
-	"str":   object.GetBuiltinByName("str"),
-	"print": object.GetBuiltinByName("print"),
-	"get":   object.GetBuiltinByName("get"),
-	"set":   object.GetBuiltinByName("set"),
+	"+":        object.GetBuiltinByName("+"),
+	"*":        object.GetBuiltinByName("*"),
+	"-":        object.GetBuiltinByName("-"),
+	"/":        object.GetBuiltinByName("/"),
+	"rem":      object.GetBuiltinByName("rem"),
+	"=":        object.GetBuiltinByName("="),
+	"<":        object.GetBuiltinByName("<"),
+	">":        object.GetBuiltinByName(">"),
+	"not":      object.GetBuiltinByName("not"),
+	"list":     object.GetBuiltinByName("list"),
+	"dict":     object.GetBuiltinByName("dict"),
+	"first":    object.GetBuiltinByName("first"),
+	"rest":     object.GetBuiltinByName("rest"),
+	"last":     object.GetBuiltinByName("last"),
+	"len":      object.GetBuiltinByName("len"),
+	"push":     object.GetBuiltinByName("push"),
+	"str":      object.GetBuiltinByName("str"),
+	"print":    object.GetBuiltinByName("print"),
+	"get":      object.GetBuiltinByName("get"),
+	"set":      object.GetBuiltinByName("set"),
+	"concat":   object.GetBuiltinByName("concat"),
+	"gensym":   object.GetBuiltinByName("gensym"),
+	"compare":  object.GetBuiltinByName("compare"),
+	"map":      object.GetBuiltinByName("map"),
+	"filter":   object.GetBuiltinByName("filter"),
+	"reduce":   object.GetBuiltinByName("reduce"),
+	"sort-by":  object.GetBuiltinByName("sort-by"),
+	"apply":    object.GetBuiltinByName("apply"),
+	"for-each": object.GetBuiltinByName("for-each"),
+}
+
+// caller calls the functions passed to builtins, such as map, evaluating
+// lambdas with the evaluator. Builtins it calls are counted by the Meter of
+// the program the Environment is in, when it has one.
+type caller struct {
+	env *object.Environment
+}
+
+func (c caller) Call(fn object.Object, args ...object.Object) object.Object {
+	if builtin, ok := fn.(*object.FunctionObject); ok && c.env != nil {
+		return callBuiltin(builtin, args, c.env)
+	}
+
+	return Apply("lambda", fn, args...)
 }
 
 func evalTruthy(obj object.Object) bool {
@@ -2,9 +2,12 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"lisp/ast"
 	"lisp/object"
+	"lisp/token"
+	"slices"
 )
 
 var (
@@ -13,37 +16,135 @@ var (
 	NULL  = object.NULL
 )
 
+// The most expressions that are evaluated nested in each other when no
+// StackSize limit is given, which keeps the evaluator well within the
+// maximum size of the Go stack.
+const MaxDepth = 10000
+
 // Recursively evaluate a given expression and return a final value.
+//
+// An error resulting from a Program has the stack trace of the functions it
+// left, ending with the top level of the program.
 func Evaluate(e ast.Expression, env *object.Environment) object.Object {
-	switch e := e.(type) {
-	case *ast.Program:
-		var result object.Object
+	m := meter(env)
 
-		for _, expression := range e.Expressions {
-			result = Evaluate(expression, env)
+	err := m.Push()
 
-			if result.Type() == object.ERROR_OBJ {
-				return result
-			}
+	if err != nil {
+		return withPosition(limitExceeded(err), e.Pos())
+	}
+
+	defer m.Pop()
+
+	result := force(evaluateTail(e, env))
+
+	if err, ok := result.(*object.ErrorObject); ok {
+		if _, ok := e.(*ast.Program); ok {
+			err.LeaveFunction(object.MainFunctionName)
 		}
+	}
 
-		return result
+	return result
+}
+
+// Evaluate an expression as Evaluate does, in the provided context and
+// within the provided Limits. Exceeding them, or the context being done,
+// results in an error object carrying the LimitError.
+func EvaluateContext(ctx context.Context, e ast.Expression, env *object.Environment, limits object.Limits) object.Object {
+	previous := env.SetMeter(NewMeter(ctx, limits))
+	defer env.SetMeter(previous)
+
+	return Evaluate(e, env)
+}
+
+// Create a Meter for the provided Limits, using the default for those the
+// evaluator always enforces. Programs are evaluated within the Limits once
+// the Meter is set on their Environment.
+func NewMeter(ctx context.Context, limits object.Limits) *object.Meter {
+	if limits.StackSize == 0 {
+		limits.StackSize = MaxDepth
+	}
+
+	return object.NewMeter(ctx, limits)
+}
+
+// Return the Meter of the program the Environment is in, giving the program
+// one with the default Limits if it has none.
+func meter(env *object.Environment) *object.Meter {
+	m := env.Meter()
+
+	if m == nil {
+		m = NewMeter(context.Background(), object.Limits{})
+		env.SetMeter(m)
+	}
+
+	return m
+}
+
+// Create the error object for a LimitError, which ends the program.
+func limitExceeded(err error) *object.ErrorObject {
+	limit, _ := err.(*object.LimitError)
+	return &object.ErrorObject{Error: err.Error(), Limit: limit}
+}
+
+// Evaluate an expression in tail position, where the result may be a
+// tailCall that is yet to be made. Calls are deferred to whichever caller
+// needs the final value, so that a chain of tail calls runs in a loop rather
+// than growing the Go stack.
+func evaluateTail(e ast.Expression, env *object.Environment) object.Object {
+	err := meter(env).Step()
+
+	if err != nil {
+		return withPosition(limitExceeded(err), e.Pos())
+	}
+
+	switch e := e.(type) {
+	case *ast.Program:
+		return evalProgram(e, env)
 	case *ast.FloatLiteral:
 		return &object.Number{Value: e.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: e.Value}
 	case *ast.Identifier:
-		return evalIdentifier(e, env)
+		return withPosition(evalIdentifier(e, env), e.Pos())
 	case *ast.SExpression:
-		return evaluateSExpression(e, env)
+		return withPosition(evaluateSExpression(e, env), e.Pos())
+	case *ast.Import:
+		return withPosition(evaluateImport(e, env), e.Pos())
+	case *ast.BadExpression:
+		err := fmt.Sprintf("cannot evaluate invalid expression %q", e.String())
+		return &object.ErrorObject{Error: err, Pos: e.Pos()}
 	default:
 		return NULL
 	}
 }
 
+// Evaluate each of the expressions of the program in order, returning the
+// result of the last, or the first error. A program without expressions, such
+// as one with only comments, results in null.
+func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+	var result object.Object = NULL
+
+	for _, expression := range program.Expressions {
+		result = Evaluate(expression, env)
+
+		if result.Type() == object.ERROR_OBJ {
+			return result
+		}
+	}
+
+	return result
+}
+
 // Recursively evaluate an SExpression and return the resulting object.
 func evaluateSExpression(e *ast.SExpression, env *object.Environment) object.Object {
 	if e.Fn == nil {
+		err := meter(env).Allocate(1)
+
+		if err != nil {
+			return limitExceeded(err)
+		}
+
 		return &object.List{}
 	}
 
@@ -54,12 +155,50 @@ func evaluateSExpression(e *ast.SExpression, env *object.Environment) object.Obj
 		return evaluateIfExpression(e, env)
 	case "def":
 		return evaluateDefExpression(e, env)
+	case "set!":
+		return evaluateSetExpression(e, env)
 	case "lambda":
 		return evaluateLambdaExpression(e, env)
+	case "let":
+		return evaluateLetExpression(e, env)
+	case "let*":
+		return evaluateLetStarExpression(e, env)
+	case "letrec":
+		return evaluateLetrecExpression(e, env)
+	case "cond":
+		return evaluateCondExpression(e, env)
+	case "case":
+		return evaluateCaseExpression(e, env)
+	case "when":
+		return evaluateWhenExpression(e, env, false)
+	case "unless":
+		return evaluateWhenExpression(e, env, true)
+	case "and":
+		return evaluateAndExpression(e, env)
+	case "or":
+		return evaluateOrExpression(e, env)
+	case "quote":
+		return evaluateQuoteExpression(e)
+	case "quasiquote":
+		return evaluateQuasiquoteExpression(e, env)
+	case "unquote", "unquote-splicing":
+		err := fmt.Sprintf("%s outside of quasiquote", e.Fn.String())
+		return &object.ErrorObject{Error: err}
+	case "try":
+		return evaluateTryExpression(e, env)
+	case "catch", "finally":
+		err := fmt.Sprintf("%s clause outside of try expression", e.Fn.String())
+		return &object.ErrorObject{Error: err}
+	case "throw":
+		return evaluateThrowExpression(e, env)
 	}
 
 	fnExpression := Evaluate(e.Fn, env)
 
+	if fnExpression.Type() == object.ERROR_OBJ {
+		return fnExpression
+	}
+
 	args := []object.Object{}
 	for _, arg := range e.Args {
 		obj := Evaluate(arg, env)
@@ -73,9 +212,9 @@ func evaluateSExpression(e *ast.SExpression, env *object.Environment) object.Obj
 
 	switch fnExpression := fnExpression.(type) {
 	case *object.FunctionObject:
-		return fnExpression.Fn(args...)
+		return callBuiltin(fnExpression, args, env)
 	case *object.LambdaObject:
-		return evalLambda(e.Fn.String(), fnExpression, args...)
+		return &tailCall{name: e.Fn.String(), lambda: fnExpression, args: args, pos: e.Pos()}
 	default:
 		err := fmt.Sprintf("%s is not a function", fnExpression.Inspect())
 		return &object.ErrorObject{
@@ -84,10 +223,41 @@ func evaluateSExpression(e *ast.SExpression, env *object.Environment) object.Obj
 	}
 }
 
+// Apply calls the provided builtin or lambda with the provided arguments and
+// returns its result. The name is what the function was called by, for use
+// in errors.
+func Apply(name string, fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.FunctionObject:
+		return fn.Fn(caller{}, args...)
+	case *object.LambdaObject:
+		return force(&tailCall{name: name, lambda: fn, args: args})
+	default:
+		err := fmt.Sprintf("%s is not a function", fn.Inspect())
+		return &object.ErrorObject{Error: err}
+	}
+}
+
+// Call the provided builtin with the arguments, counting the objects it
+// creates with the Meter of the program the Environment is in.
+func callBuiltin(fn *object.FunctionObject, args []object.Object, env *object.Environment) object.Object {
+	result := fn.Fn(caller{env: env}, args...)
+
+	err := meter(env).Allocate(object.Allocated(result, args))
+
+	if err != nil {
+		return limitExceeded(err)
+	}
+
+	return result
+}
+
 // Return the object associated with the given identifier.
 //
-// Starts by checking reserved keywords (booleans, builtins),
-// if not found then retrieves the object from the environment.
+// Starts by checking reserved keywords (booleans, null, keywords), then
+// retrieves the object from the environment. Builtins are found when the
+// environment does not define the identifier, so that variables shadow them
+// as they do in the compiler.
 func evalIdentifier(i *ast.Identifier, env *object.Environment) object.Object {
 	if i.String() == "true" {
 		return TRUE
@@ -101,49 +271,185 @@ func evalIdentifier(i *ast.Identifier, env *object.Environment) object.Object {
 		return NULL
 	}
 
-	fn, ok := builtins[i.String()]
+	if object.IsKeyword(i.String()) {
+		return &object.Symbol{Name: i.String()}
+	}
 
-	if ok {
+	obj := env.Get(i.String())
+
+	if obj.Type() != object.ERROR_OBJ {
+		return obj
+	}
+
+	if fn, ok := builtins[i.String()]; ok {
 		return fn
 	}
 
-	return env.Get(i.String())
+	return obj
+}
+
+// Attach the provided source position to an error object that doesn't yet
+// know where it occurred. Errors raised by nested expressions keep their own,
+// more precise, position. The position is also recorded as where the error
+// reached in the function it is leaving, for its stack trace.
+func withPosition(obj object.Object, pos token.Position) object.Object {
+	err, ok := obj.(*object.ErrorObject)
+
+	if !ok {
+		return obj
+	}
+
+	if !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+
+	if pos.IsValid() {
+		err.Reach(pos)
+	}
+
+	return obj
 }
 
 /*
 Evaluate the execution of a lambda function.
 
- 1. Evaluate each argument passed to the lambda and add them to a new environment.
- 2. Evaluate all but the last expression in the lambda, using the new environment.
- 3. Evaluate the final expression and return its result.
+ 1. Bind each argument passed to the lambda to its parameter in a new environment.
+ 2. Evaluate the default of each parameter that was not passed an argument.
+ 3. Evaluate all but the last expression in the lambda, using the new environment.
+ 4. Evaluate the final expression and return its result.
 */
 func evalLambda(lambdaName string, lambda *object.LambdaObject, args ...object.Object) object.Object {
-	if len(lambda.Args) != len(args) {
-		err := fmt.Sprintf("incorrect number of args for %s: expected=%d got=%d",
-			lambdaName, len(lambda.Args), len(args))
-		return &object.ErrorObject{Error: err}
+	slots, err := lambda.Params.Signature.Bind(args)
+
+	if err != nil {
+		return &object.ErrorObject{Error: fmt.Sprintf("%s: %s", lambdaName, err)}
+	}
+
+	m := meter(lambda.Env)
+
+	if err := m.Call(); err != nil {
+		return limitExceeded(err)
 	}
 
+	defer m.Return()
+
 	lambdaEnv := object.NewEnvironment(lambda.Env)
 
-	for i, arg := range args {
-		lambdaEnv.Set(
-			lambda.Args[i],
-			arg,
-		)
+	for i, name := range lambda.Params.Names {
+		if slots[i] == nil {
+			lambdaEnv.Set(name, NULL)
+		} else {
+			lambdaEnv.Set(name, slots[i])
+		}
 	}
 
-	lastIndex := len(lambda.Body) - 1
+	// Defaults are evaluated in order, so they may refer to the parameters
+	// before them.
+	for i, def := range lambda.Params.Defaults {
+		if slots[i] != nil || def == nil {
+			continue
+		}
 
-	for _, exp := range lambda.Body[:lastIndex] {
-		obj := Evaluate(exp, lambdaEnv)
+		value := Evaluate(def, lambdaEnv)
 
-		if obj.Type() == object.ERROR_OBJ {
+		if err, ok := value.(*object.ErrorObject); ok {
+			err.LeaveFunction(lambda.Name)
+			return err
+		}
+
+		lambdaEnv.Set(lambda.Params.Names[i], value)
+	}
+
+	result := evalBody(lambda.Body, lambdaEnv)
+
+	if err, ok := result.(*object.ErrorObject); ok {
+		err.LeaveFunction(lambda.Name)
+	}
+
+	return result
+}
+
+// A call to a lambda made in tail position, which is deferred until its
+// result is needed.
+type tailCall struct {
+	name   string
+	lambda *object.LambdaObject
+	args   []object.Object
+	pos    token.Position // the position of the call, for errors binding the arguments
+}
+
+func (t *tailCall) Type() object.ObjectType {
+	return "TAIL_CALL"
+}
+
+func (t *tailCall) Inspect() string {
+	return fmt.Sprintf("tail call to %s", t.name)
+}
+
+// Make the provided tailCall, along with any tail call its lambda results in,
+// until a value results. Any other object is returned as it is.
+//
+// An error leaving the calls reaches the caller at the first call, as each
+// tail call is made in place of the lambda that made it.
+func force(obj object.Object) object.Object {
+	first, ok := obj.(*tailCall)
+
+	if !ok {
+		return obj
+	}
+
+	var previous *tailCall
+
+	for {
+		call, ok := obj.(*tailCall)
+
+		if !ok {
 			return obj
 		}
+
+		obj = evalLambda(call.name, call.lambda, call.args...)
+
+		if err, ok := obj.(*object.ErrorObject); ok {
+			if !err.Pos.IsValid() {
+				err.Pos = call.pos
+			}
+
+			// Only an error binding the arguments has no trace yet, which
+			// for a tail call is raised in the lambda that made it.
+			if previous != nil && len(err.Trace) == 0 {
+				err.Reach(call.pos)
+				err.LeaveFunction(previous.lambda.Name)
+			}
+
+			if first.pos.IsValid() {
+				err.Reach(first.pos)
+			}
+
+			return err
+		}
+
+		previous = call
 	}
+}
 
-	return Evaluate(lambda.Body[lastIndex], lambdaEnv)
+// Evaluate each of the expressions in order, returning the result of the
+// last, or null if there are none. The last expression is in tail position.
+func evalBody(body []ast.Expression, env *object.Environment) object.Object {
+	var result object.Object = NULL
+
+	for i, exp := range body {
+		if i == len(body)-1 {
+			return evaluateTail(exp, env)
+		}
+
+		result = Evaluate(exp, env)
+
+		if result.Type() == object.ERROR_OBJ {
+			return result
+		}
+	}
+
+	return result
 }
 
 // Evaluate the condition of an if expression, then conditionally
@@ -162,16 +468,177 @@ func evaluateIfExpression(e *ast.SExpression, env *object.Environment) object.Ob
 	condition := evalTruthy(obj)
 
 	if condition {
-		return Evaluate(e.Args[1], env)
+		return evaluateTail(e.Args[1], env)
 	}
 
 	if len(e.Args) == 3 {
-		return Evaluate(e.Args[2], env)
+		return evaluateTail(e.Args[2], env)
 	}
 
 	return NULL
 }
 
+// Evaluate a cond expression, of the form
+// `(cond (test body...) ... (else body...))`.
+//
+// The tests are evaluated in order until one is true, and the body of that
+// clause is evaluated. A clause without a body results in the value of its
+// test. When no test is true, the result is the else body, or null if there
+// is no else clause.
+func evaluateCondExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	for i, arg := range e.Args {
+		clause, ok := arg.(*ast.SExpression)
+
+		if !ok || clause.Fn == nil {
+			err := fmt.Sprintf("cond clause must be a non-empty list, got %s", arg.String())
+			return &object.ErrorObject{Error: err, Pos: arg.Pos()}
+		}
+
+		if isForm(clause, "else") {
+			if i != len(e.Args)-1 {
+				return &object.ErrorObject{Error: "else must be the last clause of cond", Pos: clause.Pos()}
+			}
+
+			return evalBody(clause.Args, env)
+		}
+
+		test := Evaluate(clause.Fn, env)
+
+		if test.Type() == object.ERROR_OBJ {
+			return test
+		}
+
+		if !evalTruthy(test) {
+			continue
+		}
+
+		if len(clause.Args) == 0 {
+			return test
+		}
+
+		return evalBody(clause.Args, env)
+	}
+
+	return NULL
+}
+
+// Evaluate a case expression, of the form
+// `(case key ((datum ...) body...) ... (else body...))`.
+//
+// The key is evaluated once, and the body of the first clause with a datum
+// equal to it is evaluated. The datums are quoted data, which are never
+// evaluated. When no datum matches, the result is the else body, or null if
+// there is no else clause.
+func evaluateCaseExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) < 1 {
+		return object.WrongNumOfArgsError("case", "at least 1", len(e.Args))
+	}
+
+	key := Evaluate(e.Args[0], env)
+
+	if key.Type() == object.ERROR_OBJ {
+		return key
+	}
+
+	for i, arg := range e.Args[1:] {
+		clause, ok := arg.(*ast.SExpression)
+
+		if !ok || clause.Fn == nil {
+			err := fmt.Sprintf("case clause must be a non-empty list, got %s", arg.String())
+			return &object.ErrorObject{Error: err, Pos: arg.Pos()}
+		}
+
+		if isForm(clause, "else") {
+			if i != len(e.Args)-2 {
+				return &object.ErrorObject{Error: "else must be the last clause of case", Pos: clause.Pos()}
+			}
+
+			return evalBody(clause.Args, env)
+		}
+
+		datums, ok := clause.Fn.(*ast.SExpression)
+
+		if !ok {
+			err := fmt.Sprintf("case datums must be a list, got %s", clause.Fn.String())
+			return &object.ErrorObject{Error: err, Pos: clause.Fn.Pos()}
+		}
+
+		if datums.Fn == nil {
+			continue
+		}
+
+		for _, datum := range append([]ast.Expression{datums.Fn}, datums.Args...) {
+			if object.Equal(key, object.Quote(datum)) {
+				return evalBody(clause.Args, env)
+			}
+		}
+	}
+
+	return NULL
+}
+
+// Evaluate a when expression, of the form `(when test body...)`, which
+// results in its body when the test is true and null otherwise. When unless
+// is true, evaluate it as an unless expression, which results in its body
+// when the test is false instead.
+func evaluateWhenExpression(e *ast.SExpression, env *object.Environment, unless bool) object.Object {
+	if len(e.Args) < 1 {
+		return object.WrongNumOfArgsError(e.Fn.String(), "at least 1", len(e.Args))
+	}
+
+	test := Evaluate(e.Args[0], env)
+
+	if test.Type() == object.ERROR_OBJ {
+		return test
+	}
+
+	if evalTruthy(test) == unless {
+		return NULL
+	}
+
+	return evalBody(e.Args[1:], env)
+}
+
+// Evaluate the arguments of an and expression in order, stopping at the first
+// false value. Return the last value evaluated, or true if there are none.
+func evaluateAndExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	var result object.Object = TRUE
+
+	for i, arg := range e.Args {
+		if i == len(e.Args)-1 {
+			return evaluateTail(arg, env)
+		}
+
+		result = Evaluate(arg, env)
+
+		if result.Type() == object.ERROR_OBJ || !evalTruthy(result) {
+			return result
+		}
+	}
+
+	return result
+}
+
+// Evaluate the arguments of an or expression in order, stopping at the first
+// true value. Return the last value evaluated, or false if there are none.
+func evaluateOrExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	var result object.Object = FALSE
+
+	for i, arg := range e.Args {
+		if i == len(e.Args)-1 {
+			return evaluateTail(arg, env)
+		}
+
+		result = Evaluate(arg, env)
+
+		if result.Type() == object.ERROR_OBJ || evalTruthy(result) {
+			return result
+		}
+	}
+
+	return result
+}
+
 // Add the evaluated expression to env, with the key being the provided identifier.
 //
 // SExpression must be of form (def ident expr) to be successful, where ident is an
@@ -193,12 +660,66 @@ func evaluateDefExpression(e *ast.SExpression, env *object.Environment) object.O
 	val := Evaluate(e.Args[1], env)
 
 	if val.Type() != object.ERROR_OBJ {
+		nameLambda(val, e.Args[1], ident.String())
 		env.Set(ident.String(), val)
 	}
 
 	return val
 }
 
+// Give the provided value the name of the variable it is defined as when it
+// is a lambda created by the expression, as the compiler does, so that stack
+// traces show the name.
+func nameLambda(value object.Object, expr ast.Expression, name string) {
+	lambda, isLambda := value.(*object.LambdaObject)
+	sExpr, isSExpr := expr.(*ast.SExpression)
+
+	if isLambda && isSExpr && isForm(sExpr, "lambda") {
+		lambda.Name = name
+	}
+}
+
+// Change the value of an existing variable to the evaluated expression, in
+// the innermost environment that defines it, and return the new value.
+//
+// SExpression must be of form (set! ident expr), where ident is already
+// defined. Otherwise return error object.
+func evaluateSetExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) != 2 {
+		return object.WrongNumOfArgsError("set!", "2", len(e.Args))
+	}
+
+	ident, ok := e.Args[0].(*ast.Identifier)
+
+	if !ok {
+		err := fmt.Sprintf("cannot assign to non-identifier %s", e.Args[0].String())
+		return &object.ErrorObject{Error: err}
+	}
+
+	val := Evaluate(e.Args[1], env)
+
+	if val.Type() == object.ERROR_OBJ {
+		return val
+	}
+
+	if !env.Assign(ident.String(), val) {
+		err := fmt.Sprintf("cannot set! undefined variable %s", ident.String())
+
+		// Builtins can only be changed once a variable of the same name
+		// shadows them. Names that are not defined by the program but can
+		// still be found are imported from another module.
+		if _, ok := builtins[ident.String()]; ok {
+			err = fmt.Sprintf("cannot set! builtin %s", ident.String())
+		} else if env.Get(ident.String()).Type() != object.ERROR_OBJ {
+			err = fmt.Sprintf("cannot set! imported variable %s", ident.String())
+		}
+
+		return &object.ErrorObject{Error: err, Pos: ident.Pos()}
+	}
+
+	return val
+}
+
 /*
 Evaluate an expression that defines a lambda.
 
@@ -214,40 +735,470 @@ func evaluateLambdaExpression(e *ast.SExpression, env *object.Environment) objec
 		return object.WrongNumOfArgsError("lambda", "at least 2", len(args))
 	}
 
-	argsList, ok := args[0].(*ast.SExpression)
+	params, err := object.ParseParameters(args[0])
+
+	if err != nil {
+		paramErr := err.(*object.ParameterError)
+		return &object.ErrorObject{Error: paramErr.Message, Pos: paramErr.Pos}
+	}
+
+	err = meter(env).Allocate(1)
+
+	if err != nil {
+		return limitExceeded(err)
+	}
+
+	return &object.LambdaObject{
+		Params: params,
+		Env:    env,
+		Body:   args[1:],
+	}
+}
+
+// Evaluate a let expression, of the form `(let ((name value) ...) body...)`.
+//
+// The values are evaluated in the current environment, then the body is
+// evaluated in a new environment enclosed by it, where each name is bound to
+// its value.
+//
+// A named let, of the form `(let loop ((name value) ...) body...)`, binds the
+// body as a lambda called loop, which is then called with the values.
+func evaluateLetExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) < 1 {
+		return object.WrongNumOfArgsError("let", "at least 1", len(e.Args))
+	}
+
+	if ident, ok := e.Args[0].(*ast.Identifier); ok {
+		return evaluateNamedLetExpression(ident.String(), e, env)
+	}
+
+	names, values, errObj := letBindings("let", e.Args[0])
+
+	if errObj != nil {
+		return errObj
+	}
+
+	letEnv := object.NewEnvironment(env)
+
+	for i, name := range names {
+		obj := Evaluate(values[i], env)
+
+		if obj.Type() == object.ERROR_OBJ {
+			return obj
+		}
+
+		letEnv.Set(name, obj)
+	}
+
+	return evalBody(e.Args[1:], letEnv)
+}
+
+// Evaluate a named let expression by binding its body as a lambda with the
+// provided name, in an environment of its own, then calling that lambda.
+func evaluateNamedLetExpression(name string, e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) < 2 {
+		return object.WrongNumOfArgsError("named let", "at least 2", len(e.Args))
+	}
+
+	names, values, errObj := letBindings("let", e.Args[1])
+
+	if errObj != nil {
+		return errObj
+	}
+
+	args := []object.Object{}
+
+	for _, value := range values {
+		obj := Evaluate(value, env)
+
+		if obj.Type() == object.ERROR_OBJ {
+			return obj
+		}
+
+		args = append(args, obj)
+	}
+
+	loopEnv := object.NewEnvironment(env)
+	lambda := &object.LambdaObject{
+		Name:   name,
+		Params: object.NewParameters(names),
+		Env:    loopEnv,
+		Body:   e.Args[2:],
+	}
+	loopEnv.Set(name, lambda)
+
+	return &tailCall{name: name, lambda: lambda, args: args, pos: e.Pos()}
+}
+
+// Evaluate a let* expression, which is the same as a let expression except
+// that each name is bound in a new environment enclosed by the previous one,
+// so each value can refer to the names bound before it.
+func evaluateLetStarExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) < 1 {
+		return object.WrongNumOfArgsError("let*", "at least 1", len(e.Args))
+	}
+
+	names, values, errObj := letBindings("let*", e.Args[0])
+
+	if errObj != nil {
+		return errObj
+	}
+
+	letEnv := object.NewEnvironment(env)
+
+	for i, name := range names {
+		obj := Evaluate(values[i], letEnv)
+
+		if obj.Type() == object.ERROR_OBJ {
+			return obj
+		}
+
+		letEnv = object.NewEnvironment(letEnv)
+		letEnv.Set(name, obj)
+	}
+
+	return evalBody(e.Args[1:], letEnv)
+}
+
+// Evaluate a letrec expression, where every value is evaluated in the new
+// environment holding the names, so that lambdas bound by it can refer to
+// themselves and each other.
+func evaluateLetrecExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) < 1 {
+		return object.WrongNumOfArgsError("letrec", "at least 1", len(e.Args))
+	}
+
+	names, values, errObj := letBindings("letrec", e.Args[0])
+
+	if errObj != nil {
+		return errObj
+	}
+
+	letEnv := object.NewEnvironment(env)
+
+	// Each name is unset until its value is evaluated, so that a value
+	// using a later name is an error, as it is in the VM.
+	for _, name := range names {
+		letEnv.Declare(name)
+	}
+
+	for i, name := range names {
+		obj := Evaluate(values[i], letEnv)
+
+		if obj.Type() == object.ERROR_OBJ {
+			return obj
+		}
+
+		nameLambda(obj, values[i], name)
+		letEnv.Set(name, obj)
+	}
+
+	return evalBody(e.Args[1:], letEnv)
+}
+
+// Split the bindings of a let expression, which take the form
+// `((name value) ...)`, into the bound names and their value expressions.
+//
+// Return an error object if the bindings are not of this form.
+func letBindings(form string, e ast.Expression) ([]string, []ast.Expression, object.Object) {
+	list, ok := e.(*ast.SExpression)
 
 	if !ok {
-		err := fmt.Sprintf("lambda requires list of args, got %s", args[0].String())
-		return &object.ErrorObject{Error: err}
+		err := fmt.Sprintf("%s bindings must be a list, got %s", form, e.String())
+		return nil, nil, &object.ErrorObject{Error: err, Pos: e.Pos()}
 	}
 
-	lambdaArgs := []string{}
+	names := []string{}
+	values := []ast.Expression{}
 
-	if argsList.Fn != nil {
-		arg, ok := argsList.Fn.(*ast.Identifier)
+	if list.Fn == nil {
+		return names, values, nil
+	}
+
+	for _, b := range append([]ast.Expression{list.Fn}, list.Args...) {
+		binding, ok := b.(*ast.SExpression)
+
+		if !ok || len(binding.Args) != 1 {
+			err := fmt.Sprintf("%s binding must be of the form (name value), got %s", form, b.String())
+			return nil, nil, &object.ErrorObject{Error: err, Pos: b.Pos()}
+		}
+
+		name, ok := binding.Fn.(*ast.Identifier)
 
 		if !ok {
-			err := fmt.Sprintf("lambda function must be identifier, got %s", argsList.Fn.String())
-			return &object.ErrorObject{Error: err}
+			err := fmt.Sprintf("%s binding name must be identifier, got %s", form, binding.Fn.String())
+			return nil, nil, &object.ErrorObject{Error: err, Pos: binding.Fn.Pos()}
+		}
+
+		// let* is the only form that can bind the same name more than once,
+		// as each name is bound in its own environment.
+		if form != "let*" && slices.Contains(names, name.String()) {
+			err := fmt.Sprintf("%s binds %s more than once", form, name.String())
+			return nil, nil, &object.ErrorObject{Error: err, Pos: name.Pos()}
 		}
 
-		lambdaArgs = append(lambdaArgs, arg.String())
+		names = append(names, name.String())
+		values = append(values, binding.Args[0])
 	}
 
-	for _, lambdaArg := range argsList.Args {
-		arg, ok := lambdaArg.(*ast.Identifier)
+	return names, values, nil
+}
 
-		if !ok {
-			err := fmt.Sprintf("lambda args must be identifiers, got %s", arg)
-			return &object.ErrorObject{Error: err}
+// Evaluate a try expression, of the form
+// `(try body... (catch name handler...) (finally cleanup...))`.
+//
+// When the body results in an error, the handler is evaluated in a new
+// environment where name is bound to the caught value. The cleanup is always
+// evaluated last, and its result is discarded unless it is an error. Errors
+// from exceeding a limit are neither caught nor cleaned up after.
+func evaluateTryExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	body, catch, finally, errObj := tryClauses(e)
+
+	if errObj != nil {
+		return errObj
+	}
+
+	// The body is not in tail position, so that errors from the calls it
+	// makes are caught here.
+	result := force(evalBody(body, env))
+
+	if exceeded(result) {
+		return result
+	}
+
+	if err, ok := result.(*object.ErrorObject); ok && catch != nil {
+		catchEnv := object.NewEnvironment(env)
+		catchEnv.Set(catch.Args[0].String(), object.Caught(err))
+
+		if finally == nil {
+			return evalBody(catch.Args[1:], catchEnv)
 		}
 
-		lambdaArgs = append(lambdaArgs, arg.String())
+		result = force(evalBody(catch.Args[1:], catchEnv))
+
+		if exceeded(result) {
+			return result
+		}
 	}
 
-	return &object.LambdaObject{
-		Args: lambdaArgs,
-		Env:  env,
-		Body: args[1:],
+	if finally != nil {
+		cleanup := force(evalBody(finally.Args, env))
+
+		if cleanup.Type() == object.ERROR_OBJ {
+			return cleanup
+		}
+
+		// The error is raised again after the cleanup, from the try.
+		if err, ok := result.(*object.ErrorObject); ok {
+			return err.Rethrown()
+		}
 	}
+
+	return result
+}
+
+// Report whether the provided object is an error from exceeding a limit,
+// which ends the program without being caught or cleaned up after.
+func exceeded(obj object.Object) bool {
+	err, ok := obj.(*object.ErrorObject)
+	return ok && err.Limit != nil
+}
+
+// Split the arguments of a try expression into its body and its catch and
+// finally clauses, which must come after the body in that order. The clauses
+// are nil when not provided.
+//
+// Return an error object if the clauses are not of this form.
+func tryClauses(e *ast.SExpression) ([]ast.Expression, *ast.SExpression, *ast.SExpression, object.Object) {
+	body := []ast.Expression{}
+	var catch, finally *ast.SExpression
+
+	for _, arg := range e.Args {
+		clause, ok := arg.(*ast.SExpression)
+
+		switch {
+		case ok && isForm(clause, "catch"):
+			if catch != nil || finally != nil {
+				err := "catch clause must come before finally and only once in try expression"
+				return nil, nil, nil, &object.ErrorObject{Error: err, Pos: clause.Pos()}
+			}
+
+			if len(clause.Args) < 1 {
+				err := "catch clause must be of the form (catch name body...)"
+				return nil, nil, nil, &object.ErrorObject{Error: err, Pos: clause.Pos()}
+			}
+
+			if _, ok := clause.Args[0].(*ast.Identifier); !ok {
+				err := "catch clause must be of the form (catch name body...)"
+				return nil, nil, nil, &object.ErrorObject{Error: err, Pos: clause.Pos()}
+			}
+
+			catch = clause
+		case ok && isForm(clause, "finally"):
+			if finally != nil {
+				err := "finally clause must come last and only once in try expression"
+				return nil, nil, nil, &object.ErrorObject{Error: err, Pos: clause.Pos()}
+			}
+
+			finally = clause
+		case catch != nil || finally != nil:
+			err := "catch and finally clauses must be at the end of try expression"
+			return nil, nil, nil, &object.ErrorObject{Error: err, Pos: arg.Pos()}
+		default:
+			body = append(body, arg)
+		}
+	}
+
+	if catch == nil && finally == nil {
+		err := "try expression must have a catch or finally clause"
+		return nil, nil, nil, &object.ErrorObject{Error: err, Pos: e.Pos()}
+	}
+
+	return body, catch, finally, nil
+}
+
+// Evaluate a throw expression, resulting in an error carrying the value of
+// its single argument.
+func evaluateThrowExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) != 1 {
+		return object.WrongNumOfArgsError("throw", "1", len(e.Args))
+	}
+
+	value := Evaluate(e.Args[0], env)
+
+	if value.Type() == object.ERROR_OBJ {
+		return value
+	}
+
+	return object.ThrowError(value)
+}
+
+// Evaluate an import of a module, making its exports available as
+// namespace/name and resulting in the namespace as a symbol.
+//
+// The first import of a module in the program evaluates the module in a
+// global Environment of its own, so that the module runs once, when it is
+// first imported. Later imports only refer to the values it defined.
+func evaluateImport(imp *ast.Import, env *object.Environment) object.Object {
+	module, ok := env.Module(imp.Module.Path)
+
+	if !ok {
+		module = env.NewModule()
+
+		result := evalProgram(imp.Module.Program, module)
+
+		if result != nil && result.Type() == object.ERROR_OBJ {
+			return result
+		}
+
+		env.SetModule(imp.Module.Path, module)
+	}
+
+	for _, export := range imp.Module.Exports {
+		if !env.Import(imp.Namespace, module, export.String()) {
+			err := fmt.Sprintf("module %s exports undefined variable %s", imp.Module.Path, export)
+			return &object.ErrorObject{Error: err, Pos: export.Pos()}
+		}
+	}
+
+	return &object.Symbol{Name: imp.Namespace}
+}
+
+// Return the data represented by the single argument of a quote expression,
+// without evaluating it.
+func evaluateQuoteExpression(e *ast.SExpression) object.Object {
+	if len(e.Args) != 1 {
+		return object.WrongNumOfArgsError("quote", "1", len(e.Args))
+	}
+
+	return object.Quote(e.Args[0])
+}
+
+// Return the data represented by the argument of a quasiquote expression,
+// evaluating only the parts of it that are unquoted.
+func evaluateQuasiquoteExpression(e *ast.SExpression, env *object.Environment) object.Object {
+	if len(e.Args) != 1 {
+		return object.WrongNumOfArgsError("quasiquote", "1", len(e.Args))
+	}
+
+	return evalQuasiquote(e.Args[0], env, 1)
+}
+
+// Recursively build the data for a quasiquoted expression.
+//
+// depth is the number of quasiquotes the expression is nested within, less
+// the number of unquotes. Unquoted expressions are only evaluated when they
+// bring the depth back to 0.
+func evalQuasiquote(e ast.Expression, env *object.Environment, depth int) object.Object {
+	sExpr, ok := e.(*ast.SExpression)
+
+	if !ok || sExpr.Fn == nil {
+		return object.Quote(e)
+	}
+
+	switch {
+	case isForm(sExpr, "unquote"), isForm(sExpr, "unquote-splicing"):
+		if len(sExpr.Args) != 1 {
+			return object.WrongNumOfArgsError(sExpr.Fn.String(), "1", len(sExpr.Args))
+		}
+
+		if depth == 1 {
+			if isForm(sExpr, "unquote-splicing") {
+				return &object.ErrorObject{Error: "unquote-splicing used outside of a list"}
+			}
+
+			return Evaluate(sExpr.Args[0], env)
+		}
+
+		depth--
+	case isForm(sExpr, "quasiquote"):
+		depth++
+	}
+
+	elements := append([]ast.Expression{sExpr.Fn}, sExpr.Args...)
+
+	// The list is built the same way as in the compiled engine: runs of
+	// elements are collected into lists, and these are joined with the
+	// spliced lists by the concat builtin.
+	segments := []object.Object{}
+	run := []object.Object{}
+
+	for _, element := range elements {
+		if spliced, ok := element.(*ast.SExpression); ok && depth == 1 && isForm(spliced, "unquote-splicing") {
+			if len(spliced.Args) != 1 {
+				return object.WrongNumOfArgsError("unquote-splicing", "1", len(spliced.Args))
+			}
+
+			obj := Evaluate(spliced.Args[0], env)
+
+			if obj.Type() == object.ERROR_OBJ {
+				return obj
+			}
+
+			segments = append(segments, object.NewList(run...), obj)
+			run = []object.Object{}
+
+			continue
+		}
+
+		obj := evalQuasiquote(element, env, depth)
+
+		if obj.Type() == object.ERROR_OBJ {
+			return obj
+		}
+
+		run = append(run, obj)
+	}
+
+	segments = append(segments, object.NewList(run...))
+
+	return builtins["concat"].Fn(caller{}, segments...)
+}
+
+// Check whether the SExpression is a special form with the given name, such
+// as (unquote x).
+func isForm(e *ast.SExpression, name string) bool {
+	ident, ok := e.Fn.(*ast.Identifier)
+
+	return ok && ident.String() == name
 }
@@ -1,10 +1,15 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"lisp/lexer"
 	"lisp/object"
 	"lisp/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 type evaluatorTest struct {
@@ -43,6 +48,10 @@ func TestEvaluateIntegerLiteral(t *testing.T) {
 			input:    "(+)",
 			expected: float64(0),
 		},
+		{
+			input:    `(len "héllo wörld")`,
+			expected: float64(11),
+		},
 	}
 
 	runEvalTests(t, tests)
@@ -83,6 +92,11 @@ func TestEvaluateStringLiteral(t *testing.T) {
 			expected:     "(list 1 2 3)",
 			expectedType: "string",
 		},
+		{
+			input:        `"line\n\"quoted\" \u00e9"`,
+			expected:     "line\n\"quoted\" é",
+			expectedType: "string",
+		},
 	}
 
 	runEvalTests(t, tests)
@@ -140,8 +154,8 @@ func TestEvaluateListCall(t *testing.T) {
 			t.Fatalf("expected list, instead got %T(%+v)", output, output)
 		}
 
-		if len(result.Values) != tt.expectedValueCount {
-			t.Errorf("expected %d values, got %d(%+v)", tt.expectedValueCount, len(result.Values), result.Values)
+		if result.Len() != tt.expectedValueCount {
+			t.Errorf("expected %d values, got %d(%+v)", tt.expectedValueCount, result.Len(), result.Items())
 		}
 
 		if result.Inspect() != tt.expectedInspect {
@@ -200,10 +214,7 @@ func TestEvaluateBooleanLiteral(t *testing.T) {
 			input:    "(and)",
 			expected: true,
 		},
-		{
-			input:    "(and 4)",
-			expected: true,
-		},
+
 		{
 			input:    "(or false true)",
 			expected: true,
@@ -216,10 +227,83 @@ func TestEvaluateBooleanLiteral(t *testing.T) {
 			input:    "(or false (= 1 2))",
 			expected: false,
 		},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that multi-way conditionals choose the right branch, and only evaluate
+// the expressions needed.
+func TestMultiWayConditionals(t *testing.T) {
+	tests := []evaluatorTest{
+		{input: "(cond)", expected: nil},
+		{input: "(cond (false 1))", expected: nil},
+		{input: "(cond (false 1) (true 2) (true 3))", expected: float64(2)},
+		{input: "(cond (false 1) (else 2 3))", expected: float64(3)},
+		{input: "(cond (false) (7))", expected: float64(7)},
+		{input: "(cond ((= 1 2) (len 1)) ((< 1 2) 4))", expected: float64(4)},
+		{input: "(case 5 ((1 2) 1) (else 2))", expected: float64(2)},
+		{input: "(case 5 ((1 2) 1))", expected: nil},
+		{input: "(case \"b\" ((\"a\") 1) ((\"b\") 2))", expected: float64(2)},
+		{input: "(case 'x ((y) 1) ((x z) 2))", expected: float64(2)},
+		{input: "(case null ((1) 1) ((null) 2))", expected: float64(2)},
+		{input: "(case true ((false) 1) ((true) 2))", expected: float64(2)},
+		{input: "(when true 1 2)", expected: float64(2)},
+		{input: "(when false 1)", expected: nil},
+		{input: "(when true)", expected: nil},
+		{input: "(unless false 1 2)", expected: float64(2)},
+		{input: "(unless true 1)", expected: nil},
+		{input: "(cond (else 1) (true 2))", expected: "1:7: else must be the last clause of cond"},
+		{input: "(case 1 (1 2))", expected: "1:10: case datums must be a list, got 1"},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that and/or only evaluate the arguments needed to decide the result,
+// and result in the last value evaluated.
+func TestAndOrExpressions(t *testing.T) {
+	tests := []evaluatorTest{
+		{
+			input:    "(and 4)",
+			expected: float64(4),
+		},
+		{
+			input:    "(and 1 2 3)",
+			expected: float64(3),
+		},
+		{
+			input:    "(and 1 null 3)",
+			expected: nil,
+		},
 		{
 			input:    "(or false 1)",
+			expected: float64(1),
+		},
+		{
+			input:    "(or)",
+			expected: false,
+		},
+		{
+			input:    "(or null false)",
+			expected: false,
+		},
+		{
+			input:    "(def x 0) (and (not (= x 0)) (/ 10 x))",
+			expected: false,
+		},
+		{
+			input:    "(def x 0) (or (= x 0) (/ 10 x))",
 			expected: true,
 		},
+		{
+			input:    "(def x 5) (and (not (= x 0)) (/ 10 x))",
+			expected: float64(2),
+		},
+		{
+			input:    "(or (missing) 1)",
+			expected: "1:6: No such item: missing",
+		},
 	}
 
 	runEvalTests(t, tests)
@@ -321,6 +405,561 @@ func TestDefineExpression(t *testing.T) {
 	}
 }
 
+// Test that a program without expressions, such as one with only comments,
+// results in null.
+func TestEmptyPrograms(t *testing.T) {
+	tests := []evaluatorTest{
+		{input: "", expected: nil},
+		{input: "; just a comment", expected: nil},
+		{input: "#| a block comment |# #;(+ 1 2)", expected: nil},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that let expressions bind names in their own environment, which
+// closures in their body can capture.
+func TestLetExpressions(t *testing.T) {
+	tests := []evaluatorTest{
+		{input: "(let () 1)", expected: float64(1)},
+		{input: "(let ((a 1)))", expected: nil},
+		{input: "(let ((a 1) (b 2)) (+ a b))", expected: float64(3)},
+		{input: "(def a 10) (let ((a 1) (b a)) b)", expected: float64(10)},
+		{input: "(def a 10) (let ((a 1)) a) a", expected: float64(10)},
+		{input: "(let* ((a 1) (b (+ a 1)) (a (* b 10))) a)", expected: float64(20)},
+		{input: "(def add (let ((n 5)) (lambda (x) (+ x n)))) (add 1)", expected: float64(6)},
+		{
+			input: `
+            (letrec ((even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+                     (odd? (lambda (n) (if (= n 0) false (even? (- n 1))))))
+              (even? 10))
+            `,
+			expected: true,
+		},
+		{
+			input: `
+            (let loop ((i 0) (total 0))
+              (if (= i 5)
+                total
+                (loop (+ i 1) (+ total i))))
+            `,
+			expected: float64(10),
+		},
+		{input: "(let ((a 1)) a) a", expected: "1:17: No such item: a"},
+		{input: "(letrec ((a b) (b 1)) a)", expected: "1:13: undefined variable b"},
+		{input: "(def b 5) (letrec ((a (+ b 1)) (b 1)) a)", expected: "1:26: undefined variable b"},
+		{input: "(letrec ((f (lambda () b)) (a (f)) (b 1)) a)", expected: "1:24: undefined variable b"},
+		{input: "(letrec ((a (try b (catch e (str e)))) (b 1)) (= a \"undefined variable b\"))", expected: true},
+		{input: "(let ((a 1) (a 2)) a)", expected: "1:14: let binds a more than once"},
+		{input: "(let ((a)) a)", expected: "1:7: let binding must be of the form (name value), got (a)"},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that set! changes variables in the environment that defines them, so
+// that closures which captured the same variable share its changes.
+func TestSetExpressions(t *testing.T) {
+	tests := []evaluatorTest{
+		{input: "(def a 1) (set! a 2) a", expected: float64(2)},
+		{input: "(def a 1) (set! a (+ a 1))", expected: float64(2)},
+		{input: "(def f (lambda (a) (set! a (* a 2)) a)) (f 4)", expected: float64(8)},
+		{input: "(let ((a 1)) (let ((b 2)) (set! a b)) a)", expected: float64(2)},
+		{
+			input: `
+            (def counter (lambda ()
+              (let ((n 0))
+                (lambda () (set! n (+ n 1))))))
+            (def a (counter))
+            (def b (counter))
+            (a)
+            (a)
+            (b)
+            (+ (* 10 (a)) (b))
+            `,
+			expected: float64(32),
+		},
+		{
+			input: `
+            (def account (lambda (balance)
+              (list (lambda (n) (set! balance (+ balance n)))
+                    (lambda () balance))))
+            (def acc (account 10))
+            ((first acc) 5)
+            ((last acc))
+            `,
+			expected: float64(15),
+		},
+		{input: "(set! a 1)", expected: "1:7: cannot set! undefined variable a"},
+		{input: "(set! + 1)", expected: "1:7: cannot set! builtin +"},
+		{input: "(def map 1) (set! map 2) map", expected: float64(2)},
+		{input: "(def f (lambda () (set! later 1))) (f)", expected: "1:25: cannot set! undefined variable later"},
+		{input: "(def f (lambda () (set! later 1))) (def later 0) (f) later", expected: float64(1)},
+		{input: "(let ((first 1)) (set! first 2) first)", expected: float64(2)},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that optional, rest and keyword parameters are bound to the arguments
+// of a call, and that defaults are applied to those that were left out.
+func TestLambdaParameters(t *testing.T) {
+	tests := []evaluatorTest{
+		{input: "((lambda (a . r) (len r)) 1 2 3)", expected: float64(2)},
+		{input: "((lambda (a &rest r) (first r)) 1 2 3)", expected: float64(2)},
+		{input: "((lambda (a &optional b) b) 1)", expected: nil},
+		{input: "((lambda (a &optional (b 2)) (+ a b)) 1)", expected: float64(3)},
+		{input: "((lambda (a &optional (b 2)) (+ a b)) 1 5)", expected: float64(6)},
+		{input: "((lambda (a &optional (b (* a 10)) (c (+ b 1))) c) 2)", expected: float64(21)},
+		{input: "((lambda (&key x y) y) :y 3)", expected: float64(3)},
+		{input: "((lambda (a &key (x 10) (y (+ x 1))) (+ a x y)) 1 :x 2)", expected: float64(6)},
+		{input: "((lambda (&rest r &key x) (len r)) :x 1)", expected: float64(2)},
+		{input: "(def f (lambda (a &optional b) a)) (f)", expected: "1:36: f: wrong number of arguments: expected=1 to 2 got=0"},
+		{input: "(def f (lambda (&key a) a)) (f :b 1)", expected: "1:29: f: unknown keyword argument :b"},
+		{input: "(lambda (a &rest) a)", expected: "1:9: rest parameter must be named"},
+		{input: "(lambda (a (b 1)) a)", expected: "1:12: lambda parameter must be identifier, got (b 1)"},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that calls in tail position are made without growing the Go stack, so
+// that tail recursion, including mutual recursion, is not limited in depth.
+func TestTailCalls(t *testing.T) {
+	tests := []evaluatorTest{
+		{
+			input: `
+            (def count (lambda (n acc) (if (= n 0) acc (count (- n 1) (+ acc 1)))))
+            (count 100000 0)
+            `,
+			expected: float64(100000),
+		},
+		{
+			input: `
+            (def even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+            (def odd? (lambda (n) (if (= n 0) false (even? (- n 1)))))
+            (even? 100001)
+            `,
+			expected: false,
+		},
+		{
+			input: `
+            (def loop (lambda (n)
+              (cond ((= n 0) 1)
+                    (else (when true (and true (or false (loop (- n 1)))))))))
+            (loop 100000)
+            `,
+			expected: float64(1),
+		},
+		{
+			input:    "(let loop ((i 0) (acc 0)) (if (= i 100000) acc (loop (+ i 1) (+ acc 2))))",
+			expected: float64(200000),
+		},
+		{
+			input: `
+            (def sum (lambda (n) (if (= n 0) 0 (+ n (sum (- n 1))))))
+            (sum 100)
+            `,
+			expected: float64(5050),
+		},
+		{input: "(def f (lambda (a) a)) (def g (lambda () (f))) (g)", expected: "1:42: f: wrong number of arguments: expected=1 got=0"},
+	}
+
+	runEvalTests(t, tests)
+}
+
+// Test that quoted data is never evaluated, and that quasiquote evaluates
+// only the unquoted parts of its argument.
+func TestQuoting(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"'a", "a"},
+		{"'(a b c)", "(a b c)"},
+		{"'(1 (\"two\" three) ())", "(1 (two three) ())"},
+		{"(= 'a 'a)", "true"},
+		{"(= 'a 'b)", "false"},
+		{"(def x 5) `(x ,x)", "(x 5)"},
+		{"(def xs '(1 2)) `(0 ,@xs 3 ,@xs)", "(0 1 2 3 1 2)"},
+		{"`(1 `(2 ,(3 ,(+ 2 2))))", "(1 (quasiquote (2 (unquote (3 4)))))"},
+		{"(def f (lambda (n) `(n is ,n))) (f 3)", "(n is 3)"},
+		{"`(1 ,@2)", "ERROR: 1:1: attempted to call concat with unsupported type NUMBER (2)"},
+		{",a", "ERROR: 1:1: unquote outside of quasiquote"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that = compares lists and dictionaries by their items and lambdas and
+// exceptions by identity, and that compare, < and > order numbers, strings
+// and lists.
+func TestEqualityAndOrdering(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(= '(1 (2 \"a\")) (list 1 (list 2 \"a\")))", "true"},
+		{"(= '(1 2) '(1 2) '(1 3))", "false"},
+		{"(= (dict \"a\" '(2)) (dict \"a\" '(2)))", "true"},
+		{"(= (dict \"a\" 2) (dict \"a\" 3))", "false"},
+		{"(= null null)", "true"},
+		{"(def f (lambda () 1)) (list (= f f) (= f (lambda () 1)))", "(true false)"},
+		{"(def caught (lambda () (try (/ 1 0) (catch e e)))) (def e (caught)) (list (= e e) (= e (caught)))", "(true false)"},
+		{"(list (compare 1 2) (compare \"b\" \"a\") (compare '(1 2) '(1 2)) (compare '(1 2) '(1 2 0)))", "(-1 1 0 -1)"},
+		{"(list (< \"apple\" \"banana\") (> '(2) '(1 5)) (< 1 3 2))", "(true true false)"},
+		{"(compare 1 \"a\")", "ERROR: 1:1: attempted to call compare with mismatched types NUMBER (1) and STRING (a)"},
+		{"(< 'a 'b)", "ERROR: 1:1: attempted to call < with unsupported type SYMBOL (a)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that numbers, symbols, null, booleans and lists and dictionaries of them
+// can be used as dictionary keys, with equal keys finding the same entry.
+func TestDictionaryKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(get {1 \"one\" 2 \"two\"} 1)", "one"},
+		{"(get {0 \"zero\"} -0)", "zero"},
+		{"(list (get {'a 1 \"a\" 2} 'a) (get {'a 1 \"a\" 2} \"a\"))", "(1 2)"},
+		{"(list (get {null 1} null) (get {true 1 \"true\" 2} true))", "(1 1)"},
+		{"(get {'(1 (2 \"b\")) 'found} (list 1 (list 2 \"b\")))", "found"},
+		{"(list (get {'(1 2) 'found} '(1 (2))) (get {'(1) 'found} '((1))))", "(null null)"},
+		{"(len {1 \"a\" 1 \"b\" '(1) \"c\" \"1\" \"d\"})", "3"},
+		{"(def d (set (set {} '(x y) 1) (list 'x 'y) 2)) (list (len d) (get d '(x y)))", "(1 2)"},
+		{"(list (get {{1 2 3 4} 'found} {3 4 1 2}) (get {{1 2} 'found} {1 3}))", "(found null)"},
+		{"(list (get {{} 'empty '() 'list} (dict)) (len {{1 2} 'a (set {} 1 2) 'b}))", "(empty 1)"},
+		{"(get {} (list {1 +}))", "ERROR: 1:1: attempted to use unsupported type as dict key LIST (({1: +}))"},
+		{"(get {1 2})", "ERROR: 1:1: attempted to call get with incorrect number of arguments: expected 2, got=1"},
+		{"(set {1 2} 1)", "ERROR: 1:1: attempted to call set with incorrect number of arguments: expected 3, got=2"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that push, rest, concat and set leave their arguments unchanged, and
+// that lists and dictionaries keep their items as they grow large.
+func TestPersistentCollections(t *testing.T) {
+	build := `
+(def build (lambda (n) (let loop ((i 0) (l '())) (if (= i n) l (loop (+ i 1) (push l i))))))
+(def ordered? (lambda (l i) (cond ((= (len l) 0) true) ((= (first l) i) (ordered? (rest l) (+ i 1))) (else i))))
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(def a '(1 2)) (def b (push a 3)) (def c (push a 4)) (list a b c)", "((1 2) (1 2 3) (1 2 4))"},
+		{"(def a '(1 2 3)) (def b (push (rest a) 4)) (list a b (rest (rest (rest a))))", "((1 2 3) (2 3 4) ())"},
+		{"(def a '(1)) (list (concat a '(2) '() '(3 4)) a)", "((1 2 3 4) (1))"},
+		{"(def d {'a 1}) (def e (set d 'b 2)) (list (len d) (get d 'b) (len e) (get e 'b))", "(1 null 2 2)"},
+		{build + "(def l (build 5000)) (list (len l) (first l) (last l) (ordered? l 0))", "(5000 0 4999 true)"},
+		{build + `(def fill (lambda (d l) (if (= (len l) 0) d (fill (set d (first l) (str (first l))) (rest l)))))
+(def d (fill {} (build 3000)))
+(list (len d) (get d 0) (get d 1234) (get d 2999) (get d 3000))`, "(3000 0 1234 2999 null)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that dictionaries print their keys in the order they were first set.
+func TestDictionaryOrder(t *testing.T) {
+	descending := []string{}
+
+	for i := 100; i > 0; i-- {
+		descending = append(descending, fmt.Sprintf("%d: %d", i, i*i))
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"b" 1 "a" 2 "c" 3}`, "{b: 1, a: 2, c: 3}"},
+		{`(set {"b" 1 "a" 2} "b" 3)`, "{b: 3, a: 2}"},
+		{`(set (set {} 2 'x) 1 'y)`, "{2: x, 1: y}"},
+		{`{'(1 2) 1 null 2 true 3 'a 4}`, "{(1 2): 1, null: 2, true: 3, a: 4}"},
+		{`(let loop ((i 100) (d {})) (if (= i 0) d (loop (- i 1) (set d i (* i i)))))`,
+			"{" + strings.Join(descending, ", ") + "}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that builtins taking functions can call lambdas and builtins, with
+// errors and thrown values passing through them.
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(map (lambda (n) (* n n)) '(1 2 3))", "(1 4 9)"},
+		{"(map first '((1 2) (3)))", "(1 3)"},
+		{"(let ((k 10)) (map (lambda (n) (+ n k)) '(1 2)))", "(11 12)"},
+		{"(filter (lambda (n) (> n 2)) '(1 3 2 4))", "(3 4)"},
+		{"(reduce + 0 '(1 2 3 4))", "10"},
+		{"(map last (sort-by first '((3 \"a\") (1 \"b\") (2 \"c\") (1 \"d\"))))", "(b d c a)"},
+		{"(apply + 1 2 '(3 4))", "10"},
+		{"(apply (lambda (a &rest r) r) '(1 2 3))", "(2 3)"},
+		{"(def total 0) (for-each (lambda (n) (set! total (+ total n))) '(1 2 3)) total", "6"},
+		{"(def map (lambda (l f) (f l))) (map '(1) first)", "1"},
+		{"((lambda (list) (first list)) '(5))", "5"},
+		{"(try (map (lambda (n) (throw n)) '(1 2)) (catch e (list 'caught e)))", "(caught 1)"},
+		{"(map (lambda (n) (try (throw n) (catch e (* e 10)))) '(1 2))", "(10 20)"},
+		{"(map (lambda (n) (+ n \"a\")) '(1))", "ERROR: 1:18: attempted to call + with unsupported type STRING (a)"},
+		{"(map 1 '(1))", "ERROR: 1:1: 1 is not a function"},
+		{"(sort-by (lambda (n) n) '(1 \"a\"))", "ERROR: 1:1: attempted to call sort-by with mismatched types STRING (a) and NUMBER (1)"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that an error that is not handled has the stack trace of the functions
+// it occurred in, in the same form as the VM.
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"(def f (lambda (x)\n  (+ x \"a\")))\n(def g (lambda (x)\n  (list (f x))))\n(g 1)",
+			"  at f (2:3)\n  at g (4:9)\n  at <main> (5:1)\n",
+		},
+		{
+			"(def f (lambda (x) (first x)))\n(def g (lambda (x) (f x)))\n(list (g 1))",
+			"  at f (1:20)\n  at <main> (3:7)\n",
+		},
+		{
+			"(def f (lambda (x)\n  (print x)\n  (+ x \"a\")))\n(def g (lambda (x)\n  (list (f x))\n  x))\n(g 1)",
+			"  at f (3:3)\n  at g (5:9)\n  at <main> (7:1)\n",
+		},
+		{
+			"(def f (lambda (x)\n  (let ((y x))\n    (try (first y) (catch e (first e))))))\n(f 1)",
+			"  at f (3:29)\n  at <main> (4:1)\n",
+		},
+		{
+			"(let loop ((i 0))\n  (if (< i 2) (loop (+ i 1)) (first i)))",
+			"  at loop (2:30)\n  at <main> (1:1)\n",
+		},
+		{
+			"(map (lambda (n) (first n)) '(1))",
+			"  at lambda (1:18)\n  at <main> (1:1)\n",
+		},
+		{
+			"(def f (lambda (a b) a))\n(def g (lambda () (f 1)))\n(g)",
+			"  at g (2:19)\n  at <main> (3:1)\n",
+		},
+		{
+			"(def f (lambda () (throw 'oops)))\n(try (f) (finally 1))",
+			"  at <main> (2:1)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env)
+		err, ok := result.(*object.ErrorObject)
+
+		if !ok {
+			t.Fatalf("expected error for %s, got=%s", tt.input, result.Inspect())
+		}
+
+		if err.Trace.String() != tt.expected {
+			t.Errorf("wrong trace for %s: want=%q got=%q", tt.input, tt.expected, err.Trace.String())
+		}
+	}
+}
+
+// Test that a program exceeding one of its limits results in an error for
+// that limit, which try expressions do not catch, and that a program within
+// its limits runs to completion.
+func TestLimits(t *testing.T) {
+	loop := "(def loop (lambda (n) (loop (+ n 1))))\n"
+	count := "(def f (lambda (n) (if (= n 0) 0 (+ 1 (f (- n 1))))))\n"
+	build := "(def build (lambda (n l) (if (= n 0) l (build (- n 1) (push l n)))))\n"
+
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{loop + "(loop 0)", object.Limits{Steps: 1000}, object.StepLimit},
+		{loop + "(try (loop 0) (catch e 'caught))", object.Limits{Steps: 1000}, object.StepLimit},
+		{loop + "(loop 0)", object.Limits{Timeout: time.Millisecond}, object.TimeLimit},
+		{count + "(f 20)", object.Limits{CallDepth: 10}, object.CallDepthLimit},
+		{count + "(f 5)", object.Limits{CallDepth: 10, Steps: 1000}, ""},
+		{count + "(f 200)", object.Limits{StackSize: 100}, object.StackSizeLimit},
+		{count + "(f 100000)", object.Limits{}, object.StackSizeLimit},
+		{count + "(f 1000)", object.Limits{StackSize: 10000, CallDepth: 2000}, ""},
+		{build + "(build 1000 '())", object.Limits{Allocations: 100}, object.AllocationLimit},
+		{build + "(build 10 '())", object.Limits{Allocations: 100}, ""},
+		{loop + "(map (lambda (n) (loop n)) '(1))", object.Limits{Steps: 1000}, object.StepLimit},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := EvaluateContext(context.Background(), program, env, tt.limits)
+		err, ok := result.(*object.ErrorObject)
+
+		if tt.expected == "" {
+			if ok {
+				t.Errorf("unexpected error for %s: %s", tt.input, err.Error)
+			}
+
+			continue
+		}
+
+		if !ok || err.Limit == nil {
+			t.Fatalf("expected limit error for %s, got=%s", tt.input, result.Inspect())
+		}
+
+		if err.Limit.Limit != tt.expected {
+			t.Errorf("wrong limit for %s: want=%q got=%q", tt.input, tt.expected, err.Limit.Limit)
+		}
+	}
+}
+
+// Test that a program stops when the context it is evaluated in is
+// cancelled.
+func TestCancellation(t *testing.T) {
+	l := lexer.New("(def loop (lambda (n) (loop (+ n 1))))\n(loop 0)")
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond, cancel)
+
+	result := EvaluateContext(ctx, program, env, object.Limits{})
+	err, ok := result.(*object.ErrorObject)
+
+	if !ok || err.Limit == nil || !errors.Is(err.Limit, context.Canceled) {
+		t.Fatalf("expected cancellation, got=%s", result.Inspect())
+	}
+
+	if err.Error != "execution cancelled: context canceled" {
+		t.Errorf("wrong error message: %q", err.Error)
+	}
+}
+
+// Test that errors and thrown values are caught by the innermost try that
+// surrounds them, that cleanup runs whether or not the body fails, and that
+// uncaught values become errors.
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(try 1 (catch e 2))", "1"},
+		{"(try (throw 1) (catch e (+ e 1)))", "2"},
+		{"(try (/ 1 0) (catch e (str e)))", "Attempted to divide by 0"},
+		{"(try (throw 'a) (catch e e))", "a"},
+		{"(try (throw 1) (catch e))", "null"},
+		{"(def f (lambda (n) (if (= n 0) (throw 'bottom) (+ 1 (f (- n 1)))))) (try (f 50) (catch e e))", "bottom"},
+		{"(def log '()) (def r (try 1 (finally (set! log (push log 'cleanup))))) (list r log)", "(1 (cleanup))"},
+		{"(def log '()) (try (try (throw 'inner) (finally (set! log (push log 'cleanup)))) (catch e (push log e)))", "(cleanup inner)"},
+		{"(def log '()) (def r (try (throw 1) (catch e (set! log (push log e)) 'ok) (finally (set! log (push log 2))))) (list r log)", "(ok (1 2))"},
+		{"(try (try (throw 1) (catch e (throw (+ e 1)))) (catch e e))", "2"},
+		{"(try (try (/ 1 0) (catch e (throw e))) (catch e (str e)))", "Attempted to divide by 0"},
+		{"(def f (lambda () (try (g) (catch e 'caught)))) (def g (lambda () (throw 1))) (f)", "caught"},
+		{"(throw 'oops)", "ERROR: 1:1: uncaught exception: oops"},
+		{"(try (/ 1 0) (finally 1))", "ERROR: 1:6: Attempted to divide by 0"},
+		{"(try 1 (finally (throw 2)))", "ERROR: 1:17: uncaught exception: 2"},
+		{"(try 1)", "ERROR: 1:1: try expression must have a catch or finally clause"},
+		{"(try 1 (catch 2 3))", "ERROR: 1:8: catch clause must be of the form (catch name body...)"},
+		{"(try (finally 1) 2)", "ERROR: 1:18: catch and finally clauses must be at the end of try expression"},
+		{"(finally 1)", "ERROR: 1:1: finally clause outside of try expression"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env).Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
 func runEvalTests(t *testing.T, tests []evaluatorTest) {
 	t.Helper()
 
@@ -341,6 +980,8 @@ func runEvalTests(t *testing.T, tests []evaluatorTest) {
 			switch tt.expectedType {
 			case "string":
 				testStringLiteral(t, result, expected)
+			case "":
+				testErrorObject(t, result, expected)
 			default:
 				t.Errorf("invalid expected type %s", tt.expectedType)
 			}
@@ -368,6 +1009,20 @@ func testStringLiteral(t *testing.T, obj object.Object, expected string) {
 	}
 }
 
+func testErrorObject(t *testing.T, obj object.Object, expected string) {
+	t.Helper()
+
+	err, ok := obj.(*object.ErrorObject)
+
+	if !ok {
+		t.Fatalf("expected error, got=%T(%+v)", obj, obj)
+	}
+
+	if fmt.Sprintf("%s: %s", err.Pos, err.Error) != expected {
+		t.Errorf("%s: %s != %s", err.Pos, err.Error, expected)
+	}
+}
+
 func testBooleanLiteral(t *testing.T, obj object.Object, expected bool) {
 	t.Helper()
 
@@ -395,3 +1050,29 @@ func testFloatLiteral(t *testing.T, obj object.Object, expected float64) {
 		t.Errorf("%f != %f", float.Value, expected)
 	}
 }
+
+// Ensure errors produced during evaluation record the position of the
+// innermost expression that caused them.
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(+ 1\n   missing)", "ERROR: test.lsp:2:4: No such item: missing"},
+		{"(list 1\n  (len 1))", "ERROR: test.lsp:2:3: attempted to call len with unsupported type NUMBER (1)"},
+		{"(def x 1)\n(x)", "ERROR: test.lsp:2:1: 1 is not a function"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("test.lsp", tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		env := object.NewEnvironment(nil)
+
+		result := Evaluate(program, env)
+
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong error: expected=%q got=%q", tt.expected, result.Inspect())
+		}
+	}
+}
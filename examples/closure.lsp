@@ -1,3 +1,4 @@
+; Builds closures that each capture a different value of n.
 (def addBuilder (lambda (n) (lambda (m) (+ n m))))
 
 (def addTwo (addBuilder 2))
@@ -6,20 +6,12 @@
 
 (def range (lambda (n) (rangeBuilder '() 0 n)))
 
-(def reduce (lambda (lst fn acc)
-              (if (= 0 (len lst))
-                acc 
-                (reduce (rest lst) fn (fn acc (first lst))))))
-
-(def map (lambda (lst fn)
-           (reduce lst (lambda (acc n) (push acc (fn n))) '())))
-
 (def fibIter (lambda (a b n) (if (= n 0) b (fibIter b (+ a b) (- n 1)))))
 
 (def fib (lambda (n) (fibIter 0 1 n)))
 
 (def lst (range 75))
 
-(def result (map lst fib))
+(def result (map fib lst))
 
 (print result)
@@ -1,11 +1,3 @@
-(def reduce (lambda (lst fn acc)
-              (if (= 0 (len lst))
-                acc 
-                (reduce (rest lst) fn (fn acc (first lst))))))
-
-(def map (lambda (lst fn)
-           (reduce lst (lambda (acc n) (push acc (fn n))) '())))
-
 (def printList (lambda (l)
                  (print (str "List is " l))))
 
@@ -15,4 +7,4 @@
 (def lst (range 1000))
 
 (printList lst)
-(printList (map lst (lambda (n) (- (* n n) (/ n 2)))))
+(printList (map (lambda (n) (- (* n n) (/ n 2))) lst))
@@ -0,0 +1,613 @@
+// The expander package contains the definition of the Expander type, which
+// expands the macros in a program into the expressions they stand for, before
+// the program is evaluated or compiled.
+package expander
+
+import (
+	"context"
+	"fmt"
+	"lisp/ast"
+	"lisp/evaluator"
+	"lisp/object"
+	"lisp/token"
+	"slices"
+)
+
+// The most macro calls that can be expanded inside one another, which stops
+// a macro that always expands to a call to itself.
+const MaxExpansionDepth = 1000
+
+// The forms that are built into the language, which cannot be redefined as
+// macros.
+var specialForms = []string{
+	"if", "def", "set!", "lambda", "let", "let*", "letrec", "cond", "case",
+	"when", "unless", "and", "or", "quote", "quasiquote", "unquote",
+	"unquote-splicing", "defmacro", "macroexpand", "try", "catch", "finally",
+	"throw", "import", "export",
+}
+
+// An Expander holds the macros defined by the programs it has expanded, so
+// that macros can be used by every program expanded after their definition,
+// as in the repl. It also holds the modules those programs import, so that
+// each module is read once.
+type Expander struct {
+	macros  map[string]*object.LambdaObject // each macro, by name
+	env     *object.Environment             // the environment macro bodies are evaluated in
+	depth   int                             // the number of macro calls currently being expanded
+	modules *modules                        // the modules read, shared with the Expanders of imported modules
+	exports []*ast.Identifier               // the names exported by the programs expanded
+}
+
+// Return the address of a new Expander instance without any macros.
+func New() *Expander {
+	return &Expander{
+		macros:  map[string]*object.LambdaObject{},
+		env:     object.NewEnvironment(nil),
+		modules: &modules{read: map[string]*ast.Module{}},
+	}
+}
+
+// Expand returns a copy of the provided program with every macro call
+// replaced by its expansion. Return an error if there is a problem defining
+// or expanding a macro.
+//
+// Macros are defined by top-level expressions of the form
+// (defmacro name (param ...) body...), and can be used by the expressions
+// after their definition. A macro is a lambda that is called with its
+// arguments as quoted data when a call to it is expanded, and results in the
+// data of the expression the call stands for. Each definition is replaced by
+// the name of the macro as a quoted symbol.
+//
+// Macro bodies are evaluated while expanding, before the program runs, so
+// they can use builtins but not the variables defined by the program.
+//
+// Top-level import expressions are replaced by an Import of the module they
+// name, which is read and expanded along with the modules it imports. Export
+// expressions, of the form (export name...), are replaced by the list of
+// names they export as quoted data.
+func (e *Expander) Expand(program *ast.Program) (*ast.Program, error) {
+	return e.ExpandContext(context.Background(), program, object.Limits{})
+}
+
+// Expand the provided program as Expand does, evaluating the bodies of the
+// macros it calls, including those of the modules it imports, within the
+// provided Limits and context. The error returned when expanding exceeds
+// them wraps a LimitError.
+func (e *Expander) ExpandContext(ctx context.Context, program *ast.Program, limits object.Limits) (*ast.Program, error) {
+	previous := e.modules.meter
+	e.modules.meter = evaluator.NewMeter(ctx, limits)
+
+	defer func() { e.modules.meter = previous }()
+
+	return e.expandProgram(program)
+}
+
+// Expand the provided program with the Meter already given to the Expander.
+func (e *Expander) expandProgram(program *ast.Program) (*ast.Program, error) {
+	expanded := &ast.Program{}
+
+	for _, expr := range program.Expressions {
+		sExpr, ok := expr.(*ast.SExpression)
+
+		switch {
+		case ok && isForm(sExpr, "defmacro"):
+			err := e.defineMacro(sExpr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expanded.Expressions = append(expanded.Expressions, quote(sExpr.Token, sExpr.Args[0]))
+			continue
+		case ok && isForm(sExpr, "import"):
+			imp, err := e.importModule(sExpr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expanded.Expressions = append(expanded.Expressions, imp)
+			continue
+		case ok && isForm(sExpr, "export"):
+			err := e.export(sExpr)
+
+			if err != nil {
+				return nil, err
+			}
+
+			names := &ast.SExpression{Token: sExpr.Token, Fn: sExpr.Args[0], Args: sExpr.Args[1:]}
+			expanded.Expressions = append(expanded.Expressions, quote(sExpr.Token, names))
+			continue
+		}
+
+		result, err := e.expand(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expanded.Expressions = append(expanded.Expressions, result)
+	}
+
+	return expanded, nil
+}
+
+// Define the macro described by the provided defmacro expression.
+func (e *Expander) defineMacro(expr *ast.SExpression) error {
+	if len(expr.Args) < 2 {
+		return fmt.Errorf("%s: not enough arguments for defmacro", expr.Pos())
+	}
+
+	name, ok := expr.Args[0].(*ast.Identifier)
+
+	if !ok {
+		return fmt.Errorf("%s: first argument to defmacro must be identifier", expr.Args[0].Pos())
+	}
+
+	if slices.Contains(specialForms, name.String()) {
+		return fmt.Errorf("%s: cannot define special form %s as a macro", name.Pos(), name)
+	}
+
+	params, err := object.ParseParameters(expr.Args[1])
+
+	if err != nil {
+		return err
+	}
+
+	// Macros used in the body are expanded now, as the body is evaluated
+	// without expanding it again.
+	body, err := e.expandAll(expr.Args[2:])
+
+	if err != nil {
+		return err
+	}
+
+	e.macros[name.String()] = &object.LambdaObject{
+		Params: params,
+		Env:    e.env,
+		Body:   body,
+	}
+
+	return nil
+}
+
+// Return the provided expression with every macro call inside it expanded.
+//
+// Parts of special forms that are not expressions, such as parameter lists,
+// let binding names and case datums, are kept as they are, as are quoted
+// expressions.
+func (e *Expander) expand(expr ast.Expression) (ast.Expression, error) {
+	sExpr, ok := expr.(*ast.SExpression)
+
+	if !ok || sExpr.Fn == nil {
+		return expr, nil
+	}
+
+	ident, ok := sExpr.Fn.(*ast.Identifier)
+
+	if !ok {
+		return e.expandElements(sExpr)
+	}
+
+	switch ident.String() {
+	case "quote":
+		return expr, nil
+	case "quasiquote":
+		return e.expandQuasiquote(sExpr, 0)
+	case "defmacro", "import", "export":
+		return nil, fmt.Errorf("%s: %s must be at the top level of the program", sExpr.Pos(), ident)
+	case "macroexpand":
+		return e.expandMacroexpand(sExpr)
+	case "lambda":
+		return e.expandLambda(sExpr)
+	case "let", "let*", "letrec":
+		return e.expandLet(sExpr)
+	case "cond":
+		return e.expandCond(sExpr)
+	case "case":
+		return e.expandCase(sExpr)
+	}
+
+	if _, ok := e.macros[ident.String()]; !ok {
+		return e.expandElements(sExpr)
+	}
+
+	expansion, err := e.expandMacro(sExpr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The expansion can itself contain macro calls, including a call to the
+	// same macro.
+	e.depth++
+	defer func() { e.depth-- }()
+
+	if e.depth > MaxExpansionDepth {
+		return nil, fmt.Errorf("%s: expanding %s exceeded the maximum depth of %d macro calls", sExpr.Pos(), ident, MaxExpansionDepth)
+	}
+
+	return e.expand(expansion)
+}
+
+// Call the macro named by the provided SExpression with its arguments as
+// quoted data, and return the expression represented by the result.
+//
+// The parts of the expansion that were taken from the arguments are the
+// expressions they were quoted from, so they keep their place in the source.
+// Everything else is given the position of the call.
+func (e *Expander) expandMacro(call *ast.SExpression) (ast.Expression, error) {
+	name := call.Fn.String()
+	sources := sources{}
+	args := []object.Object{}
+
+	for _, arg := range call.Args {
+		args = append(args, sources.quote(arg))
+	}
+
+	macro := e.macros[name]
+
+	// Report wrong arguments in the same way as calls to lambdas.
+	_, err := macro.Params.Signature.Bind(args)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", call.Pos(), name, err)
+	}
+
+	e.env.SetMeter(e.modules.meter)
+	result := evaluator.Apply(name, macro, args...)
+
+	if errObj, ok := result.(*object.ErrorObject); ok {
+		if errObj.Limit != nil {
+			return nil, fmt.Errorf("%s: error expanding %s: %w", call.Pos(), name, errObj.Limit)
+		}
+
+		msg := errObj.Error
+
+		if errObj.Pos.IsValid() {
+			msg = fmt.Sprintf("%s: %s", errObj.Pos, msg)
+		}
+
+		return nil, fmt.Errorf("%s: error expanding %s: %s", call.Pos(), name, msg)
+	}
+
+	expansion, err := sources.unquote(result, call.Token)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: error expanding %s: %s", call.Pos(), name, err)
+	}
+
+	return expansion, nil
+}
+
+// Expand a macroexpand expression, of the form (macroexpand 'expr), into the
+// quoted expansion of expr. The expression is expanded until it is no longer
+// a macro call, but the expressions inside it are not expanded.
+func (e *Expander) expandMacroexpand(expr *ast.SExpression) (ast.Expression, error) {
+	if len(expr.Args) != 1 {
+		return nil, fmt.Errorf("%s: incorrect number of values in macroexpand expression", expr.Pos())
+	}
+
+	quoted, ok := expr.Args[0].(*ast.SExpression)
+
+	if !ok || !isForm(quoted, "quote") || len(quoted.Args) != 1 {
+		return nil, fmt.Errorf("%s: macroexpand requires a quoted expression, got %s", expr.Args[0].Pos(), expr.Args[0])
+	}
+
+	expansion := quoted.Args[0]
+
+	for depth := 0; ; depth++ {
+		call, ok := expansion.(*ast.SExpression)
+
+		if !ok || call.Fn == nil {
+			break
+		}
+
+		if _, ok := e.macros[call.Fn.String()]; !ok {
+			break
+		}
+
+		if depth == MaxExpansionDepth {
+			return nil, fmt.Errorf("%s: expanding %s exceeded the maximum depth of %d macro calls", call.Pos(), call.Fn, MaxExpansionDepth)
+		}
+
+		var err error
+		expansion, err = e.expandMacro(call)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return quote(quoted.Token, expansion), nil
+}
+
+// Expand the body of a lambda expression, along with the defaults in its
+// parameter list.
+func (e *Expander) expandLambda(expr *ast.SExpression) (ast.Expression, error) {
+	if len(expr.Args) == 0 {
+		return expr, nil
+	}
+
+	params := expr.Args[0]
+
+	if list, ok := params.(*ast.SExpression); ok && list.Fn != nil {
+		expandedList := copyExpression(list)
+
+		for i, p := range append([]ast.Expression{list.Fn}, list.Args...) {
+			// Parameters with a default take the form (name default).
+			if param, ok := p.(*ast.SExpression); ok && len(param.Args) == 1 {
+				def, err := e.expand(param.Args[0])
+
+				if err != nil {
+					return nil, err
+				}
+
+				param = copyExpression(param)
+				param.Args = []ast.Expression{def}
+				p = param
+			}
+
+			if i == 0 {
+				expandedList.Fn = p
+			} else {
+				expandedList.Args = append(expandedList.Args, p)
+			}
+		}
+
+		params = expandedList
+	}
+
+	body, err := e.expandAll(expr.Args[1:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := copyExpression(expr)
+	expanded.Args = append([]ast.Expression{params}, body...)
+
+	return expanded, nil
+}
+
+// Expand the values bound by a let, let* or letrec expression, along with its
+// body.
+func (e *Expander) expandLet(expr *ast.SExpression) (ast.Expression, error) {
+	expanded := copyExpression(expr)
+	start := 0
+
+	// The name of a named let comes before its bindings.
+	if len(expr.Args) > 0 {
+		if _, ok := expr.Args[0].(*ast.Identifier); ok {
+			expanded.Args = append(expanded.Args, expr.Args[0])
+			start = 1
+		}
+	}
+
+	if len(expr.Args) <= start {
+		expanded.Args = expr.Args
+		return expanded, nil
+	}
+
+	bindings := expr.Args[start]
+
+	if list, ok := bindings.(*ast.SExpression); ok && list.Fn != nil {
+		expandedList := copyExpression(list)
+
+		for i, b := range append([]ast.Expression{list.Fn}, list.Args...) {
+			if binding, ok := b.(*ast.SExpression); ok && len(binding.Args) == 1 {
+				value, err := e.expand(binding.Args[0])
+
+				if err != nil {
+					return nil, err
+				}
+
+				binding = copyExpression(binding)
+				binding.Args = []ast.Expression{value}
+				b = binding
+			}
+
+			if i == 0 {
+				expandedList.Fn = b
+			} else {
+				expandedList.Args = append(expandedList.Args, b)
+			}
+		}
+
+		bindings = expandedList
+	}
+
+	body, err := e.expandAll(expr.Args[start+1:])
+
+	if err != nil {
+		return nil, err
+	}
+
+	expanded.Args = append(expanded.Args, bindings)
+	expanded.Args = append(expanded.Args, body...)
+
+	return expanded, nil
+}
+
+// Expand the tests and bodies of the clauses of a cond expression.
+func (e *Expander) expandCond(expr *ast.SExpression) (ast.Expression, error) {
+	expanded := copyExpression(expr)
+
+	for _, arg := range expr.Args {
+		clause, ok := arg.(*ast.SExpression)
+
+		if ok {
+			var err error
+			arg, err = e.expandElements(clause)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		expanded.Args = append(expanded.Args, arg)
+	}
+
+	return expanded, nil
+}
+
+// Expand the key of a case expression, along with the body of each clause.
+func (e *Expander) expandCase(expr *ast.SExpression) (ast.Expression, error) {
+	if len(expr.Args) == 0 {
+		return expr, nil
+	}
+
+	key, err := e.expand(expr.Args[0])
+
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := copyExpression(expr)
+	expanded.Args = append(expanded.Args, key)
+
+	for _, arg := range expr.Args[1:] {
+		if clause, ok := arg.(*ast.SExpression); ok && clause.Fn != nil {
+			body, err := e.expandAll(clause.Args)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expandedClause := copyExpression(clause)
+			expandedClause.Args = body
+			arg = expandedClause
+		}
+
+		expanded.Args = append(expanded.Args, arg)
+	}
+
+	return expanded, nil
+}
+
+// Expand only the unquoted parts of a quasiquoted expression, as the rest of
+// it is data. depth is the number of quasiquotes the expression is inside,
+// less the number of unquotes.
+func (e *Expander) expandQuasiquote(expr *ast.SExpression, depth int) (ast.Expression, error) {
+	if expr.Fn == nil {
+		return expr, nil
+	}
+
+	switch {
+	case isForm(expr, "quasiquote"):
+		depth++
+	case isForm(expr, "unquote"), isForm(expr, "unquote-splicing"):
+		if depth == 1 {
+			args, err := e.expandAll(expr.Args)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expanded := copyExpression(expr)
+			expanded.Args = args
+
+			return expanded, nil
+		}
+
+		depth--
+	}
+
+	expanded := copyExpression(expr)
+
+	for i, el := range append([]ast.Expression{expr.Fn}, expr.Args...) {
+		if sExpr, ok := el.(*ast.SExpression); ok {
+			var err error
+			el, err = e.expandQuasiquote(sExpr, depth)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if i == 0 {
+			expanded.Fn = el
+		} else {
+			expanded.Args = append(expanded.Args, el)
+		}
+	}
+
+	return expanded, nil
+}
+
+// Expand the first element and each of the arguments of the provided
+// SExpression.
+func (e *Expander) expandElements(expr *ast.SExpression) (*ast.SExpression, error) {
+	fn, err := e.expand(expr.Fn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	args, err := e.expandAll(expr.Args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := copyExpression(expr)
+	expanded.Fn = fn
+	expanded.Args = args
+
+	return expanded, nil
+}
+
+// Expand each of the provided expressions.
+func (e *Expander) expandAll(exprs []ast.Expression) ([]ast.Expression, error) {
+	expanded := []ast.Expression{}
+
+	for _, expr := range exprs {
+		result, err := e.expand(expr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = append(expanded, result)
+	}
+
+	return expanded, nil
+}
+
+// Return a copy of the provided SExpression without its arguments, so that
+// expanding never changes the AST it was given.
+func copyExpression(expr *ast.SExpression) *ast.SExpression {
+	return &ast.SExpression{
+		Token: expr.Token,
+		Fn:    expr.Fn,
+		Name:  expr.Name,
+	}
+}
+
+// Build a quote expression of the provided expression.
+func quote(tok token.Token, expr ast.Expression) *ast.SExpression {
+	return &ast.SExpression{
+		Token: tok,
+		Fn:    identifier("quote", tok.Pos),
+		Args:  []ast.Expression{expr},
+	}
+}
+
+// Build an Identifier with the provided name at the provided position.
+func identifier(name string, pos token.Position) *ast.Identifier {
+	return &ast.Identifier{
+		Token: token.Token{
+			Type:    token.IDENT,
+			Literal: name,
+			Pos:     pos,
+		},
+	}
+}
+
+// Report whether the SExpression is a call to the form with the provided
+// name.
+func isForm(expr *ast.SExpression, name string) bool {
+	ident, ok := expr.Fn.(*ast.Identifier)
+
+	return ok && ident.String() == name
+}
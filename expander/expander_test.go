@@ -0,0 +1,466 @@
+package expander
+
+import (
+	"context"
+	"errors"
+	"lisp/ast"
+	"lisp/compiler"
+	"lisp/evaluator"
+	"lisp/lexer"
+	"lisp/object"
+	"lisp/parser"
+	"lisp/vm"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that macro calls are replaced by their expansions, including macro
+// calls within expansions, while the parts of expressions that are not
+// evaluated are kept as they are.
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(+ 1 2)", "(+ 1 2)"},
+		{"(defmacro one () 1) (+ (one) (one))", "(quote one)(+ 1 1)"},
+		{"(defmacro id (x) x) (id (id (+ 1 2)))", "(quote id)(+ 1 2)"},
+		{
+			"(defmacro unless2 (test &rest body) `(if ,test null (do ,@body))) (unless2 false 1 2)",
+			"(quote unless2)(if false null (do 1 2))",
+		},
+		{
+			"(defmacro inc (x) `(+ ,x 1)) (defmacro inc2 (x) `(inc (inc ,x))) (inc2 1)",
+			"(quote inc)(quote inc2)(+ (+ 1 1) 1)",
+		},
+		{"(defmacro a () 1) '(a)", "(quote a)(quote (a))"},
+		{"(defmacro a () 1) (lambda (a) (a))", "(quote a)(lambda (a) 1)"},
+		{"(defmacro a () 1) (lambda (&optional (b (a))) b)", "(quote a)(lambda (&optional (b 1)) b)"},
+		{"(defmacro a () 1) (let ((a (a))) a)", "(quote a)(let ((a 1)) a)"},
+		{"(defmacro a () 1) (let loop ((i (a))) (a))", "(quote a)(let loop ((i 1)) 1)"},
+		{"(defmacro a () 1) (cond ((a) (a)) (else (a)))", "(quote a)(cond (1 1) (else 1))"},
+		{"(defmacro a () 1) (case (a) ((a) (a)))", "(quote a)(case 1 ((a) 1))"},
+		{"(defmacro a () 1) `((a) ,(a) `(,(a) ,,(a)))", "(quote a)(quasiquote ((a) (unquote 1) (quasiquote ((unquote (a)) (unquote (unquote 1))))))"},
+		{"(defmacro a () '(+ 1 2)) (macroexpand '(a))", "(quote a)(quote (+ 1 2))"},
+		{"(defmacro a () '(b)) (defmacro b () '(+ (a))) (macroexpand '(a))", "(quote a)(quote b)(quote (+ (a)))"},
+		{"(defmacro name (x) (str x)) (name hello)", `(quote name)hello`},
+		{"(defmacro t () true) (defmacro n () null) (list (t) (n))", "(quote t)(quote n)(list true null)"},
+	}
+
+	for _, tt := range tests {
+		expanded, err := New().Expand(parse(tt.input))
+
+		if err != nil {
+			t.Fatalf("expander error for %q: %s", tt.input, err)
+		}
+
+		if expanded.String() != tt.expected {
+			t.Errorf("wrong expansion of %q:\n  want=%q\n  got=%q", tt.input, tt.expected, expanded.String())
+		}
+	}
+}
+
+// Test that an expanded program gives the same result whether it is evaluated
+// or compiled and run on the VM.
+func TestMacrosInBothEngines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input: `
+            (defmacro swap! (a b)
+              (let ((tmp (gensym)))
+                ` + "`" + `(let ((,tmp ,a)) (set! ,a ,b) (set! ,b ,tmp))))
+            (def x 1)
+            (def y 2)
+            (swap! x y)
+            (list x y)
+            `,
+			expected: "(2 1)",
+		},
+		{
+			// The name introduced by the macro does not capture the
+			// variable of the same name passed to it.
+			input: `
+            (defmacro swap! (a b)
+              (let ((tmp (gensym)))
+                ` + "`" + `(let ((,tmp ,a)) (set! ,a ,b) (set! ,b ,tmp))))
+            (def tmp 1)
+            (def y 2)
+            (swap! tmp y)
+            (list tmp y)
+            `,
+			expected: "(2 1)",
+		},
+		{
+			input: `
+            (defmacro while (test &rest body)
+              (let ((loop (gensym "loop")))
+                ` + "`" + `(let ,loop () (when ,test ,@body (,loop)))))
+            (def i 0)
+            (def total 0)
+            (while (< i 10000) (set! total (+ total i)) (set! i (+ i 1)))
+            total
+            `,
+			expected: "49995000",
+		},
+		{
+			input: `
+            (defmacro my-and (&rest xs)
+              (cond ((= (len xs) 0) true)
+                    ((= (len xs) 1) (first xs))
+                    (else ` + "`" + `(if ,(first xs) (my-and ,@(rest xs)) false))))
+            (list (my-and) (my-and 1 2 3) (my-and 1 false 3))
+            `,
+			expected: "(true 3 false)",
+		},
+		{
+			input: `
+            (defmacro unless2 (test &key (then null) (else null))
+              ` + "`" + `(if ,test ,else ,then))
+            (unless2 false :then 'yes :else 'no)
+            `,
+			expected: "yes",
+		},
+		{
+			input:    "(defmacro a () '(b)) (defmacro b () ''done) (macroexpand '(a))",
+			expected: "(quote done)",
+		},
+		{
+			input:    "(defmacro m () 1) (m)",
+			expected: "1",
+		},
+		{
+			input:    "(defmacro m () 1)",
+			expected: "m",
+		},
+	}
+
+	for _, tt := range tests {
+		expanded, err := New().Expand(parse(tt.input))
+
+		if err != nil {
+			t.Fatalf("expander error for %q: %s", tt.input, err)
+		}
+
+		evaluated := evaluator.Evaluate(expanded, object.NewEnvironment(nil))
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong evaluated result for %q: want=%s got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		comp := compiler.New()
+		err = comp.Compile(expanded)
+
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		machine := vm.New(comp.Bytecode())
+		err = machine.Run()
+
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		if machine.LastPoppedStackElem().Inspect() != tt.expected {
+			t.Errorf("wrong vm result for %q: want=%s got=%s", tt.input, tt.expected, machine.LastPoppedStackElem().Inspect())
+		}
+	}
+}
+
+// Test that macros defined by one program can be used by the programs
+// expanded after it by the same Expander.
+func TestMacrosPersistBetweenPrograms(t *testing.T) {
+	e := New()
+
+	_, err := e.Expand(parse("(defmacro one () 1)"))
+
+	if err != nil {
+		t.Fatalf("expander error: %s", err)
+	}
+
+	expanded, err := e.Expand(parse("(one)"))
+
+	if err != nil {
+		t.Fatalf("expander error: %s", err)
+	}
+
+	if expanded.String() != "1" {
+		t.Errorf("wrong expansion: want=%q got=%q", "1", expanded.String())
+	}
+}
+
+// Test that problems with defining or expanding macros are reported with the
+// position they occur at, and that the parts of an expansion taken from the
+// arguments of a macro call keep their original positions.
+func TestExpanderErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(defmacro m)", "test.lsp:1:1: not enough arguments for defmacro"},
+		{"(defmacro 1 ())", "test.lsp:1:11: first argument to defmacro must be identifier"},
+		{"(defmacro if (a) a)", "test.lsp:1:11: cannot define special form if as a macro"},
+		{"(defmacro throw (a) a)", "test.lsp:1:11: cannot define special form throw as a macro"},
+		{"(defmacro m (a a) a)", "test.lsp:1:16: duplicate parameter a"},
+		{"(lambda () (defmacro m () 1))", "test.lsp:1:12: defmacro must be at the top level of the program"},
+		{"(defmacro m (a) a)\n(m)", "test.lsp:2:1: m: wrong number of arguments: expected=1 got=0"},
+		{"(defmacro m ()\n  (+ 1 'a))\n(m)", "test.lsp:3:1: error expanding m: test.lsp:2:3: attempted to call + with unsupported type SYMBOL (a)"},
+		{"(defmacro m () (lambda () 1)) (m)", "test.lsp:1:31: error expanding m: expansion must only contain data, got (lambda () 1)"},
+		{"(defmacro m () '(m)) (m)", "test.lsp:1:22: expanding m exceeded the maximum depth of 1000 macro calls"},
+		{"(macroexpand 1)", "test.lsp:1:14: macroexpand requires a quoted expression, got 1"},
+		{"(macroexpand)", "test.lsp:1:1: incorrect number of values in macroexpand expression"},
+	}
+
+	for _, tt := range tests {
+		_, err := New().Expand(parseFile("test.lsp", tt.input))
+
+		if err == nil {
+			t.Fatalf("expected expander error for %q, got none", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error: expected=%q got=%q", tt.expected, err)
+		}
+	}
+
+	expanded, err := New().Expand(parseFile("test.lsp", "(defmacro m (x) `(+ 1 ,x))\n(m\n  missing)"))
+
+	if err != nil {
+		t.Fatalf("expander error: %s", err)
+	}
+
+	err = compiler.New().Compile(expanded)
+	expected := "test.lsp:3:3: undefined variable missing"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected=%q got=%q", expected, err)
+	}
+}
+
+// Test that macro bodies are evaluated within the Limits given to
+// ExpandContext, including those of imported modules, so that a macro that
+// never finishes expanding stops with a LimitError.
+func TestExpansionLimits(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"endless.lsp": "(defmacro m () (let loop () (loop)))\n(m)",
+	})
+	main := filepath.Join(dir, "main.lsp")
+
+	tests := []string{
+		"(defmacro m () (let loop () (loop)))\n(m)",
+		`(import "endless.lsp")`,
+	}
+
+	for _, input := range tests {
+		_, err := New().ExpandContext(context.Background(), parseFile(main, input), object.Limits{Steps: 1000})
+
+		var limitErr *object.LimitError
+
+		if !errors.As(err, &limitErr) || limitErr.Limit != object.StepLimit {
+			t.Errorf("expected step limit error for %q, got=%v", input, err)
+		}
+	}
+}
+
+// Test that imported modules run once, however many times they are imported,
+// and that their exports are available under the namespace they are imported
+// as, in both engines.
+func TestModulesInBothEngines(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"lib/counter.lsp": `
+            (export inc! n)
+            (def n 0)
+            (def inc! (lambda () (set! n (+ n 1)) n))
+            `,
+		"lib/math.lsp": `
+            (import "counter.lsp")
+            (export square twice)
+            (defmacro sq (x) ` + "`" + `(* ,x ,x))
+            (def square (lambda (x) (counter/inc!) (sq x)))
+            (def twice (lambda (f x) (f (f x))))
+            `,
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(import "lib/math.lsp")`, "math"},
+		{`(import "lib/math.lsp") (math/square 3)`, "9"},
+		{`(import "lib/math.lsp" :as m) (m/twice m/square 3)`, "81"},
+		{`(import "lib/math.lsp") (def square 'mine) (list square (math/square 2))`, "(mine 4)"},
+		{
+			// The module imported by math is the same module imported here,
+			// so both see the same variables.
+			input: `
+            (import "lib/math.lsp")
+            (import "lib/counter.lsp" :as a)
+            (import "lib/counter.lsp" :as b)
+            (math/square 2)
+            (a/inc!)
+            (b/inc!)
+            (list a/n b/n)
+            `,
+			expected: "(3 3)",
+		},
+	}
+
+	for _, tt := range tests {
+		expanded, err := New().Expand(parseFile(filepath.Join(dir, "main.lsp"), tt.input))
+
+		if err != nil {
+			t.Fatalf("expander error for %q: %s", tt.input, err)
+		}
+
+		evaluated := evaluator.Evaluate(expanded, object.NewEnvironment(nil))
+
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong evaluated result for %q: want=%s got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		comp := compiler.New()
+		err = comp.Compile(expanded)
+
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		machine := vm.New(comp.Bytecode())
+		err = machine.Run()
+
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		if machine.LastPoppedStackElem().Inspect() != tt.expected {
+			t.Errorf("wrong vm result for %q: want=%s got=%s", tt.input, tt.expected, machine.LastPoppedStackElem().Inspect())
+		}
+	}
+}
+
+// Test that problems reading a module are reported at the import that caused
+// them, and that names a module does not export cannot be used.
+func TestModuleErrors(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.lsp":      `(import "b.lsp")`,
+		"b.lsp":      `(import "a.lsp")`,
+		"self.lsp":   `(import "self.lsp")`,
+		"broken.lsp": `(def x`,
+		"lib.lsp":    "(export f)\n(def secret 1)\n(def f (lambda () secret))",
+		"bad.lsp":    "(export missing)",
+	})
+	main := filepath.Join(dir, "main.lsp")
+	broken := filepath.Join(dir, "broken.lsp")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`(import "a.lsp")`, filepath.Join(dir, "b.lsp") + ":1:1: import cycle: " + filepath.Join(dir, "a.lsp") + " -> " + filepath.Join(dir, "b.lsp") + " -> " + filepath.Join(dir, "a.lsp")},
+		{`(import "self.lsp")`, filepath.Join(dir, "self.lsp") + ":1:1: import cycle: " + filepath.Join(dir, "self.lsp") + " -> " + filepath.Join(dir, "self.lsp")},
+		{`(import "none.lsp")`, main + ":1:1: cannot import " + filepath.Join(dir, "none.lsp") + ": no such file or directory"},
+		{`(import "broken.lsp")`, main + ":1:1: cannot import " + broken + ": " + broken + ":1:1: error: Reached EOF before ')' (hint: add ')' to close the '(' at " + broken + ":1:1)"},
+		{`(import lib)`, main + `:1:1: import must be of the form (import "path") or (import "path" :as namespace)`},
+		{`(import "lib.lsp" :as a/b)`, main + `:1:1: invalid namespace "a/b" for module ` + filepath.Join(dir, "lib.lsp")},
+		{`(lambda () (import "lib.lsp"))`, main + ":1:12: import must be at the top level of the program"},
+		{`(export 1)`, main + ":1:9: export arguments must be identifiers, got 1"},
+	}
+
+	for _, tt := range tests {
+		_, err := New().Expand(parseFile(main, tt.input))
+
+		if err == nil {
+			t.Fatalf("expected expander error for %q, got none", tt.input)
+		}
+
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error:\n  want=%q\n  got=%q", tt.expected, err)
+		}
+	}
+
+	expanded, err := New().Expand(parseFile(main, `(import "lib.lsp") (lib/f) lib/secret`))
+
+	if err != nil {
+		t.Fatalf("expander error: %s", err)
+	}
+
+	err = compiler.New().Compile(expanded)
+	expected := main + ":1:28: undefined variable lib/secret"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected=%q got=%q", expected, err)
+	}
+
+	result := evaluator.Evaluate(expanded, object.NewEnvironment(nil))
+	expected = "ERROR: " + main + ":1:28: No such item: lib/secret"
+
+	if result.Inspect() != expected {
+		t.Errorf("wrong error: expected=%q got=%q", expected, result.Inspect())
+	}
+
+	expanded, err = New().Expand(parseFile(main, `(import "bad.lsp")`))
+
+	if err != nil {
+		t.Fatalf("expander error: %s", err)
+	}
+
+	err = compiler.New().Compile(expanded)
+	expected = filepath.Join(dir, "bad.lsp") + ":1:9: module " + filepath.Join(dir, "bad.lsp") + " exports undefined variable missing"
+
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error: expected=%q got=%q", expected, err)
+	}
+
+	result = evaluator.Evaluate(expanded, object.NewEnvironment(nil))
+
+	if result.Inspect() != "ERROR: "+expected {
+		t.Errorf("wrong error: expected=%q got=%q", "ERROR: "+expected, result.Inspect())
+	}
+}
+
+// Helper function for writing the provided files, by path, to a temporary
+// directory for testing. Returns the directory.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755)
+
+		if err != nil {
+			t.Fatalf("failed to create directory: %s", err)
+		}
+
+		err = os.WriteFile(path, []byte(contents), 0o644)
+
+		if err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+
+	return dir
+}
+
+// Helper function for getting a parsed program for testing.
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+
+	return p.ParseProgram()
+}
+
+// Helper function for getting a parsed program for testing, as if it were
+// read from the provided file.
+func parseFile(filename string, input string) *ast.Program {
+	l := lexer.NewWithFile(filename, input)
+	p := parser.New(l)
+
+	return p.ParseProgram()
+}
@@ -0,0 +1,177 @@
+// Reading and expanding the modules imported by a program.
+package expander
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"lisp/ast"
+	"lisp/lexer"
+	"lisp/object"
+	"lisp/parser"
+	"lisp/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// modules holds the modules read by an Expander, shared with the Expanders of
+// the modules it imports, so that each file is read and expanded only once.
+type modules struct {
+	read    map[string]*ast.Module // each module read, by absolute path
+	loading []*ast.Module          // the modules being read, in the order they were imported
+	meter   *object.Meter          // counts the resources used by macro bodies in every module
+}
+
+// Expand an import expression, of the form (import "path") or
+// (import "path" :as namespace), into an Import of the module read from the
+// path. A relative path is relative to the directory of the importing file.
+// The namespace is the name of the file without its extension, unless given.
+func (e *Expander) importModule(expr *ast.SExpression) (*ast.Import, error) {
+	form := fmt.Errorf("%s: import must be of the form (import \"path\") or (import \"path\" :as namespace)", expr.Pos())
+
+	if len(expr.Args) != 1 && len(expr.Args) != 3 {
+		return nil, form
+	}
+
+	lit, ok := expr.Args[0].(*ast.StringLiteral)
+
+	if !ok {
+		return nil, form
+	}
+
+	path := lit.Value
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(expr.Pos().File), path)
+	}
+
+	namespace := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if len(expr.Args) == 3 {
+		name, ok := expr.Args[2].(*ast.Identifier)
+
+		if !ok || expr.Args[1].String() != ":as" {
+			return nil, form
+		}
+
+		namespace = name.String()
+	}
+
+	if namespace == "" || strings.Contains(namespace, "/") || object.IsKeyword(namespace) {
+		return nil, fmt.Errorf("%s: invalid namespace %q for module %s", expr.Pos(), namespace, path)
+	}
+
+	module, err := e.modules.load(path, expr.Pos())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.Import{Token: expr.Token, Module: module, Namespace: namespace}, nil
+}
+
+// Record the names listed by an export expression, of the form
+// (export name...), as exports of the program being expanded.
+func (e *Expander) export(expr *ast.SExpression) error {
+	if len(expr.Args) == 0 {
+		return fmt.Errorf("%s: not enough arguments for export", expr.Pos())
+	}
+
+	for _, arg := range expr.Args {
+		name, ok := arg.(*ast.Identifier)
+
+		if !ok {
+			return fmt.Errorf("%s: export arguments must be identifiers, got %s", arg.Pos(), arg)
+		}
+
+		e.exports = append(e.exports, name)
+	}
+
+	return nil
+}
+
+// Return the module read from the provided path, reading and expanding it
+// with an Expander of its own if it has not been read yet. pos is the
+// position of the import, for errors.
+//
+// Return an error if the module cannot be read, or if it imports itself,
+// directly or through the modules it imports.
+func (m *modules) load(path string, pos token.Position) (*ast.Module, error) {
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot import %s: %s", pos, path, err)
+	}
+
+	if module, ok := m.read[abs]; ok {
+		// A module that is still being read is importing itself.
+		if module.Program == nil {
+			return nil, fmt.Errorf("%s: import cycle: %s", pos, m.cycle(module))
+		}
+
+		return module, nil
+	}
+
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		var pathErr *fs.PathError
+
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+
+		return nil, fmt.Errorf("%s: cannot import %s: %s", pos, path, err)
+	}
+
+	module := &ast.Module{Path: path}
+	m.read[abs] = module
+	m.loading = append(m.loading, module)
+
+	defer func() { m.loading = m.loading[:len(m.loading)-1] }()
+
+	l := lexer.NewWithFile(path, string(source))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if errs := p.Errors(); len(errs) > 0 {
+		delete(m.read, abs)
+		return nil, fmt.Errorf("%s: cannot import %s: %s", pos, path, errs[0])
+	}
+
+	// Macros are local to the module that defines them.
+	expander := &Expander{
+		macros:  map[string]*object.LambdaObject{},
+		env:     object.NewEnvironment(nil),
+		modules: m,
+	}
+
+	expanded, err := expander.expandProgram(program)
+
+	if err != nil {
+		delete(m.read, abs)
+		return nil, err
+	}
+
+	module.Program = expanded
+	module.Exports = expander.exports
+
+	return module, nil
+}
+
+// Describe the chain of imports from the provided module, which is being
+// read, back to itself.
+func (m *modules) cycle(module *ast.Module) string {
+	paths := []string{}
+
+	for i := len(m.loading) - 1; i >= 0; i-- {
+		paths = append([]string{m.loading[i].Path}, paths...)
+
+		if m.loading[i] == module {
+			break
+		}
+	}
+
+	return strings.Join(append(paths, module.Path), " -> ")
+}
@@ -0,0 +1,93 @@
+// Conversion between the expressions passed to and produced by macros, and
+// the data macros work with.
+package expander
+
+import (
+	"fmt"
+	"lisp/ast"
+	"lisp/object"
+	"lisp/token"
+)
+
+// sources maps the data passed to a macro back to the expressions it was
+// quoted from, so that the parts of an expansion taken from the arguments of
+// a macro call become the original expressions again.
+type sources map[object.Object]ast.Expression
+
+// Quote the provided expression, as object.Quote does, recording the
+// expression each part of the resulting data was quoted from.
+func (s sources) quote(expr ast.Expression) object.Object {
+	var obj object.Object
+
+	if list, ok := expr.(*ast.SExpression); ok {
+		values := []object.Object{}
+
+		if list.Fn != nil {
+			values = append(values, s.quote(list.Fn))
+		}
+
+		for _, arg := range list.Args {
+			values = append(values, s.quote(arg))
+		}
+
+		obj = object.NewList(values...)
+	} else {
+		obj = object.Quote(expr)
+	}
+
+	// true, false and null are shared by every expression quoted to them.
+	if obj != object.TRUE && obj != object.FALSE && obj != object.NULL {
+		s[obj] = expr
+	}
+
+	return obj
+}
+
+// Convert the provided data into the expression it represents, the reverse of
+// quoting. Data that was not quoted from an expression is given the position
+// of the provided Token.
+//
+// Return an error if the data contains an object that has no representation
+// as source code, such as a lambda.
+func (s sources) unquote(obj object.Object, tok token.Token) (ast.Expression, error) {
+	if expr, ok := s[obj]; ok {
+		return expr, nil
+	}
+
+	switch obj := obj.(type) {
+	case *object.List:
+		list := &ast.SExpression{Token: tok}
+
+		for i, value := range obj.Items() {
+			expr, err := s.unquote(value, tok)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if i == 0 {
+				list.Fn = expr
+			} else {
+				list.Args = append(list.Args, expr)
+			}
+		}
+
+		return list, nil
+	case *object.Symbol:
+		return identifier(obj.Name, tok.Pos), nil
+	case *object.Number:
+		return &ast.FloatLiteral{
+			Token: token.Token{Type: token.NUM, Literal: obj.Inspect(), Pos: tok.Pos},
+			Value: obj.Value,
+		}, nil
+	case *object.String:
+		return &ast.StringLiteral{
+			Token: token.Token{Type: token.STRING, Literal: obj.Value, Pos: tok.Pos},
+			Value: obj.Value,
+		}, nil
+	case *object.BooleanObject, *object.Null:
+		return identifier(obj.Inspect(), tok.Pos), nil
+	default:
+		return nil, fmt.Errorf("expansion must only contain data, got %s", obj.Inspect())
+	}
+}
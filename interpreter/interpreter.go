@@ -0,0 +1,300 @@
+// The interpreter package runs lisp programs on behalf of Go programs that
+// embed the language, with either engine, keeping the definitions of each
+// program for the next.
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lisp/compiler"
+	"lisp/evaluator"
+	"lisp/expander"
+	"lisp/lexer"
+	"lisp/object"
+	"lisp/parser"
+	"lisp/vm"
+	"strings"
+)
+
+// The engines an Interpreter can run programs with.
+const (
+	EngineVM   = "vm"   // compile programs to bytecode and run it on a VM
+	EngineEval = "eval" // evaluate programs by walking their AST
+)
+
+// Interpreter runs programs one after another, each able to use the
+// variables and macros defined by the programs before it, as in the repl.
+//
+// An Interpreter must not be used by more than one goroutine at once.
+type Interpreter struct {
+	engine   string
+	limits   object.Limits
+	expander *expander.Expander
+	// The state of the eval engine
+	env *object.Environment
+	// The state of the vm engine, kept between compiler and VM instances
+	constants []object.Object
+	symbols   *compiler.SymbolTable
+	globals   []object.Object
+}
+
+// Option configures an Interpreter as it is created.
+type Option func(*Interpreter)
+
+// Run programs with the provided engine, EngineVM or EngineEval, rather than
+// the VM.
+func WithEngine(engine string) Option {
+	return func(i *Interpreter) {
+		i.engine = engine
+	}
+}
+
+// Run each program, and each function called, within the provided Limits.
+func WithLimits(limits object.Limits) Option {
+	return func(i *Interpreter) {
+		i.limits = limits
+	}
+}
+
+// SyntaxError is the error returned when the source of a program cannot be
+// parsed, with the problems found in it.
+type SyntaxError struct {
+	Diagnostics []parser.Diagnostic
+}
+
+// Return the problems found, one on each line.
+func (e *SyntaxError) Error() string {
+	lines := []string{}
+
+	for _, d := range e.Diagnostics {
+		lines = append(lines, d.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Error is the error returned when a program or function fails while it runs,
+// by either engine, with the stack trace of the functions it failed in.
+type Error struct {
+	Message string
+	Value   object.Object      // The value thrown by a throw expression, nil for other errors.
+	Limit   *object.LimitError // The limit the program exceeded, nil for other errors.
+	Trace   object.StackTrace
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Return the LimitError of a program that exceeded one of its limits.
+func (e *Error) Unwrap() error {
+	if e.Limit == nil {
+		return nil
+	}
+
+	return e.Limit
+}
+
+// Create a new Interpreter, configured by the provided options.
+func New(options ...Option) *Interpreter {
+	i := &Interpreter{
+		engine:    EngineVM,
+		expander:  expander.New(),
+		env:       object.NewEnvironment(nil),
+		constants: []object.Object{},
+		symbols:   compiler.NewSymbolTable(),
+		globals:   []object.Object{},
+	}
+
+	for index, builtin := range object.Builtins {
+		i.symbols.DefineBuiltin(index, builtin.Name)
+	}
+
+	for _, option := range options {
+		option(i)
+	}
+
+	return i
+}
+
+// Run the program in the provided source and return the value of its last
+// expression, or null if it has none.
+//
+// Returns a SyntaxError if the source cannot be parsed, the error from
+// expanding macros or compiling the program if either fails, or an Error if
+// the program fails while it runs. Macros are expanded within the same limits
+// as the program, and exceeding them while expanding also returns an Error.
+func (i *Interpreter) Eval(source string) (object.Object, error) {
+	return i.EvalContext(context.Background(), source)
+}
+
+// Run the program in the provided source as Eval does, in the provided
+// context. The program stops with an Error wrapping a LimitError when the
+// context is done.
+func (i *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+
+	if errs := p.Errors(); len(errs) > 0 {
+		return nil, &SyntaxError{Diagnostics: errs}
+	}
+
+	expanded, err := i.expander.ExpandContext(ctx, program, i.limits)
+
+	if err != nil {
+		var limit *object.LimitError
+
+		if errors.As(err, &limit) {
+			return nil, &Error{Message: err.Error(), Limit: limit}
+		}
+
+		return nil, err
+	}
+
+	if i.engine == EngineEval {
+		return result(evaluator.EvaluateContext(ctx, expanded, i.env, i.limits))
+	}
+
+	c := compiler.NewWithState(i.constants, i.symbols)
+
+	err = c.Compile(expanded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bytecode := c.Bytecode()
+	i.constants = bytecode.Constants
+
+	machine := vm.NewWithState(bytecode, i.globals, vm.WithLimits(i.limits))
+
+	err = machine.RunContext(ctx)
+
+	// Globals defined before an error are kept.
+	i.globals = machine.Globals()
+
+	if err != nil {
+		return nil, runtimeError(err)
+	}
+
+	return result(machine.LastPoppedStackElem())
+}
+
+// Call the function defined by the provided name, a lambda defined by a
+// program or with Define, or a builtin, with the provided arguments, and
+// return its result.
+//
+// Returns an Error if the name is not defined or the function fails.
+func (i *Interpreter) Call(name string, args ...object.Object) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// Call the function defined by the provided name as Call does, in the
+// provided context.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...object.Object) (object.Object, error) {
+	fn, ok := i.lookup(name)
+
+	if !ok {
+		return nil, &Error{Message: fmt.Sprintf("undefined variable %s", name)}
+	}
+
+	if i.engine == EngineEval {
+		previous := i.env.SetMeter(evaluator.NewMeter(ctx, i.limits))
+		defer i.env.SetMeter(previous)
+
+		return result(evaluator.Apply(name, fn, args...))
+	}
+
+	bytecode := &compiler.Bytecode{Constants: i.constants, GlobalNames: i.symbols.GlobalNames()}
+	machine := vm.NewWithState(bytecode, i.globals, vm.WithLimits(i.limits))
+
+	value := machine.CallContext(ctx, fn, args...)
+	i.globals = machine.Globals()
+
+	return result(value)
+}
+
+// Define a global variable with the provided name and value, for the
+// programs run and functions called afterwards. Defining a name that is
+// already defined changes its value.
+func (i *Interpreter) Define(name string, value object.Object) {
+	if i.engine == EngineEval {
+		i.env.Set(name, value)
+		return
+	}
+
+	sym := i.symbols.Define(name)
+
+	for len(i.globals) <= sym.Index {
+		i.globals = append(i.globals, nil)
+	}
+
+	i.globals[sym.Index] = value
+}
+
+// Return the value of the global variable or builtin with the provided name,
+// reporting whether it is defined.
+func (i *Interpreter) lookup(name string) (object.Object, bool) {
+	if i.engine == EngineEval {
+		value := i.env.Get(name)
+
+		if value.Type() != object.ERROR_OBJ {
+			return value, true
+		}
+	} else if sym, ok := i.symbols.Resolve(name); ok {
+		switch sym.Scope {
+		case compiler.GlobalScope:
+			if sym.Index < len(i.globals) && i.globals[sym.Index] != nil {
+				return i.globals[sym.Index], true
+			}
+
+			return nil, false
+		case compiler.BuiltinScope:
+			return object.Builtins[sym.Index], true
+		}
+	}
+
+	if builtin := object.GetBuiltinByName(name); builtin != nil {
+		return builtin, true
+	}
+
+	return nil, false
+}
+
+// Return the value resulting from a program or function, or an Error if it
+// is an error object. A program with no expressions results in null.
+func result(value object.Object) (object.Object, error) {
+	if value == nil {
+		return object.NULL, nil
+	}
+
+	if err, ok := value.(*object.ErrorObject); ok {
+		return nil, &Error{Message: err.Error, Value: err.Value, Limit: err.Limit, Trace: err.Trace}
+	}
+
+	return value, nil
+}
+
+// Create the Error for an error returned by the VM.
+func runtimeError(err error) *Error {
+	runtimeErr := &Error{Message: err.Error()}
+
+	var thrown *vm.ThrownError
+	var limit *object.LimitError
+	var traced *vm.RuntimeError
+
+	if errors.As(err, &thrown) {
+		runtimeErr.Value = thrown.Value
+	}
+
+	if errors.As(err, &limit) {
+		runtimeErr.Limit = limit
+	}
+
+	if errors.As(err, &traced) {
+		runtimeErr.Trace = traced.Trace
+	}
+
+	return runtimeErr
+}
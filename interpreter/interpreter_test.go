@@ -0,0 +1,187 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"lisp/object"
+	"testing"
+	"time"
+)
+
+var engines = []string{EngineVM, EngineEval}
+
+// Test that programs can use the variables, functions and macros defined by
+// the programs run before them and with Define, and that functions they
+// define can be called.
+func TestState(t *testing.T) {
+	for _, engine := range engines {
+		i := New(WithEngine(engine))
+
+		evalAll(t, i,
+			"(def x 2)",
+			"(def square (lambda (n) (* n n)))",
+			"(defmacro twice (e) `(+ ,e ,e))",
+			"(def count 0)",
+			"(def inc (lambda () (set! count (+ count 1))))",
+			"(def a 1) (first a)",
+		)
+
+		i.Define("y", &object.Number{Value: 5})
+		i.Define("x", &object.Number{Value: 3})
+
+		tests := []struct {
+			input    string
+			expected string
+		}{
+			{"(square x)", "9"},
+			{"(twice y)", "10"},
+			{"a", "1"},
+			{"", "null"},
+		}
+
+		for _, tt := range tests {
+			result, err := i.Eval(tt.input)
+
+			if err != nil {
+				t.Fatalf("[%s] unexpected error for %s: %s", engine, tt.input, err)
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("[%s] wrong result for %s: want=%s got=%s", engine, tt.input, tt.expected, result.Inspect())
+			}
+		}
+
+		calls := []struct {
+			name     string
+			args     []object.Object
+			expected string
+		}{
+			{"square", []object.Object{&object.Number{Value: 4}}, "16"},
+			{"+", []object.Object{&object.Number{Value: 1}, &object.Number{Value: 2}}, "3"},
+			{"inc", nil, "1"},
+			{"inc", nil, "2"},
+		}
+
+		for _, tt := range calls {
+			result, err := i.Call(tt.name, tt.args...)
+
+			if err != nil {
+				t.Fatalf("[%s] unexpected error calling %s: %s", engine, tt.name, err)
+			}
+
+			if result.Inspect() != tt.expected {
+				t.Errorf("[%s] wrong result calling %s: want=%s got=%s", engine, tt.name, tt.expected, result.Inspect())
+			}
+		}
+
+		result, err := i.Eval("count")
+
+		if err != nil || result.Inspect() != "2" {
+			t.Errorf("[%s] expected count to be changed by calls, got=%v (%v)", engine, result, err)
+		}
+	}
+}
+
+// Test that each kind of failure is reported as an error of its own type.
+func TestErrors(t *testing.T) {
+	for _, engine := range engines {
+		i := New(WithEngine(engine), WithLimits(object.Limits{Steps: 1000}))
+
+		var syntaxErr *SyntaxError
+		_, err := i.Eval("(+ 1")
+
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("[%s] expected syntax error, got=%v", engine, err)
+		}
+
+		evalAll(t, i,
+			"(def check (lambda (x) (+ x \"a\")))",
+			"(def loop (lambda () (loop)))",
+		)
+
+		var runtimeErr *Error
+		_, err = i.Eval("(list (check 1))")
+
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("[%s] expected runtime error, got=%v", engine, err)
+		}
+
+		expected := "attempted to call + with unsupported type STRING (a)"
+
+		if runtimeErr.Message != expected {
+			t.Errorf("[%s] wrong message: want=%q got=%q", engine, expected, runtimeErr.Message)
+		}
+
+		if trace := runtimeErr.Trace.String(); trace != "  at check (1:24)\n  at <main> (1:7)\n" {
+			t.Errorf("[%s] wrong trace: %q", engine, trace)
+		}
+
+		_, err = i.Eval("(throw 'oops)")
+
+		if !errors.As(err, &runtimeErr) || runtimeErr.Value == nil || runtimeErr.Value.Inspect() != "oops" {
+			t.Errorf("[%s] expected thrown value, got=%v", engine, err)
+		}
+
+		var limitErr *object.LimitError
+
+		_, err = i.Eval("(loop)")
+
+		if !errors.As(err, &limitErr) || limitErr.Limit != object.StepLimit {
+			t.Errorf("[%s] expected step limit error running program, got=%v", engine, err)
+		}
+
+		_, err = i.Call("loop")
+
+		if !errors.As(err, &limitErr) || limitErr.Limit != object.StepLimit {
+			t.Errorf("[%s] expected step limit error calling function, got=%v", engine, err)
+		}
+
+		_, err = i.Call("nothing")
+
+		if err == nil || err.Error() != "undefined variable nothing" {
+			t.Errorf("[%s] expected undefined variable error, got=%v", engine, err)
+		}
+	}
+}
+
+// Test that the limits and context apply to expanding macros as well as to
+// running programs, so that a macro that never finishes expanding stops.
+func TestExpansionLimits(t *testing.T) {
+	for _, engine := range engines {
+		i := New(WithEngine(engine), WithLimits(object.Limits{Timeout: 200 * time.Millisecond}))
+
+		var runtimeErr *Error
+		var limitErr *object.LimitError
+
+		_, err := i.Eval("(defmacro m () (let loop () (loop))) (m)")
+
+		if !errors.As(err, &runtimeErr) || !errors.As(err, &limitErr) || limitErr.Limit != object.TimeLimit {
+			t.Errorf("[%s] expected time limit error, got=%v", engine, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err = New(WithEngine(engine)).EvalContext(ctx, "(defmacro m () (let loop () (loop))) (m)")
+
+		if !errors.As(err, &limitErr) || limitErr.Limit != object.ContextLimit {
+			t.Errorf("[%s] expected context limit error, got=%v", engine, err)
+		}
+	}
+}
+
+// Evaluate each of the provided programs in turn, failing the test if any
+// of them cannot be run. Programs that fail while they run are allowed.
+func evalAll(t *testing.T, i *Interpreter, inputs ...string) {
+	t.Helper()
+
+	for _, input := range inputs {
+		_, err := i.Eval(input)
+
+		var runtimeErr *Error
+
+		if err != nil && !errors.As(err, &runtimeErr) {
+			t.Fatalf("error running %s: %s", input, err)
+		}
+	}
+}
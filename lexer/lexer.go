@@ -2,20 +2,26 @@
 package lexer
 
 import (
-	"bytes"
 	"fmt"
 	"lisp/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-const EOF byte = 0
+const EOF rune = 0
 
 // A Lexer is an object that transforms the input text
 // into tokens until reaching an EOF.
 type Lexer struct {
 	Input   string // The source code text.
-	pos     int    // The current character position in the text.
-	readPos int    // The position of the next character.
-	ch      byte   // The currently highlighted character.
+	File    string // The name of the file the source code was read from.
+	pos     int    // The byte offset of the current character in the text.
+	readPos int    // The byte offset of the next character.
+	ch      rune   // The currently highlighted character.
+	line    int    // The line of the currently highlighted character.
+	column  int    // The column of the currently highlighted character.
 }
 
 // Create a new lexer object that will tokenize the given
@@ -23,21 +29,33 @@ type Lexer struct {
 func New(input string) *Lexer {
 	l := &Lexer{
 		Input: input,
+		line:  1,
 	}
 
-	l.pos = 0
-	l.readPos = 1
-	l.ch = l.Input[l.pos]
+	l.readChar()
+
+	return l
+}
+
+// Create a new lexer object that will tokenize the given input text, using
+// the provided file name in the positions of the produced tokens.
+func NewWithFile(file string, input string) *Lexer {
+	l := New(input)
+	l.File = file
 
 	return l
 }
 
-// Read bytes from input until a complete token is formed.
+// Read characters from input until a complete token is formed.
 // Return the newly created token.
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
-	l.skipWhitespace()
+	if illegal, ok := l.skipWhitespace(); !ok {
+		return illegal
+	}
+
+	pos := l.position()
 
 	switch {
 	case l.ch == '(':
@@ -56,10 +74,29 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Type = token.RBRACE
 		tok.Literal = string(l.ch)
 		l.readChar()
+	case l.ch == '#' && l.peekChar() == ';':
+		tok.Type = token.DATUM_COMMENT
+		tok.Literal = "#;"
+		l.readChar()
+		l.readChar()
 	case l.ch == '\'':
 		tok.Type = token.QUOTE
 		tok.Literal = string(l.ch)
 		l.readChar()
+	case l.ch == '`':
+		tok.Type = token.BACKQUOTE
+		tok.Literal = string(l.ch)
+		l.readChar()
+	case l.ch == ',':
+		if l.peekChar() == '@' {
+			tok.Type = token.UNQUOTE_SPLICING
+			tok.Literal = ",@"
+			l.readChar()
+		} else {
+			tok.Type = token.UNQUOTE
+			tok.Literal = string(l.ch)
+		}
+		l.readChar()
 	case l.ch == '-':
 		if isNumber(l.peekChar()) {
 			l.readChar()
@@ -82,35 +119,49 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Literal = string(l.ch)
 	}
 
+	tok.Pos = pos
+
 	return tok
 }
 
 // Update the position, read position, and
-// the current character fields in the lexer.
+// the current character fields in the lexer,
+// decoding the next UTF-8 encoded character.
 //
 // If the read position is beyond the end of
-// the input, return EOF.
+// the input, the current character is EOF.
 func (l *Lexer) readChar() {
-	l.pos++
-	l.readPos = l.pos + 1
+	if l.ch == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
+
+	l.pos = l.readPos
 
-	if l.readPos >= len(l.Input)+1 {
+	if l.readPos >= len(l.Input) {
 		l.ch = EOF
-	} else {
-		l.ch = l.Input[l.pos]
+		return
 	}
+
+	ch, width := utf8.DecodeRuneInString(l.Input[l.readPos:])
+	l.ch = ch
+	l.readPos += width
 }
 
 // See the next character in the input.
 //
 // If the read position is beyond the end of
 // the input, return EOF.
-func (l *Lexer) peekChar() byte {
-	if l.readPos >= len(l.Input)+1 {
+func (l *Lexer) peekChar() rune {
+	if l.readPos >= len(l.Input) {
 		return EOF
 	}
 
-	return l.Input[l.readPos]
+	ch, _ := utf8.DecodeRuneInString(l.Input[l.readPos:])
+
+	return ch
 }
 
 // Read characters until either reaching whitespace or
@@ -148,48 +199,174 @@ func (l *Lexer) readIdent() token.Token {
 // Read characters until reaching a terminating `"`.
 // Return a Token of type identifier string with
 // the literal value of a string of the read characters.
+//
+// Escape sequences (\n \t \r \\ \" and \uXXXX) are replaced
+// by the characters they represent.
 func (l *Lexer) readString() token.Token {
 	l.readChar()
 
-	var output bytes.Buffer
+	var output strings.Builder
+	var escapeErr error
 
 	for l.ch != '"' {
-		if l.ch == 0 {
+		if l.ch == EOF {
 			return token.Token{
 				Type:    token.ILLEGAL,
 				Literal: fmt.Sprintf("unterminated string: \"%s", output.String()),
 			}
 		}
-		output.WriteByte(l.ch)
+
+		if l.ch == '\\' {
+			ch, err := l.readEscape()
+
+			// Keep reading to the end of the string after a bad escape so
+			// that lexing resumes after the string rather than inside it.
+			if err != nil && escapeErr == nil {
+				escapeErr = err
+			}
+
+			output.WriteRune(ch)
+			continue
+		}
+
+		output.WriteRune(l.ch)
 		l.readChar()
 	}
 	l.readChar()
 
+	if escapeErr != nil {
+		return token.Token{
+			Type:    token.ILLEGAL,
+			Literal: escapeErr.Error(),
+		}
+	}
+
 	return token.Token{
 		Type:    token.STRING,
 		Literal: output.String(),
 	}
 }
 
-func (l *Lexer) skipWhitespace() {
-	for isWhitespace(l.ch) {
+// Return the source position of the currently highlighted character.
+func (l *Lexer) position() token.Position {
+	return token.Position{
+		File:   l.File,
+		Line:   l.line,
+		Column: l.column,
+	}
+}
+
+// Read the escape sequence at the current '\' and return its character.
+func (l *Lexer) readEscape() (rune, error) {
+	l.readChar()
+
+	escape := l.ch
+	l.readChar()
+
+	switch escape {
+	case 'n':
+		return '\n', nil
+	case 't':
+		return '\t', nil
+	case 'r':
+		return '\r', nil
+	case '\\':
+		return '\\', nil
+	case '"':
+		return '"', nil
+	case 'u':
+		start := l.pos
+
+		for i := 0; i < 4 && isHexDigit(l.ch); i++ {
+			l.readChar()
+		}
+
+		digits := l.Input[start:l.pos]
+		value, err := strconv.ParseUint(digits, 16, 32)
+
+		if len(digits) != 4 || err != nil {
+			return 0, fmt.Errorf("invalid unicode escape: \\u%s", digits)
+		}
+
+		return rune(value), nil
+	case EOF:
+		return 0, fmt.Errorf("unterminated string: escape at end of input")
+	default:
+		return 0, fmt.Errorf("unknown escape sequence: \\%c", escape)
+	}
+}
+
+// Skip whitespace and comments until reaching the start of the next token.
+//
+// Line comments begin with ';' and run to the end of the line. Block comments
+// are written as #| ... |# and may be nested. If a block comment is never
+// closed, return an ILLEGAL token and false.
+func (l *Lexer) skipWhitespace() (token.Token, bool) {
+	for {
+		switch {
+		case isWhitespace(l.ch):
+			l.readChar()
+		case l.ch == ';':
+			for l.ch != '\n' && l.ch != EOF {
+				l.readChar()
+			}
+		case l.ch == '#' && l.peekChar() == '|':
+			pos := l.position()
+
+			if !l.skipBlockComment() {
+				return token.Token{
+					Type:    token.ILLEGAL,
+					Literal: "unterminated block comment",
+					Pos:     pos,
+				}, false
+			}
+		default:
+			return token.Token{}, true
+		}
+	}
+}
+
+// Skip a block comment, including any block comments nested inside it.
+// Return false if the input ends before the comment is closed.
+func (l *Lexer) skipBlockComment() bool {
+	depth := 0
+
+	for l.ch != EOF {
+		switch {
+		case l.ch == '#' && l.peekChar() == '|':
+			depth++
+			l.readChar()
+		case l.ch == '|' && l.peekChar() == '#':
+			depth--
+			l.readChar()
+		}
+
 		l.readChar()
+
+		if depth == 0 {
+			return true
+		}
 	}
+
+	return false
 }
 
-func isValidIdentChar(ch byte) bool {
+func isValidIdentChar(ch rune) bool {
 	return !isReservedChar(ch) && !isWhitespace(ch)
 }
 
 // Checks if the provided character is in a set of
 // reserved characters that can't be part of another
 // token.
-func isReservedChar(ch byte) bool {
-	reserved := map[byte]bool{
+func isReservedChar(ch rune) bool {
+	reserved := map[rune]bool{
 		'(': true,
 		')': true,
 		'{': true,
 		'}': true,
+		';': true,
+		'`': true,
+		',': true,
 		EOF: true,
 	}
 
@@ -198,10 +375,14 @@ func isReservedChar(ch byte) bool {
 	return ok
 }
 
-func isNumber(ch byte) bool {
+func isNumber(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func isWhitespace(ch byte) bool {
-	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+func isHexDigit(ch rune) bool {
+	return isNumber(ch) || ('a' <= ch && ch <= 'f') || ('A' <= ch && ch <= 'F')
+}
+
+func isWhitespace(ch rune) bool {
+	return unicode.IsSpace(ch)
 }
@@ -111,6 +111,108 @@ func TestLexer(t *testing.T) {
 
 	l := New(input)
 
+	for _, expectedToken := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != expectedToken.Type || tok.Literal != expectedToken.Literal {
+			t.Errorf("expected %q, got %q", expectedToken, tok)
+		}
+	}
+}
+
+// Ensure tokens record the file, line, and column they begin at.
+func TestTokenPositions(t *testing.T) {
+	input := `(add 1
+  -2 "str")`
+
+	expected := []token.Position{
+		{File: "test.lsp", Line: 1, Column: 1},
+		{File: "test.lsp", Line: 1, Column: 2},
+		{File: "test.lsp", Line: 1, Column: 6},
+		{File: "test.lsp", Line: 2, Column: 3},
+		{File: "test.lsp", Line: 2, Column: 6},
+		{File: "test.lsp", Line: 2, Column: 11},
+		{File: "test.lsp", Line: 2, Column: 12},
+	}
+
+	l := NewWithFile("test.lsp", input)
+
+	for _, expectedPos := range expected {
+		tok := l.NextToken()
+
+		if tok.Pos != expectedPos {
+			t.Errorf("wrong position for %q: expected %s, got %s",
+				tok.Literal, expectedPos, tok.Pos)
+		}
+	}
+}
+
+// Ensure line and block comments are skipped, and that datum comments are
+// produced as tokens for the parser to handle.
+func TestComments(t *testing.T) {
+	input := `; a line comment
+    (add; comment directly after an identifier
+      1 #| a block
+      #| nested |# comment |# 2)
+    #;(ignored)`
+
+	expected := []token.Token{
+		{Type: token.LPAREN, Literal: "("},
+		{Type: token.IDENT, Literal: "add"},
+		{Type: token.NUM, Literal: "1"},
+		{Type: token.NUM, Literal: "2"},
+		{Type: token.RPAREN, Literal: ")"},
+		{Type: token.DATUM_COMMENT, Literal: "#;"},
+		{Type: token.LPAREN, Literal: "("},
+		{Type: token.IDENT, Literal: "ignored"},
+		{Type: token.RPAREN, Literal: ")"},
+		{Type: token.EOF, Literal: ""},
+	}
+
+	l := New(input)
+
+	for _, expectedToken := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != expectedToken.Type || tok.Literal != expectedToken.Literal {
+			t.Errorf("expected %q, got %q", expectedToken, tok)
+		}
+	}
+}
+
+// Ensure an unclosed block comment produces an illegal token.
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("1 #| never #| closed |#")
+
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("expected illegal token, got %q", tok)
+	}
+
+	if tok.Pos.Column != 3 {
+		t.Errorf("wrong column: expected=%d got=%d", 3, tok.Pos.Column)
+	}
+}
+
+// Ensure multi-byte characters are read as single characters, and that
+// escape sequences in strings are decoded.
+func TestUnicodeAndEscapes(t *testing.T) {
+	input := `(café "é\n\t\\\"é") "bad \q escape" ünïcode`
+
+	expected := []token.Token{
+		{Type: token.LPAREN, Literal: "(", Pos: token.Position{Line: 1, Column: 1}},
+		{Type: token.IDENT, Literal: "café", Pos: token.Position{Line: 1, Column: 2}},
+		{Type: token.STRING, Literal: "é\n\t\\\"é", Pos: token.Position{Line: 1, Column: 7}},
+		{Type: token.RPAREN, Literal: ")", Pos: token.Position{Line: 1, Column: 19}},
+		{Type: token.ILLEGAL, Literal: `unknown escape sequence: \q`, Pos: token.Position{Line: 1, Column: 21}},
+		{Type: token.IDENT, Literal: "ünïcode", Pos: token.Position{Line: 1, Column: 37}},
+		{Type: token.EOF, Literal: "", Pos: token.Position{Line: 1, Column: 44}},
+	}
+
+	l := New(input)
+
 	for _, expectedToken := range expected {
 		tok := l.NextToken()
 
@@ -119,3 +221,12 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+// Ensure lexing empty input produces EOF rather than failing.
+func TestEmptyInput(t *testing.T) {
+	tok := New("").NextToken()
+
+	if tok.Type != token.EOF {
+		t.Errorf("expected EOF, got %q", tok)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"flag"
 	"fmt"
 	"lisp/compiler"
+	"lisp/debugger"
 	"lisp/evaluator"
+	"lisp/expander"
 	"lisp/lexer"
 	"lisp/object"
 	"lisp/parser"
@@ -19,6 +21,19 @@ var engine *string = flag.String("engine", "vm", "enter 'vm' or 'eval'")
 func main() {
 	flag.Parse()
 
+	// Run a file under the debugger with lisp debug [file].
+	if len(flag.Args()) == 2 && flag.Arg(0) == "debug" {
+		fileContents, err := os.ReadFile(flag.Arg(1))
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, err.Error())
+			return
+		}
+
+		debugger.Start(flag.Arg(1), string(fileContents), os.Stdin, os.Stdout)
+		return
+	}
+
 	switch len(flag.Args()) {
 	// if there are no args provided, evaluate from stdin
 	case 0:
@@ -39,9 +54,9 @@ func main() {
 		}
 
 		if *engine == "eval" {
-			runFile(string(fileContents))
+			runFile(flag.Arg(0), string(fileContents))
 		} else {
-			runCompiled(string(fileContents))
+			runCompiled(flag.Arg(0), string(fileContents))
 		}
 	default:
 		fmt.Fprintf(os.Stderr, "expected only 1 filename")
@@ -49,42 +64,60 @@ func main() {
 }
 
 // Convert the provided program into an AST, then evluate it.
-func runFile(source string) {
-	l := lexer.New(source)
+func runFile(filename string, source string) {
+	l := lexer.NewWithFile(filename, source)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
-	if len(p.Errors) > 0 {
-		for _, err := range p.Errors {
-			fmt.Fprintf(os.Stderr, err)
-		}
+	for _, d := range p.Diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+
+	if len(p.Errors()) > 0 {
+		return
+	}
 
+	expanded, err := expander.New().Expand(program)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "expander error: %s\n", err)
 		return
 	}
 
 	env := object.NewEnvironment(nil)
-	result := evaluator.Evaluate(program, env)
+	result := evaluator.Evaluate(expanded, env)
 
 	fmt.Println(result.Inspect())
+
+	if err, ok := result.(*object.ErrorObject); ok {
+		fmt.Print(err.Trace)
+	}
 }
 
 // Compile the expressions in the provided program into bytecode, then
 // execute the bytecode on a VM.
-func runCompiled(source string) {
-	l := lexer.New(source)
+func runCompiled(filename string, source string) {
+	l := lexer.NewWithFile(filename, source)
 	p := parser.New(l)
 	program := p.ParseProgram()
 
-	if len(p.Errors) > 0 {
-		for _, err := range p.Errors {
-			fmt.Fprintf(os.Stderr, err)
-		}
+	for _, d := range p.Diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+
+	if len(p.Errors()) > 0 {
+		return
+	}
 
+	expanded, err := expander.New().Expand(program)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "expander error: %s\n", err)
 		return
 	}
 
 	c := compiler.New()
-	err := c.Compile(program)
+	err = c.Compile(expanded)
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "compiler error: %s\n", err)
@@ -96,6 +129,11 @@ func runCompiled(source string) {
 
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "vm error: %s\n", err)
+
+		if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(os.Stderr, runtimeErr.Trace)
+		}
+
 		return
 	}
 
@@ -3,7 +3,10 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"slices"
 	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
 var TRUE = &BooleanObject{Value: true}
@@ -15,7 +18,7 @@ var NULL = &Null{}
 var Builtins = []*FunctionObject{
 	{
 		"+",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			var result float64 = 0
 
 			for _, arg := range args {
@@ -33,7 +36,7 @@ var Builtins = []*FunctionObject{
 	},
 	{
 		"*",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			var result float64 = 1
 
 			for _, arg := range args {
@@ -51,7 +54,7 @@ var Builtins = []*FunctionObject{
 	},
 	{
 		"-",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) == 0 {
 				return NoArgsError("-")
 			}
@@ -83,7 +86,7 @@ var Builtins = []*FunctionObject{
 	},
 	{
 		"/",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) == 0 {
 				return NoArgsError("/")
 			}
@@ -120,7 +123,7 @@ var Builtins = []*FunctionObject{
 	// Analogous to % in other languages like python, ruby, etc.
 	{
 		"rem",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 2 {
 				return WrongNumOfArgsError("rem", "2", len(args))
 			}
@@ -157,7 +160,7 @@ var Builtins = []*FunctionObject{
 	// Analogous to `==` in other languages, but with any amount of arguments
 	{
 		"=",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) == 0 {
 				return TRUE
 			}
@@ -175,68 +178,48 @@ var Builtins = []*FunctionObject{
 				return lambdasEqual(obj, args[1:]...)
 			case *FunctionObject:
 				return functionsEqual(obj, args[1:]...)
+			case *Symbol:
+				return symbolsEqual(obj, args[1:]...)
+			case *Null:
+				return nullsEqual(args[1:]...)
+			case *List:
+				return listsEqual(obj, args[1:]...)
+			case *Dictionary:
+				return dictsEqual(obj, args[1:]...)
+			case *Closure:
+				return closuresEqual(obj, args[1:]...)
+			case *Exception:
+				return exceptionsEqual(obj, args[1:]...)
 			default:
 				return BadTypeError("=", obj)
 			}
 		},
 	},
+	// Check that each argument is less than the one after it, comparing them
+	// in the same way as compare.
 	{
 		"<",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) == 0 {
 				return WrongNumOfArgsError("<", "at least 1", 0)
 			}
 
-			nums := []float64{}
-
-			for _, arg := range args {
-				switch arg := arg.(type) {
-				case *Number:
-					nums = append(nums, arg.Value)
-				default:
-					return BadTypeError("<", arg)
-				}
-			}
-
-			for i, n := range nums[1:] {
-				if n <= nums[i] {
-					return FALSE
-				}
-			}
-
-			return TRUE
+			return ordered("<", -1, args)
 		},
 	},
 	{
 		">",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) == 0 {
 				return WrongNumOfArgsError(">", "at least 1", 0)
 			}
 
-			nums := []float64{}
-
-			for _, arg := range args {
-				switch arg := arg.(type) {
-				case *Number:
-					nums = append(nums, arg.Value)
-				default:
-					return BadTypeError(">", arg)
-				}
-			}
-
-			for i, n := range nums[1:] {
-				if n >= nums[i] {
-					return FALSE
-				}
-			}
-
-			return TRUE
+			return ordered(">", 1, args)
 		},
 	},
 	{
 		"not",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 1 {
 				return WrongNumOfArgsError("not", "1", len(args))
 			}
@@ -251,90 +234,38 @@ var Builtins = []*FunctionObject{
 			return TRUE
 		},
 	},
-	{
-		"and",
-		func(args ...Object) Object {
-			for _, arg := range args {
-				if arg.Type() == ERROR_OBJ {
-					return arg
-				}
-
-				if !evalTruthy(arg) {
-					return FALSE
-				}
-			}
-
-			return TRUE
-		},
-	},
-	{
-		"or",
-		func(args ...Object) Object {
-			for _, arg := range args {
-				if arg.Type() == ERROR_OBJ {
-					return arg
-				}
-
-				if evalTruthy(arg) {
-					return TRUE
-				}
-			}
-
-			return FALSE
-		},
-	},
 	// Construct a List Object from an argument list.
 	{
 		"list",
-		func(args ...Object) Object {
-			values := make([]Object, len(args), len(args))
-
-			// Loop ensures that the args are referenced as individual objects,
-            // using args directly makes values a reference to args' underlying
-            // slice, which can be changed elsewhere.
-			for i, arg := range args {
-				values[i] = arg
-			}
-
-			return &List{
-				Values: values,
-			}
+		func(caller Caller, args ...Object) Object {
+			return NewList(args...)
 		},
 	},
 	// Construct a Dictionary Object from an argument list.
 	{
 		"dict",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args)%2 != 0 {
 				return WrongNumOfArgsError("dict", "even number", len(args))
 			}
 
-			items := map[HashKey]DictPair{}
+			dict := NewDictionary()
 
 			for i := 0; i < len(args)-1; i += 2 {
-				obj := args[i]
-				value := args[i+1]
-
-				key, ok := obj.(Hashable)
-
-				if !ok {
-					return BadKeyError(obj)
-				}
+				var err *ErrorObject
+				dict, err = dict.Set(args[i], args[i+1])
 
-				items[key.HashKey()] = DictPair{
-					Key:   obj,
-					Value: value,
+				if err != nil {
+					return err
 				}
 			}
 
-			return &Dictionary{
-				Values: items,
-			}
+			return dict
 		},
 	},
 	{
 		"first",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 1 {
 				return WrongNumOfArgsError("first", "1", len(args))
 			}
@@ -345,16 +276,16 @@ var Builtins = []*FunctionObject{
 
 			list := args[0].(*List)
 
-			if len(list.Values) == 0 {
+			if list.Len() == 0 {
 				return NULL
 			}
 
-			return list.Values[0]
+			return list.Get(0)
 		},
 	},
 	{
 		"rest",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 1 {
 				return WrongNumOfArgsError("rest", "1", len(args))
 			}
@@ -365,18 +296,16 @@ var Builtins = []*FunctionObject{
 
 			list := args[0].(*List)
 
-			if len(list.Values) == 0 {
+			if list.Len() == 0 {
 				return NULL
 			}
 
-			return &List{
-				Values: list.Values[1:],
-			}
+			return list.Rest()
 		},
 	},
 	{
 		"last",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 1 {
 				return WrongNumOfArgsError("last", "1", len(args))
 			}
@@ -387,16 +316,16 @@ var Builtins = []*FunctionObject{
 
 			list := args[0].(*List)
 
-			if len(list.Values) == 0 {
+			if list.Len() == 0 {
 				return NULL
 			}
 
-			return list.Values[len(list.Values)-1]
+			return list.Get(list.Len() - 1)
 		},
 	},
 	{
 		"len",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 1 {
 				return WrongNumOfArgsError("len", "1", len(args))
 			}
@@ -404,10 +333,13 @@ var Builtins = []*FunctionObject{
 			switch args[0].Type() {
 			case LIST_OBJ:
 				list := args[0].(*List)
-				return &Number{Value: float64(len(list.Values))}
+				return &Number{Value: float64(list.Len())}
 			case STRING_OBJ:
 				str := args[0].(*String)
-				return &Number{Value: float64(len(str.Value))}
+				return &Number{Value: float64(utf8.RuneCountInString(str.Value))}
+			case DICT_OBJ:
+				dict := args[0].(*Dictionary)
+				return &Number{Value: float64(dict.Len())}
 			default:
 				return BadTypeError("len", args[0])
 			}
@@ -415,11 +347,11 @@ var Builtins = []*FunctionObject{
 	},
 	// Takes two arguments, a list and an
 	//
-	// Returns a new list that is a copy of the given list, with
-	// the object appended.
+	// Returns a new list with the object appended, sharing the items of the
+	// given list, which is left unchanged.
 	{
 		"push",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 2 {
 				return WrongNumOfArgsError("push", "2", len(args))
 			}
@@ -433,18 +365,13 @@ var Builtins = []*FunctionObject{
 
 			list := args[0].(*List)
 
-			newList := make([]Object, len(list.Values))
-			copy(newList, list.Values)
-
-			newList = append(newList, args[1])
-
-			return &List{Values: newList}
+			return list.Push(args[1])
 		},
 	},
 	// string representation of any object
 	{
 		"str",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			var result bytes.Buffer
 
 			for _, arg := range args {
@@ -458,7 +385,7 @@ var Builtins = []*FunctionObject{
 	},
 	{
 		"print",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			objects := []string{}
 
 			for _, arg := range args {
@@ -476,9 +403,9 @@ var Builtins = []*FunctionObject{
 	// in other languages.
 	{
 		"get",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 2 {
-				WrongNumOfArgsError("get", "2", len(args))
+				return WrongNumOfArgsError("get", "2", len(args))
 			}
 
 			dictObj := args[0]
@@ -492,29 +419,29 @@ var Builtins = []*FunctionObject{
 			}
 			dict := dictObj.(*Dictionary)
 
-			key, ok := keyObj.(Hashable)
-			if !ok {
-				BadKeyError(keyObj)
+			if _, ok := HashKeyOf(keyObj); !ok {
+				return BadKeyError(keyObj)
 			}
 
-			result, ok := dict.Values[key.HashKey()]
+			result, ok := dict.Get(keyObj)
 
 			if !ok {
 				return NULL
 			}
 
-			return result.Value
+			return result
 		},
 	},
-	// Used to add an item to a dictionary.
+	// Used to add an item to a dictionary, returning a new dictionary and
+	// leaving the given one unchanged.
 	//
 	// `(set dict 'key' 5)` is the equivalent of `dict['key'] = 5`
-	// in other languages.
+	// in other languages, on a copy of dict.
 	{
 		"set",
-		func(args ...Object) Object {
+		func(caller Caller, args ...Object) Object {
 			if len(args) != 3 {
-				WrongNumOfArgsError("get", "3", len(args))
+				return WrongNumOfArgsError("set", "3", len(args))
 			}
 
 			dictObj := args[0]
@@ -522,29 +449,326 @@ var Builtins = []*FunctionObject{
 			value := args[2]
 
 			if dictObj.Type() != DICT_OBJ {
-				err := fmt.Sprintf("attempted to get from %s(%s) instead of dict", dictObj.Type(), dictObj.Inspect())
+				err := fmt.Sprintf("attempted to set in %s(%s) instead of dict", dictObj.Type(), dictObj.Inspect())
 				return &ErrorObject{
 					Error: err,
 				}
 			}
 
-			key, ok := keyObj.(Hashable)
+			dict, err := dictObj.(*Dictionary).Set(keyObj, value)
+
+			if err != nil {
+				return err
+			}
+
+			return dict
+		},
+	},
+	// Join any number of lists into a new list containing the items of
+	// each in order.
+	{
+		"concat",
+		func(caller Caller, args ...Object) Object {
+			result := NewList()
+
+			for i, arg := range args {
+				list, ok := arg.(*List)
+
+				if !ok {
+					return BadTypeError("concat", arg)
+				}
+
+				// The result shares the items of the first list.
+				if i == 0 {
+					result = list
+					continue
+				}
+
+				for _, item := range list.Items() {
+					result = result.Push(item)
+				}
+			}
+
+			return result
+		},
+	},
+	// Create a Symbol with a name that is different to every other, for use
+	// as a name introduced by a macro that must not clash with the names
+	// around it. An optional string is used as the start of the name.
+	{
+		"gensym",
+		func(caller Caller, args ...Object) Object {
+			if len(args) > 1 {
+				return WrongNumOfArgsError("gensym", "0 or 1", len(args))
+			}
+
+			prefix := "g"
+
+			if len(args) == 1 {
+				str, ok := args[0].(*String)
+
+				if !ok {
+					return BadTypeError("gensym", args[0])
+				}
+
+				prefix = str.Value
+			}
+
+			// The #: prefix marks the name as generated, as in other lisps,
+			// keeping it apart from the names written in source code.
+			name := fmt.Sprintf("#:%s%d", prefix, gensymCounter.Add(1))
+
+			return &Symbol{Name: name}
+		},
+	},
+	// Compare two numbers, strings or lists, resulting in -1, 0 or 1 when the
+	// first is less than, equal to or greater than the second.
+	{
+		"compare",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return WrongNumOfArgsError("compare", "2", len(args))
+			}
+
+			result, err := Compare("compare", args[0], args[1])
+
+			if err != nil {
+				return err
+			}
+
+			return &Number{Value: float64(result)}
+		},
+	},
+	// Call a function with each item of a list, returning a list of the
+	// results.
+	//
+	// `(map f '(1 2))` is the equivalent of `(list (f 1) (f 2))`.
+	{
+		"map",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return WrongNumOfArgsError("map", "2", len(args))
+			}
+
+			list, ok := args[1].(*List)
 
 			if !ok {
-				BadKeyError(keyObj)
+				return BadTypeError("map", args[1])
 			}
 
-			dict := dictObj.(*Dictionary)
-			dict.Values[key.HashKey()] = DictPair{
-				Key:   keyObj,
-				Value: value,
+			result := NewList()
+
+			for _, item := range list.Items() {
+				value := caller.Call(args[0], item)
+
+				if value.Type() == ERROR_OBJ {
+					return value
+				}
+
+				result = result.Push(value)
 			}
 
-			return dict
+			return result
+		},
+	},
+	// Return a list of the items of a list for which a function returns a
+	// true value, in their original order.
+	{
+		"filter",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return WrongNumOfArgsError("filter", "2", len(args))
+			}
+
+			list, ok := args[1].(*List)
+
+			if !ok {
+				return BadTypeError("filter", args[1])
+			}
+
+			result := NewList()
+
+			for _, item := range list.Items() {
+				keep := caller.Call(args[0], item)
+
+				if keep.Type() == ERROR_OBJ {
+					return keep
+				}
+
+				if evalTruthy(keep) {
+					result = result.Push(item)
+				}
+			}
+
+			return result
+		},
+	},
+	// Combine the items of a list into a single value, by calling a function
+	// with the value so far, starting with an initial value, and each item.
+	//
+	// `(reduce + 0 '(1 2))` is the equivalent of `(+ (+ 0 1) 2)`.
+	{
+		"reduce",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 3 {
+				return WrongNumOfArgsError("reduce", "3", len(args))
+			}
+
+			list, ok := args[2].(*List)
+
+			if !ok {
+				return BadTypeError("reduce", args[2])
+			}
+
+			result := args[1]
+
+			for _, item := range list.Items() {
+				result = caller.Call(args[0], result, item)
+
+				if result.Type() == ERROR_OBJ {
+					return result
+				}
+			}
+
+			return result
+		},
+	},
+	// Return the items of a list sorted by the key a function returns for
+	// each of them, compared in the same way as compare. Items with equal
+	// keys keep their original order.
+	{
+		"sort-by",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return WrongNumOfArgsError("sort-by", "2", len(args))
+			}
+
+			list, ok := args[1].(*List)
+
+			if !ok {
+				return BadTypeError("sort-by", args[1])
+			}
+
+			// Keys are found once for each item, before sorting.
+			pairs := []DictPair{}
+
+			for _, item := range list.Items() {
+				key := caller.Call(args[0], item)
+
+				if key.Type() == ERROR_OBJ {
+					return key
+				}
+
+				pairs = append(pairs, DictPair{Key: key, Value: item})
+			}
+
+			var err *ErrorObject
+
+			slices.SortStableFunc(pairs, func(a DictPair, b DictPair) int {
+				order, compareErr := Compare("sort-by", a.Key, b.Key)
+
+				if compareErr != nil && err == nil {
+					err = compareErr
+				}
+
+				return order
+			})
+
+			if err != nil {
+				return err
+			}
+
+			result := NewList()
+
+			for _, pair := range pairs {
+				result = result.Push(pair.Value)
+			}
+
+			return result
+		},
+	},
+	// Call a function with the provided arguments followed by the items of
+	// the list given last.
+	//
+	// `(apply f 1 '(2 3))` is the equivalent of `(f 1 2 3)`.
+	{
+		"apply",
+		func(caller Caller, args ...Object) Object {
+			if len(args) < 2 {
+				return WrongNumOfArgsError("apply", "at least 2", len(args))
+			}
+
+			list, ok := args[len(args)-1].(*List)
+
+			if !ok {
+				return BadTypeError("apply", args[len(args)-1])
+			}
+
+			fnArgs := append(slices.Clone(args[1:len(args)-1]), list.Items()...)
+
+			return caller.Call(args[0], fnArgs...)
+		},
+	},
+	// Call a function with each item of a list in order, for its effects,
+	// returning null.
+	{
+		"for-each",
+		func(caller Caller, args ...Object) Object {
+			if len(args) != 2 {
+				return WrongNumOfArgsError("for-each", "2", len(args))
+			}
+
+			list, ok := args[1].(*List)
+
+			if !ok {
+				return BadTypeError("for-each", args[1])
+			}
+
+			for _, item := range list.Items() {
+				result := caller.Call(args[0], item)
+
+				if result.Type() == ERROR_OBJ {
+					return result
+				}
+			}
+
+			return NULL
 		},
 	},
 }
 
+// Check that comparing each of the provided objects with the one after it
+// gives the expected result, for the builtin fn. Every object is checked,
+// so that objects that cannot be compared are always an error.
+func ordered(fn string, expected int, args []Object) Object {
+	var result Object = TRUE
+
+	for i, arg := range args {
+		// Comparing the first object with itself checks that it can be
+		// compared.
+		previous := arg
+
+		if i > 0 {
+			previous = args[i-1]
+		}
+
+		order, err := Compare(fn, previous, arg)
+
+		if err != nil {
+			return err
+		}
+
+		if i > 0 && order != expected {
+			result = FALSE
+		}
+	}
+
+	return result
+}
+
+// The number of Symbols created by gensym so far.
+var gensymCounter atomic.Uint64
+
 func GetBuiltinByName(name string) *FunctionObject {
 	for _, builtin := range Builtins {
 		if builtin.Name == name {
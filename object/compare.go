@@ -0,0 +1,61 @@
+// Ordering of objects, as used by the compare, < and > builtins.
+package object
+
+// Compare the provided objects, returning -1, 0 or 1 when a is less than,
+// equal to or greater than b. Numbers, strings and lists can each be compared
+// with objects of the same type. Strings are ordered by their bytes, and
+// lists by their first unequal item, with a list coming before any longer
+// list it is the start of.
+//
+// Return an error object, naming the builtin fn, if the objects cannot be
+// compared.
+func Compare(fn string, a Object, b Object) (int, *ErrorObject) {
+	switch a := a.(type) {
+	case *Number:
+		other, ok := b.(*Number)
+
+		if !ok {
+			return 0, mismatchedTypesError(fn, a, b)
+		}
+
+		return compareOrdered(a.Value, other.Value), nil
+	case *String:
+		other, ok := b.(*String)
+
+		if !ok {
+			return 0, mismatchedTypesError(fn, a, b)
+		}
+
+		return compareOrdered(a.Value, other.Value), nil
+	case *List:
+		other, ok := b.(*List)
+
+		if !ok {
+			return 0, mismatchedTypesError(fn, a, b)
+		}
+
+		for i := 0; i < a.Len() && i < other.Len(); i++ {
+			result, err := Compare(fn, a.Get(i), other.Get(i))
+
+			if err != nil || result != 0 {
+				return result, err
+			}
+		}
+
+		return compareOrdered(a.Len(), other.Len()), nil
+	default:
+		return 0, BadTypeError(fn, a)
+	}
+}
+
+// Compare two values of a type with a natural order.
+func compareOrdered[T float64 | string | int](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
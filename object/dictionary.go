@@ -0,0 +1,254 @@
+// Storing and looking up the keys of a Dictionary.
+package object
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+)
+
+const (
+	mapBits = 5
+	mapMask = 1<<mapBits - 1
+)
+
+// mapNode is a node of the hash array mapped trie holding the pairs of a
+// Dictionary. Each level of the trie is indexed by the next mapBits bits of
+// the HashKey value of a key, and only the slots in use are stored. Nodes are
+// never changed once created, setting a key copies the nodes on the path to
+// it and shares the rest.
+type mapNode struct {
+	bitmap  uint32     // the slots of the node in use
+	entries []mapEntry // an entry for each slot in use, in slot order
+}
+
+// mapEntry is a slot of a mapNode, holding either the node below it or a
+// bucket of the pairs whose keys share a HashKey value.
+type mapEntry struct {
+	node  *mapNode
+	hash  uint64
+	pairs []DictPair
+}
+
+// Create an empty Dictionary.
+func NewDictionary() *Dictionary {
+	return &Dictionary{}
+}
+
+// Return the HashKey of the provided object, and whether it can be used as a
+// key in a Dictionary. A list can be a key when each of its items can, and is
+// hashed from the HashKeys of its items. A dictionary can be a key when each
+// of its keys and values can, and is hashed from the HashKeys of its pairs
+// in a way that does not depend on their order, as equal dictionaries may
+// have set their keys in different orders.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hashable:
+		return obj.HashKey(), true
+	case *List:
+		h := fnv.New64a()
+
+		for _, value := range obj.Items() {
+			key, ok := HashKeyOf(value)
+
+			if !ok {
+				return HashKey{}, false
+			}
+
+			h.Write([]byte(key.Type))
+			binary.Write(h, binary.LittleEndian, key.Value)
+		}
+
+		return HashKey{Type: LIST_OBJ, Value: h.Sum64()}, true
+	case *Dictionary:
+		var sum uint64
+
+		for _, pair := range obj.Pairs() {
+			h := fnv.New64a()
+
+			for _, item := range []Object{pair.Key, pair.Value} {
+				key, ok := HashKeyOf(item)
+
+				if !ok {
+					return HashKey{}, false
+				}
+
+				h.Write([]byte(key.Type))
+				binary.Write(h, binary.LittleEndian, key.Value)
+			}
+
+			sum += h.Sum64()
+		}
+
+		return HashKey{Type: DICT_OBJ, Value: sum}, true
+	default:
+		return HashKey{}, false
+	}
+}
+
+// Return the value stored for a key equal to the provided key, and whether
+// there is one.
+func (d *Dictionary) Get(key Object) (Object, bool) {
+	hash, ok := HashKeyOf(key)
+
+	if !ok {
+		return nil, false
+	}
+
+	return d.root.get(hash.Value, 0, key)
+}
+
+// Return a new Dictionary with the provided value stored for the key,
+// replacing the value of any key equal to it. The Dictionary itself is left
+// unchanged.
+//
+// Return an error object if the key cannot be used in a Dictionary.
+func (d *Dictionary) Set(key Object, value Object) (*Dictionary, *ErrorObject) {
+	hash, ok := HashKeyOf(key)
+
+	if !ok {
+		return nil, BadKeyError(key)
+	}
+
+	root, added := d.root.set(hash.Value, 0, DictPair{Key: key, Value: value})
+	result := &Dictionary{root: root, keys: d.keys, count: d.count}
+
+	if added {
+		result.keys = d.orderedKeys().push(key)
+		result.count++
+	}
+
+	return result, nil
+}
+
+// Return the number of keys in the Dictionary.
+func (d *Dictionary) Len() int {
+	return d.count
+}
+
+// Return every key and value stored in the Dictionary, in the order the keys
+// were first set.
+func (d *Dictionary) Pairs() []DictPair {
+	keys := d.orderedKeys()
+	pairs := make([]DictPair, d.count)
+
+	for i := range pairs {
+		key := keys.get(i)
+		value, _ := d.Get(key)
+		pairs[i] = DictPair{Key: key, Value: value}
+	}
+
+	return pairs
+}
+
+// Return the vector of the keys of the Dictionary in the order they were
+// first set.
+func (d *Dictionary) orderedKeys() *vector {
+	if d.keys == nil {
+		return emptyVector
+	}
+
+	return d.keys
+}
+
+// Return the bit of the slot used by the provided hash at the level of the
+// trie given by shift.
+func mapBit(hash uint64, shift uint) uint32 {
+	return 1 << ((hash >> shift) & mapMask)
+}
+
+// Return the index in the entries of the node of the slot with the provided
+// bit.
+func (n *mapNode) index(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// Return the value stored below the node for a key equal to the provided
+// key, which has the provided hash, and whether there is one.
+func (n *mapNode) get(hash uint64, shift uint, key Object) (Object, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	bit := mapBit(hash, shift)
+	index := n.index(bit)
+
+	if n.bitmap&bit == 0 {
+		return nil, false
+	}
+
+	entry := n.entries[index]
+
+	if entry.node != nil {
+		return entry.node.get(hash, shift+mapBits, key)
+	}
+
+	if entry.hash != hash {
+		return nil, false
+	}
+
+	for _, pair := range entry.pairs {
+		if Equal(pair.Key, key) {
+			return pair.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Return a copy of the node with the provided pair stored below it, and
+// whether its key was not already stored.
+func (n *mapNode) set(hash uint64, shift uint, pair DictPair) (*mapNode, bool) {
+	if n == nil {
+		n = &mapNode{}
+	}
+
+	bit := mapBit(hash, shift)
+	index := n.index(bit)
+
+	if n.bitmap&bit == 0 {
+		entry := mapEntry{hash: hash, pairs: []DictPair{pair}}
+
+		return &mapNode{
+			bitmap:  n.bitmap | bit,
+			entries: slices.Insert(slices.Clone(n.entries), index, entry),
+		}, true
+	}
+
+	entry := n.entries[index]
+	added := false
+
+	switch {
+	case entry.node != nil:
+		entry.node, added = entry.node.set(hash, shift+mapBits, pair)
+	case entry.hash == hash:
+		entry.pairs, added = setPair(entry.pairs, pair)
+	default:
+		// Another hash is using the slot, so the bucket moves a level down
+		// where the next bits of the hashes can tell them apart.
+		node := &mapNode{bitmap: mapBit(entry.hash, shift+mapBits), entries: []mapEntry{entry}}
+		node, added = node.set(hash, shift+mapBits, pair)
+		entry = mapEntry{node: node}
+	}
+
+	entries := slices.Clone(n.entries)
+	entries[index] = entry
+
+	return &mapNode{bitmap: n.bitmap, entries: entries}, added
+}
+
+// Return a copy of the bucket with the provided pair replacing the pair with
+// an equal key, or added if there is none, and whether it was added.
+func setPair(bucket []DictPair, pair DictPair) ([]DictPair, bool) {
+	bucket = slices.Clone(bucket)
+
+	for i, existing := range bucket {
+		if Equal(existing.Key, pair.Key) {
+			bucket[i] = pair
+			return bucket, false
+		}
+	}
+
+	return append(bucket, pair), true
+}
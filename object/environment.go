@@ -1,13 +1,38 @@
 // Definition of the Environment type.
 package object
 
-import "fmt"
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
 
 // Environment is the data structure which holds values
 // that are used during program evaluation.
 type Environment struct {
 	outer  *Environment      // The enclosing Environment, where the current Environment was defined.
 	values map[string]Object // A map holding each of the objects defined in the Environment.
+	// The modules imported into a global Environment, by namespace.
+	imports map[string]*imported
+	// The state of the program, shared by the global Environments of every
+	// module in it.
+	program *program
+}
+
+// program is the state of a program being evaluated, which is shared by all
+// of its modules.
+type program struct {
+	// The global Environment of each module evaluated, by path.
+	modules map[string]*Environment
+	// The Meter counting the resources used by the program, if any.
+	meter *Meter
+}
+
+// imported is a module imported into an Environment, of which only the
+// exported names can be used.
+type imported struct {
+	env     *Environment
+	exports []string
 }
 
 // Return the object from the Environment that is associated
@@ -17,10 +42,15 @@ type Environment struct {
 // query the enclosing Environment.
 //
 // If there is no enclosing Environment and the identifier isn't
-// found, an Error Object is returned.
+// found, or it is declared without a value, an Error Object is returned.
 func (e *Environment) Get(ident string) Object {
 	result, ok := e.values[ident]
 
+	if ok && result == nil {
+		err := fmt.Sprintf("undefined variable %s", ident)
+		return &ErrorObject{Error: err}
+	}
+
 	if ok {
 		return result
 	}
@@ -29,6 +59,13 @@ func (e *Environment) Get(ident string) Object {
 		return e.outer.Get(ident)
 	}
 
+	// Names of the form namespace/name refer to the exports of a module.
+	if namespace, name, ok := strings.Cut(ident, "/"); ok {
+		if module, ok := e.imports[namespace]; ok && slices.Contains(module.exports, name) {
+			return module.env.Get(name)
+		}
+	}
+
 	err := fmt.Sprintf("No such item: %s", ident)
 	return &ErrorObject{Error: err}
 }
@@ -39,6 +76,29 @@ func (e *Environment) Set(ident string, obj Object) {
 	e.values[ident] = obj
 }
 
+// Define the provided identifier in the Environment without a value, so that
+// it hides any definition in the enclosing Environments, but is an error to
+// use until it is given one with Set or Assign.
+func (e *Environment) Declare(ident string) {
+	e.values[ident] = nil
+}
+
+// Change the value of an identifier that is already defined, in the
+// innermost Environment that defines it. Report whether the identifier was
+// found.
+func (e *Environment) Assign(ident string, obj Object) bool {
+	if _, ok := e.values[ident]; ok {
+		e.values[ident] = obj
+		return true
+	}
+
+	if e.outer != nil {
+		return e.outer.Assign(ident, obj)
+	}
+
+	return false
+}
+
 // Create a new Environment object and return its address.
 //
 // If an outer Environment is provided, use it to enclose the
@@ -50,7 +110,81 @@ func NewEnvironment(outer *Environment) *Environment {
 
 	if outer != nil {
 		e.outer = outer
+	} else {
+		e.imports = make(map[string]*imported)
+		e.program = &program{modules: make(map[string]*Environment)}
 	}
 
 	return &e
 }
+
+// Create the global Environment of a new module in the same program as this
+// Environment. The values the module defines are separate from those of
+// every other module.
+func (e *Environment) NewModule() *Environment {
+	module := NewEnvironment(nil)
+	module.program = e.global().program
+
+	return module
+}
+
+// Return the global Environment of the module read from the provided path, if
+// it has been evaluated in this program.
+func (e *Environment) Module(path string) (*Environment, bool) {
+	module, ok := e.global().program.modules[path]
+	return module, ok
+}
+
+// Record the global Environment of the module read from the provided path.
+func (e *Environment) SetModule(path string, module *Environment) {
+	e.global().program.modules[path] = module
+}
+
+// Return the Meter counting the resources used by the program the
+// Environment is in, or nil if it has none.
+func (e *Environment) Meter() *Meter {
+	return e.global().program.meter
+}
+
+// Count the resources used by the program the Environment is in with the
+// provided Meter, returning the Meter it replaces.
+func (e *Environment) SetMeter(meter *Meter) *Meter {
+	program := e.global().program
+	previous := program.meter
+	program.meter = meter
+
+	return previous
+}
+
+// Make the provided name, as defined in the global Environment of a module,
+// available in this Environment as namespace/name. Report whether the module
+// defines the name.
+func (e *Environment) Import(namespace string, module *Environment, name string) bool {
+	if _, ok := module.values[name]; !ok {
+		return false
+	}
+
+	global := e.global()
+	imp, ok := global.imports[namespace]
+
+	// Importing a different module under the same namespace replaces it.
+	if !ok || imp.env != module {
+		imp = &imported{env: module}
+		global.imports[namespace] = imp
+	}
+
+	if !slices.Contains(imp.exports, name) {
+		imp.exports = append(imp.exports, name)
+	}
+
+	return true
+}
+
+// Return the global Environment enclosing this Environment.
+func (e *Environment) global() *Environment {
+	for e.outer != nil {
+		e = e.outer
+	}
+
+	return e
+}
@@ -1,6 +1,42 @@
 // A collection of functions for calculating equality between objects.
 package object
 
+// Report whether two objects are equal in the same way as the = builtin.
+// Objects that = cannot compare are never equal, and null is only equal to
+// itself. Lists and dictionaries are equal when their items are.
+func Equal(a Object, b Object) bool {
+	var result *BooleanObject
+
+	switch a := a.(type) {
+	case *Number:
+		result = numsEqual(a.Value, b)
+	case *String:
+		result = stringsEqual(a, b)
+	case *BooleanObject:
+		result = boolEqual(a, b)
+	case *LambdaObject:
+		result = lambdasEqual(a, b)
+	case *FunctionObject:
+		result = functionsEqual(a, b)
+	case *Symbol:
+		result = symbolsEqual(a, b)
+	case *Null:
+		result = nullsEqual(b)
+	case *List:
+		result = listsEqual(a, b)
+	case *Dictionary:
+		result = dictsEqual(a, b)
+	case *Closure:
+		result = closuresEqual(a, b)
+	case *Exception:
+		result = exceptionsEqual(a, b)
+	default:
+		return false
+	}
+
+	return result == TRUE
+}
+
 // Compare list of objects to ensure all have
 // the same value as the initially given number.
 func numsEqual(first float64, rest ...Object) *BooleanObject {
@@ -93,3 +129,103 @@ func functionsEqual(first *FunctionObject, rest ...Object) *BooleanObject {
 
 	return TRUE
 }
+
+// Compare list of objects to ensure all are symbols
+// with the same name as the initially given symbol.
+func symbolsEqual(first *Symbol, rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		symbol, ok := arg.(*Symbol)
+
+		if !ok {
+			return FALSE
+		}
+
+		if symbol.Name != first.Name {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Compare list of objects to ensure all are null.
+func nullsEqual(rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		if _, ok := arg.(*Null); !ok {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Compare list of objects to ensure all are lists with the same number of
+// items as the initially given list, where each item is equal to the item in
+// the same place.
+func listsEqual(first *List, rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		list, ok := arg.(*List)
+
+		if !ok || list.Len() != first.Len() {
+			return FALSE
+		}
+
+		for i := 0; i < list.Len(); i++ {
+			if !Equal(first.Get(i), list.Get(i)) {
+				return FALSE
+			}
+		}
+	}
+
+	return TRUE
+}
+
+// Compare list of objects to ensure all are dictionaries with the same keys
+// as the initially given dictionary, where each key has an equal value.
+func dictsEqual(first *Dictionary, rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		dict, ok := arg.(*Dictionary)
+
+		if !ok || dict.Len() != first.Len() {
+			return FALSE
+		}
+
+		for _, pair := range first.Pairs() {
+			other, ok := dict.Get(pair.Key)
+
+			if !ok || !Equal(pair.Value, other) {
+				return FALSE
+			}
+		}
+	}
+
+	return TRUE
+}
+
+// Compare list of objects to ensure all are the same closure as the
+// initially given closure.
+func closuresEqual(first *Closure, rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		closure, ok := arg.(*Closure)
+
+		if !ok || closure != first {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Compare list of objects to ensure all are the same exception as the
+// initially given exception.
+func exceptionsEqual(first *Exception, rest ...Object) *BooleanObject {
+	for _, arg := range rest {
+		exception, ok := arg.(*Exception)
+
+		if !ok || exception != first {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
@@ -29,3 +29,32 @@ func WrongNumOfArgsError(fn string, expected string, got int) *ErrorObject {
 		fn, expected, got)
 	return &ErrorObject{Error: err}
 }
+
+// Create an error for a builtin called with objects of types that cannot be
+// compared with each other.
+func mismatchedTypesError(fn string, a Object, b Object) *ErrorObject {
+	err := fmt.Sprintf("attempted to call %s with mismatched types %s (%s) and %s (%s)",
+		fn, a.Type(), a.Inspect(), b.Type(), b.Inspect())
+	return &ErrorObject{Error: err}
+}
+
+// Create the error raised by throwing the provided value, which is caught as
+// the value itself. Exceptions are raised again as the error they were caught
+// from.
+func ThrowError(value Object) *ErrorObject {
+	if exc, ok := value.(*Exception); ok {
+		return &ErrorObject{Error: exc.Message, Pos: exc.Pos}
+	}
+
+	err := fmt.Sprintf("uncaught exception: %s", value.Inspect())
+	return &ErrorObject{Error: err, Value: value}
+}
+
+// Return the value a try expression catches for the provided error.
+func Caught(err *ErrorObject) Object {
+	if err.Value != nil {
+		return err.Value
+	}
+
+	return &Exception{Message: err.Error, Pos: err.Pos}
+}
@@ -0,0 +1,209 @@
+// Limits on the resources a program can use while it runs.
+package object
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The limits a LimitError can report as exceeded.
+const (
+	StepLimit       = "step"
+	CallDepthLimit  = "call depth"
+	StackSizeLimit  = "stack size"
+	AllocationLimit = "allocation"
+	TimeLimit       = "time"
+	// Reported when the context a program runs in is cancelled or reaches
+	// its deadline.
+	ContextLimit = "context"
+)
+
+// The number of steps between checks of the clock and the context, which
+// are too slow to make at every step.
+const checkInterval = 1024
+
+// Limits bound the resources a program can use while it runs. A limit left
+// at zero is not enforced, except for CallDepth and StackSize, which then
+// default to the most the engine running the program supports.
+type Limits struct {
+	// The number of instructions the VM executes, or the number of
+	// expressions the evaluator evaluates.
+	Steps int
+	// The number of calls to functions in progress at once. Tail calls
+	// replace the call that made them, so they are not counted.
+	CallDepth int
+	// The number of slots on the stack of the VM, or the number of nested
+	// expressions the evaluator is evaluating at once.
+	StackSize int
+	// The number of objects created by builtins, lambdas and empty lists. A
+	// list or dictionary also counts each item it has added.
+	Allocations int
+	// The time the program runs for.
+	Timeout time.Duration
+}
+
+// LimitError is the error a program ends with when it exceeds one of its
+// Limits, or the context it runs in is done. A program cannot recover from
+// running out of a resource, so it is not caught by try expressions.
+type LimitError struct {
+	Limit string // the limit that was exceeded
+	Err   error  // the error of the context, when it is done
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == ContextLimit {
+		return fmt.Sprintf("execution cancelled: %s", e.Err)
+	}
+
+	return fmt.Sprintf("%s limit exceeded", e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Meter counts the resources a program uses as it runs, and reports when they
+// exceed its Limits or its context is done.
+//
+// The VM counts its calls and the size of its stack with its frame stack and
+// stack, the evaluator with the Meter.
+type Meter struct {
+	ctx         context.Context
+	limits      Limits
+	deadline    time.Time // when the Timeout is reached, zero without one
+	steps       int
+	allocations int
+	calls       int
+	depth       int
+	// The number of steps left until the clock and the context are checked.
+	countdown int
+}
+
+// Create a Meter for a program starting now, in the provided context.
+func NewMeter(ctx context.Context, limits Limits) *Meter {
+	m := &Meter{ctx: ctx, limits: limits}
+
+	if limits.Timeout > 0 {
+		m.deadline = time.Now().Add(limits.Timeout)
+	}
+
+	return m
+}
+
+// Count a step of the program, returning a LimitError if it has taken too
+// many steps, run for too long, or its context is done.
+func (m *Meter) Step() error {
+	m.steps++
+
+	if m.limits.Steps > 0 && m.steps > m.limits.Steps {
+		return &LimitError{Limit: StepLimit}
+	}
+
+	m.countdown--
+
+	if m.countdown > 0 {
+		return nil
+	}
+
+	m.countdown = checkInterval
+
+	if !m.deadline.IsZero() && time.Now().After(m.deadline) {
+		return &LimitError{Limit: TimeLimit, Err: context.DeadlineExceeded}
+	}
+
+	if err := m.ctx.Err(); err != nil {
+		return &LimitError{Limit: ContextLimit, Err: err}
+	}
+
+	return nil
+}
+
+// Count the provided number of objects created by the program, returning a
+// LimitError if it has created too many.
+func (m *Meter) Allocate(n int) error {
+	m.allocations += n
+
+	if m.limits.Allocations > 0 && m.allocations > m.limits.Allocations {
+		return &LimitError{Limit: AllocationLimit}
+	}
+
+	return nil
+}
+
+// Count the start of a call, returning a LimitError instead if too many calls
+// are already in progress. Each call counted must be ended with Return.
+func (m *Meter) Call() error {
+	if m.limits.CallDepth > 0 && m.calls >= m.limits.CallDepth {
+		return &LimitError{Limit: CallDepthLimit}
+	}
+
+	m.calls++
+
+	return nil
+}
+
+// Count the end of a call started with Call.
+func (m *Meter) Return() {
+	m.calls--
+}
+
+// Count the start of the evaluation of an expression nested in the ones
+// being evaluated, returning a LimitError instead if they are nested too
+// deeply. Each expression counted must be ended with Pop.
+func (m *Meter) Push() error {
+	if m.limits.StackSize > 0 && m.depth >= m.limits.StackSize {
+		return &LimitError{Limit: StackSizeLimit}
+	}
+
+	m.depth++
+
+	return nil
+}
+
+// Count the end of the evaluation of an expression started with Push.
+func (m *Meter) Pop() {
+	m.depth--
+}
+
+// Return the number of objects created by a builtin called with the provided
+// arguments, which resulted in the provided object. A list or dictionary
+// counts one for itself and one for each item it has beyond those of the
+// largest collection of the same type it was passed, which it may share.
+func Allocated(result Object, args []Object) int {
+	if result == TRUE || result == FALSE || result == NULL {
+		return 0
+	}
+
+	if _, ok := result.(*ErrorObject); ok {
+		return 0
+	}
+
+	shared := 0
+
+	for _, arg := range args {
+		if arg == result {
+			return 0
+		}
+
+		switch arg := arg.(type) {
+		case *List:
+			if _, ok := result.(*List); ok {
+				shared = max(shared, arg.Len())
+			}
+		case *Dictionary:
+			if _, ok := result.(*Dictionary); ok {
+				shared = max(shared, arg.Len())
+			}
+		}
+	}
+
+	switch result := result.(type) {
+	case *List:
+		return 1 + max(result.Len()-shared, 0)
+	case *Dictionary:
+		return 1 + max(result.Len()-shared, 0)
+	}
+
+	return 1
+}
@@ -8,6 +8,8 @@ import (
 	"hash/fnv"
 	"lisp/ast"
 	"lisp/code"
+	"lisp/token"
+	"math"
 	"strings"
 )
 
@@ -23,10 +25,22 @@ const (
 	ERROR_OBJ             = "ERROR"
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 	CLOSURE_OBJ           = "CLOSURE"
+	SYMBOL_OBJ            = "SYMBOL"
+	EXCEPTION_OBJ         = "EXCEPTION"
 )
 
-// The Function type is the definition of a builtin function.
-type Function func(args ...Object) Object
+// The Function type is the definition of a builtin function. Builtins that
+// take functions as arguments call them through the provided Caller.
+type Function func(caller Caller, args ...Object) Object
+
+// Caller calls functions on behalf of a builtin, using the engine that is
+// running the builtin, so that builtins can call lambdas as well as other
+// builtins.
+type Caller interface {
+	// Call the provided function with the arguments and return its result,
+	// or an error object if the function is not callable or fails.
+	Call(fn Object, args ...Object) Object
+}
 
 type ObjectType string
 
@@ -78,9 +92,27 @@ func (s *String) Inspect() string {
 	return s.Value
 }
 
-// The List Object wraps an Object slice.
+// Symbol is an Object that holds a name as data, as produced by quoting an
+// identifier. Symbols are never looked up as variables.
+type Symbol struct {
+	Name string
+}
+
+func (s *Symbol) Type() ObjectType {
+	return SYMBOL_OBJ
+}
+
+// Return the name of the Symbol.
+func (s *Symbol) Inspect() string {
+	return s.Name
+}
+
+// The List Object holds a sequence of Objects. Lists are never changed once
+// created: adding an item or dropping the first creates a new List, sharing
+// the items of the original.
 type List struct {
-	Values []Object
+	items *vector // nil when the List was created empty
+	start int     // the index in items of the first item of the List
 }
 
 func (l *List) Type() ObjectType {
@@ -96,7 +128,7 @@ func (l *List) Inspect() string {
 
 	items := []string{}
 
-	for _, val := range l.Values {
+	for _, val := range l.Items() {
 		items = append(items, val.Inspect())
 	}
 
@@ -154,7 +186,8 @@ func (n *Null) Inspect() string {
 
 // The Lambda type stores user defined lambda functions.
 type LambdaObject struct {
-	Args []string         // The Arguments passed to the function.
+	Name   string         // The name the lambda was defined with, empty for an anonymous lambda.
+	Params *Parameters    // The parameters the arguments passed to the function are bound to.
 	Env  *Environment     // The Environment in which the lambda was defined, allowing for closures.
 	Body []ast.Expression // The SExpressions defined by the user, which are evaluated when the lambda is called.
 }
@@ -168,11 +201,9 @@ func (l *LambdaObject) Type() ObjectType {
 func (l *LambdaObject) Inspect() string {
 	var result bytes.Buffer
 
-	result.WriteString("(lambda (")
-	result.WriteString(
-		strings.Join(l.Args, " "),
-	)
-	result.WriteString(") ")
+	result.WriteString("(lambda ")
+	result.WriteString(l.Params.String())
+	result.WriteString(" ")
 
 	body := []string{}
 
@@ -191,6 +222,13 @@ func (l *LambdaObject) Inspect() string {
 // goes wrong.
 type ErrorObject struct {
 	Error string
+	Pos   token.Position // Where in the source the error occurred, if known.
+	Value Object         // The value thrown by a throw expression, nil for other errors.
+	Limit *LimitError    // The limit the program exceeded, which is not caught by try.
+	// The functions the error has left so far, innermost first.
+	Trace StackTrace
+	// Where the error reached in the function it is currently leaving.
+	reached token.Position
 }
 
 func (e *ErrorObject) Type() ObjectType {
@@ -198,17 +236,39 @@ func (e *ErrorObject) Type() ObjectType {
 }
 
 func (e *ErrorObject) Inspect() string {
+	if e.Pos.IsValid() {
+		return fmt.Sprintf("ERROR: %s: %s", e.Pos, e.Error)
+	}
+
 	return fmt.Sprintf("ERROR: %s", e.Error)
 }
 
+// Exception is an error caught by a try expression, as a value that can be
+// inspected, passed around and thrown again.
+type Exception struct {
+	Message string
+	Pos     token.Position // Where in the source the error occurred, if known.
+}
+
+func (e *Exception) Type() ObjectType {
+	return EXCEPTION_OBJ
+}
+
+// Return the message of the error.
+func (e *Exception) Inspect() string {
+	return e.Message
+}
+
 // The HashKey Object stores a hashed value of a Hashable Object so
-// that it can be used as a key in a Dictionary.
+// that it can be used as a key in a Dictionary. Objects that are not equal
+// can share a HashKey, so it only narrows down where a key is stored.
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
 }
 
 // Used to establish if an Object can be used as a key in a Dictionary.
+// Lists can also be keys when all of their items can, see HashKeyOf.
 type Hashable interface {
 	HashKey() HashKey
 }
@@ -223,7 +283,7 @@ func (b *BooleanObject) HashKey() HashKey {
 		value = 0
 	}
 
-	return HashKey{Type: STRING_OBJ, Value: value}
+	return HashKey{Type: BOOLEAN_OBJ, Value: value}
 }
 
 // Create a HashKey object that represents a String
@@ -235,6 +295,31 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: STRING_OBJ, Value: h.Sum64()}
 }
 
+// Create a HashKey object that represents a Number from the bits of its
+// value, treating 0 and -0 as the same number as = does.
+func (f *Number) HashKey() HashKey {
+	value := f.Value
+
+	if value == 0 {
+		value = 0
+	}
+
+	return HashKey{Type: NUMBER_OBJ, Value: math.Float64bits(value)}
+}
+
+// Create a HashKey object that represents a Symbol from its name.
+func (s *Symbol) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Name))
+
+	return HashKey{Type: SYMBOL_OBJ, Value: h.Sum64()}
+}
+
+// Create a HashKey object that represents null.
+func (n *Null) HashKey() HashKey {
+	return HashKey{Type: NULL_OBJ}
+}
+
 // The DictPair type represents both the key and value
 // to be stored in a Dictionary.
 type DictPair struct {
@@ -242,10 +327,17 @@ type DictPair struct {
 	Value Object
 }
 
-// The Dictionary type wraps a map of HashKey to DictPair
-// where the HashKey is created from the Key from the DictPair.
+// The Dictionary type maps keys to values, storing each DictPair in a bucket
+// by the HashKey of its Key. Keys that share a HashKey are told apart with
+// Equal. Dictionaries are never changed once created: setting a key creates
+// a new Dictionary, sharing the pairs of the original.
+//
+// The keys are kept in the order they were first set, which is the order
+// they are printed and iterated in.
 type Dictionary struct {
-	Values map[HashKey]DictPair
+	root  *mapNode // nil when the Dictionary is empty
+	keys  *vector  // nil when the Dictionary is empty
+	count int      // the number of pairs across all buckets
 }
 
 func (d *Dictionary) Type() ObjectType {
@@ -253,7 +345,8 @@ func (d *Dictionary) Type() ObjectType {
 }
 
 // Create a string representation of a Dictionary by
-// concatenating the string representations of its DictPairs.
+// concatenating the string representations of its DictPairs, in the order
+// their keys were added.
 func (d *Dictionary) Inspect() string {
 	var result bytes.Buffer
 
@@ -261,7 +354,7 @@ func (d *Dictionary) Inspect() string {
 
 	items := []string{}
 
-	for _, pair := range d.Values {
+	for _, pair := range d.Pairs() {
 		items = append(
 			items,
 			fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()),
@@ -276,9 +369,40 @@ func (d *Dictionary) Inspect() string {
 
 // CompiledLambda is an object that holds compiled instructions.
 type CompiledLambda struct {
-	Instructions   code.Instructions
-	LocalsCount    int
-	ParameterCount int
+	Instructions code.Instructions
+	LocalsCount  int
+	Signature    Signature // the arguments accepted, which fill the first local slots
+	Name         string    // the name the lambda was defined with, empty if anonymous
+	Lines        LineTable // the position in the source of each instruction
+	// Inline lambdas are compiled to run the body of an expression such as
+	// let, and are left out of stack traces in favour of the lambda the
+	// expression is in.
+	Inline bool
+	// The variables captured from the enclosing scope when a Closure is
+	// created from the lambda, in the order of the Closure's Free slice.
+	Captures []Capture
+	// The name of each local slot and of each captured variable, for
+	// debuggers.
+	LocalNames []string
+	FreeNames  []string
+}
+
+// The place a captured variable is found in the scope enclosing a lambda.
+type CaptureScope int
+
+const (
+	// A local binding of the enclosing function.
+	CaptureLocal CaptureScope = iota
+	// A variable captured by the enclosing Closure.
+	CaptureFree
+	// The enclosing Closure itself, which is referred to by its own name.
+	CaptureCurrentClosure
+)
+
+// Capture describes a variable to capture when creating a Closure.
+type Capture struct {
+	Scope CaptureScope
+	Index int // the index of the local or free variable, unused otherwise
 }
 
 func (cl *CompiledLambda) Type() ObjectType {
@@ -294,9 +418,12 @@ func (cl *CompiledLambda) Inspect() string {
 // Closure is a wrapper around a CompiledFunction instance that allows it to
 // carry free variables with it, which are variables defined in an enclosing
 // scope. All CompiledLambda objects will be wrapped and treated as Closures.
+//
+// Free variables are shared with the scope they were captured from, so a
+// change made to one is seen by every Closure that captured it.
 type Closure struct {
 	Lambda *CompiledLambda
-	Free   []Object
+	Free   []*Upvalue
 }
 
 func (cl *Closure) Type() ObjectType {
@@ -306,3 +433,44 @@ func (cl *Closure) Type() ObjectType {
 func (cl *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", cl)
 }
+
+// An Upvalue is a cell holding a variable captured by a Closure.
+//
+// While the function that defined the variable is still running, the Upvalue
+// is open and refers to the variable's slot on that function's stack, so
+// that the function and its Closures share changes. When the function
+// returns, the Upvalue is closed by moving the value into the Upvalue itself.
+type Upvalue struct {
+	Location *Object // where the value of the variable is stored
+	Index    int     // the stack slot the variable occupies while open
+	closed   Object
+}
+
+// Create an open Upvalue for the variable held in the provided stack slot.
+func NewOpenUpvalue(slot *Object, index int) *Upvalue {
+	return &Upvalue{Location: slot, Index: index}
+}
+
+// Create a closed Upvalue holding the provided value.
+func NewClosedUpvalue(value Object) *Upvalue {
+	u := &Upvalue{closed: value}
+	u.Location = &u.closed
+
+	return u
+}
+
+// Return the current value of the captured variable.
+func (u *Upvalue) Get() Object {
+	return *u.Location
+}
+
+// Change the value of the captured variable.
+func (u *Upvalue) Set(value Object) {
+	*u.Location = value
+}
+
+// Move the value out of the stack slot and into the Upvalue.
+func (u *Upvalue) Close() {
+	u.closed = *u.Location
+	u.Location = &u.closed
+}
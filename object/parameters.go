@@ -0,0 +1,285 @@
+// The parameter lists of lambdas, shared by the evaluator and the compiler.
+package object
+
+import (
+	"fmt"
+	"lisp/ast"
+	"lisp/token"
+	"slices"
+	"strings"
+)
+
+// Signature describes the arguments a lambda accepts. Each parameter is given
+// a slot, in the order: required, optional, rest, then keyword parameters.
+type Signature struct {
+	Required int      // the number of parameters that must be passed
+	Optional int      // the number of positional parameters that may be left out
+	Rest     bool     // whether extra arguments are collected into a list
+	Keywords []string // the names of the keyword parameters, without the ':'
+}
+
+// Return the number of slots needed to hold the parameters.
+func (s Signature) Len() int {
+	length := s.Required + s.Optional + len(s.Keywords)
+
+	if s.Rest {
+		length++
+	}
+
+	return length
+}
+
+// Report whether the Signature only has required parameters.
+func (s Signature) IsFixed() bool {
+	return s.Optional == 0 && !s.Rest && len(s.Keywords) == 0
+}
+
+// Arrange the arguments of a call into the slot of each parameter.
+//
+// Rest parameters receive a list of the arguments after the positional ones,
+// and keyword parameters receive the value following their keyword in those
+// same arguments. The slot of an optional or keyword parameter that was not
+// passed an argument is left nil.
+func (s Signature) Bind(args []Object) ([]Object, error) {
+	positional := s.Required + s.Optional
+
+	if len(args) < s.Required || (len(args) > positional && !s.Rest && len(s.Keywords) == 0) {
+		return nil, fmt.Errorf("wrong number of arguments: expected=%s got=%d", s.arity(), len(args))
+	}
+
+	slots := make([]Object, s.Len())
+	copy(slots, args[:min(len(args), positional)])
+
+	extra := []Object{}
+
+	if len(args) > positional {
+		extra = append(extra, args[positional:]...)
+	}
+
+	i := positional
+
+	if s.Rest {
+		slots[i] = NewList(extra...)
+		i++
+	}
+
+	if len(s.Keywords) == 0 {
+		return slots, nil
+	}
+
+	if len(extra)%2 != 0 {
+		return nil, fmt.Errorf("keyword arguments must be pairs of keyword and value, got %d values", len(extra))
+	}
+
+	for j := 0; j < len(extra); j += 2 {
+		key, ok := extra[j].(*Symbol)
+
+		if !ok || !IsKeyword(key.Name) {
+			return nil, fmt.Errorf("expected keyword argument, got %s", extra[j].Inspect())
+		}
+
+		index := slices.Index(s.Keywords, key.Name[1:])
+
+		if index == -1 {
+			return nil, fmt.Errorf("unknown keyword argument %s", key.Name)
+		}
+
+		slots[i+index] = extra[j+1]
+	}
+
+	return slots, nil
+}
+
+// Describe the number of positional arguments the Signature accepts.
+func (s Signature) arity() string {
+	switch {
+	case s.Rest || len(s.Keywords) > 0:
+		return fmt.Sprintf("at least %d", s.Required)
+	case s.Optional > 0:
+		return fmt.Sprintf("%d to %d", s.Required, s.Required+s.Optional)
+	default:
+		return fmt.Sprintf("%d", s.Required)
+	}
+}
+
+// Report whether the name is a keyword, such as :verbose, which evaluates to
+// a symbol of itself.
+func IsKeyword(name string) bool {
+	return len(name) > 1 && name[0] == ':'
+}
+
+// Parameters is the parameter list of a lambda, of the form:
+//
+//	(a b &optional c (d default) &rest r &key e (f default))
+//
+// where each section is optional, and `. r` may be written in place of
+// `&rest r`.
+type Parameters struct {
+	Names     []string         // the name of each parameter, in slot order
+	Defaults  []ast.Expression // the default of each parameter, nil if it has none
+	Signature Signature
+}
+
+// ParameterError is a problem with the parameter list of a lambda.
+type ParameterError struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *ParameterError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Message)
+}
+
+// Create Parameters made up of only required parameters with the provided
+// names.
+func NewParameters(names []string) *Parameters {
+	return &Parameters{
+		Names:     names,
+		Defaults:  make([]ast.Expression, len(names)),
+		Signature: Signature{Required: len(names)},
+	}
+}
+
+// The sections of a parameter list, in the order they must appear.
+const (
+	requiredSection = iota
+	optionalSection
+	restSection
+	keySection
+)
+
+// The markers that begin each section of a parameter list.
+var sectionMarkers = map[string]int{
+	"&optional": optionalSection,
+	"&rest":     restSection,
+	".":         restSection,
+	"&key":      keySection,
+}
+
+// Parse the parameter list of a lambda.
+func ParseParameters(expr ast.Expression) (*Parameters, error) {
+	list, ok := expr.(*ast.SExpression)
+
+	if !ok {
+		return nil, &ParameterError{expr.Pos(), fmt.Sprintf("lambda parameters must be a list, got %s", expr)}
+	}
+
+	params := &Parameters{
+		Names:    []string{},
+		Defaults: []ast.Expression{},
+	}
+
+	if list.Fn == nil {
+		return params, nil
+	}
+
+	section := requiredSection
+	restNamed := false
+
+	for _, p := range append([]ast.Expression{list.Fn}, list.Args...) {
+		if ident, ok := p.(*ast.Identifier); ok {
+			if next, ok := sectionMarkers[ident.String()]; ok {
+				if next <= section {
+					return nil, &ParameterError{p.Pos(), fmt.Sprintf("%s is out of place in parameters", ident)}
+				}
+
+				if section == restSection && !restNamed {
+					return nil, &ParameterError{p.Pos(), "rest parameter must be named"}
+				}
+
+				section = next
+				continue
+			}
+		}
+
+		name, def, err := parseParameter(p, section)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if slices.Contains(params.Names, name.String()) {
+			return nil, &ParameterError{name.Pos(), fmt.Sprintf("duplicate parameter %s", name)}
+		}
+
+		params.Names = append(params.Names, name.String())
+		params.Defaults = append(params.Defaults, def)
+
+		switch section {
+		case requiredSection:
+			params.Signature.Required++
+		case optionalSection:
+			params.Signature.Optional++
+		case restSection:
+			if restNamed {
+				return nil, &ParameterError{p.Pos(), "only one rest parameter is allowed"}
+			}
+
+			restNamed = true
+			params.Signature.Rest = true
+		case keySection:
+			params.Signature.Keywords = append(params.Signature.Keywords, name.String())
+		}
+	}
+
+	if section == restSection && !restNamed {
+		return nil, &ParameterError{list.Pos(), "rest parameter must be named"}
+	}
+
+	return params, nil
+}
+
+// Parse a single parameter, which is either a name, or for optional and
+// keyword parameters may also be a list of a name and its default.
+func parseParameter(p ast.Expression, section int) (*ast.Identifier, ast.Expression, error) {
+	switch p := p.(type) {
+	case *ast.Identifier:
+		return p, nil, nil
+	case *ast.SExpression:
+		if section != optionalSection && section != keySection {
+			break
+		}
+
+		name, ok := p.Fn.(*ast.Identifier)
+
+		if !ok || len(p.Args) != 1 {
+			return nil, nil, &ParameterError{p.Pos(), fmt.Sprintf("parameter with default must be of the form (name default), got %s", p)}
+		}
+
+		return name, p.Args[0], nil
+	}
+
+	return nil, nil, &ParameterError{p.Pos(), fmt.Sprintf("lambda parameter must be identifier, got %s", p)}
+}
+
+// Recreate the source of the parameter list.
+func (p *Parameters) String() string {
+	items := []string{}
+
+	optional := p.Signature.Required
+	rest := optional + p.Signature.Optional
+	keys := rest
+
+	if p.Signature.Rest {
+		keys++
+	}
+
+	for i, name := range p.Names {
+		switch {
+		case i == optional && p.Signature.Optional > 0:
+			items = append(items, "&optional")
+		case i == rest && p.Signature.Rest:
+			items = append(items, "&rest")
+		case i == keys && len(p.Signature.Keywords) > 0:
+			items = append(items, "&key")
+		}
+
+		if p.Defaults[i] != nil {
+			items = append(items, fmt.Sprintf("(%s %s)", name, p.Defaults[i]))
+		} else {
+			items = append(items, name)
+		}
+	}
+
+	return "(" + strings.Join(items, " ") + ")"
+}
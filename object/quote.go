@@ -0,0 +1,44 @@
+// Conversion of quoted source code into data.
+package object
+
+import "lisp/ast"
+
+// Quote returns the data represented by a quoted expression without
+// evaluating any part of it.
+//
+// Numbers and strings become their matching Objects, identifiers become
+// Symbols (with the exception of true, false and null, which keep their usual
+// values), and SExpressions become Lists of their quoted elements.
+func Quote(expr ast.Expression) Object {
+	switch expr := expr.(type) {
+	case *ast.FloatLiteral:
+		return &Number{Value: expr.Value}
+	case *ast.StringLiteral:
+		return &String{Value: expr.Value}
+	case *ast.Identifier:
+		switch expr.String() {
+		case "true":
+			return TRUE
+		case "false":
+			return FALSE
+		case "null":
+			return NULL
+		}
+
+		return &Symbol{Name: expr.String()}
+	case *ast.SExpression:
+		values := []Object{}
+
+		if expr.Fn != nil {
+			values = append(values, Quote(expr.Fn))
+		}
+
+		for _, arg := range expr.Args {
+			values = append(values, Quote(arg))
+		}
+
+		return NewList(values...)
+	default:
+		return NULL
+	}
+}
@@ -0,0 +1,98 @@
+// Stack traces of the functions that were running when an error occurred.
+package object
+
+import (
+	"fmt"
+	"lisp/token"
+	"sort"
+	"strings"
+)
+
+// The name given to the top level of a program in stack traces.
+const MainFunctionName = "<main>"
+
+// StackFrame is a function that was running when an error occurred, and the
+// position in the source it had reached.
+type StackFrame struct {
+	Name string // the name of the function, empty for an anonymous lambda
+	Pos  token.Position
+}
+
+// Return the frame in the form name (position).
+func (f StackFrame) String() string {
+	name := f.Name
+
+	if name == "" {
+		name = "lambda"
+	}
+
+	if !f.Pos.IsValid() {
+		return name
+	}
+
+	return fmt.Sprintf("%s (%s)", name, f.Pos)
+}
+
+// StackTrace is the frames of the functions that were running when an error
+// occurred, innermost first.
+type StackTrace []StackFrame
+
+// Return the trace with a line for each frame, of the form "  at frame".
+func (t StackTrace) String() string {
+	var result strings.Builder
+
+	for _, frame := range t {
+		result.WriteString("  at ")
+		result.WriteString(frame.String())
+		result.WriteString("\n")
+	}
+
+	return result.String()
+}
+
+// LineEntry records the position in the source that the instructions from an
+// offset onwards were compiled from.
+type LineEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// LineTable maps the offsets of compiled instructions to the positions in the
+// source they were compiled from, with an entry, in order of offset, for each
+// offset at which the position changes.
+type LineTable []LineEntry
+
+// Return the position that the instruction at the provided offset was
+// compiled from, or an invalid position if it is not known.
+func (t LineTable) Lookup(offset int) token.Position {
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+
+	if i == 0 {
+		return token.Position{}
+	}
+
+	return t[i-1].Pos
+}
+
+// Record the provided position as where the error reached in the function it
+// is leaving, unless a position has already been recorded. Errors pass
+// through the innermost expressions first, so the first position recorded is
+// the most precise.
+func (e *ErrorObject) Reach(pos token.Position) {
+	if !e.reached.IsValid() {
+		e.reached = pos
+	}
+}
+
+// Add a frame for the function with the provided name to the stack trace of
+// the error, as it leaves the function, at the position recorded by Reach.
+func (e *ErrorObject) LeaveFunction(name string) {
+	e.Trace = append(e.Trace, StackFrame{Name: name, Pos: e.reached})
+	e.reached = token.Position{}
+}
+
+// Return a copy of the error as it is raised again by the code that handled
+// it, with a stack trace that starts from where it is raised again.
+func (e *ErrorObject) Rethrown() *ErrorObject {
+	return &ErrorObject{Error: e.Error, Pos: e.Pos, Value: e.Value, Limit: e.Limit}
+}
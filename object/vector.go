@@ -0,0 +1,159 @@
+// A persistent vector, the storage behind a List.
+package object
+
+import "slices"
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+// vector is an immutable sequence of objects, stored as a trie of nodes
+// holding up to vectorWidth children or items each, with the last items kept
+// in a separate tail. Adding an item copies at most one node on each level of
+// the trie, sharing the rest with the vector it was added to.
+type vector struct {
+	count int
+	shift uint        // the number of index bits below the root
+	root  *vectorNode // the items before the tail, vectorWidth to a leaf
+	tail  []Object    // the last 1 to vectorWidth items, or none when empty
+}
+
+// vectorNode is a node of the trie of a vector. Branches hold children and
+// leaves hold items.
+type vectorNode struct {
+	children []*vectorNode
+	items    []Object
+}
+
+// The vector with no items, which every vector is built from.
+var emptyVector = &vector{shift: vectorBits, root: &vectorNode{}}
+
+// Return the index of the first item in the tail.
+func (v *vector) tailOffset() int {
+	return v.count - len(v.tail)
+}
+
+// Return the item at the provided index, which must be less than the number
+// of items.
+func (v *vector) get(index int) Object {
+	if index >= v.tailOffset() {
+		return v.tail[index-v.tailOffset()]
+	}
+
+	node := v.root
+
+	for level := v.shift; level > 0; level -= vectorBits {
+		node = node.children[(index>>level)&vectorMask]
+	}
+
+	return node.items[index&vectorMask]
+}
+
+// Return a vector with the provided item added to the end.
+func (v *vector) push(item Object) *vector {
+	// Capping the tail makes append copy it, so it is never shared.
+	if len(v.tail) < vectorWidth {
+		return &vector{
+			count: v.count + 1,
+			shift: v.shift,
+			root:  v.root,
+			tail:  append(v.tail[:len(v.tail):len(v.tail)], item),
+		}
+	}
+
+	// The tail is full, so it becomes a leaf of the trie and a new tail is
+	// started. The root is split when the trie has no room left.
+	leaf := &vectorNode{items: v.tail}
+	root := v.root
+	shift := v.shift
+
+	if v.count>>vectorBits > 1<<v.shift {
+		root = &vectorNode{children: []*vectorNode{v.root, newVectorPath(v.shift, leaf)}}
+		shift += vectorBits
+	} else {
+		root = v.pushLeaf(v.shift, v.root, leaf)
+	}
+
+	return &vector{count: v.count + 1, shift: shift, root: root, tail: []Object{item}}
+}
+
+// Return a copy of the provided node at the provided level, with the leaf
+// added after the last leaf below it.
+func (v *vector) pushLeaf(level uint, node *vectorNode, leaf *vectorNode) *vectorNode {
+	index := ((v.count - 1) >> level) & vectorMask
+	children := slices.Clone(node.children)
+
+	switch {
+	case level == vectorBits:
+		children = append(children, leaf)
+	case index < len(children):
+		children[index] = v.pushLeaf(level-vectorBits, children[index], leaf)
+	default:
+		children = append(children, newVectorPath(level-vectorBits, leaf))
+	}
+
+	return &vectorNode{children: children}
+}
+
+// Return a chain of branches from the provided level down to the leaf.
+func newVectorPath(level uint, leaf *vectorNode) *vectorNode {
+	if level == 0 {
+		return leaf
+	}
+
+	return &vectorNode{children: []*vectorNode{newVectorPath(level-vectorBits, leaf)}}
+}
+
+// Create a List of the provided items.
+func NewList(items ...Object) *List {
+	v := emptyVector
+
+	for _, item := range items {
+		v = v.push(item)
+	}
+
+	return &List{items: v}
+}
+
+// Return the vector holding the items of the List.
+func (l *List) vector() *vector {
+	if l.items == nil {
+		return emptyVector
+	}
+
+	return l.items
+}
+
+// Return the number of items in the List.
+func (l *List) Len() int {
+	return l.vector().count - l.start
+}
+
+// Return the item at the provided index, which must be less than the length
+// of the List.
+func (l *List) Get(index int) Object {
+	return l.vector().get(l.start + index)
+}
+
+// Return a new List with the provided item added to the end.
+func (l *List) Push(item Object) *List {
+	return &List{items: l.vector().push(item), start: l.start}
+}
+
+// Return a new List of every item but the first, which must exist.
+func (l *List) Rest() *List {
+	return &List{items: l.items, start: l.start + 1}
+}
+
+// Return the items of the List as a new slice.
+func (l *List) Items() []Object {
+	items := make([]Object, l.Len())
+
+	for i := range items {
+		items[i] = l.Get(i)
+	}
+
+	return items
+}
@@ -0,0 +1,48 @@
+package parser
+
+import (
+	"fmt"
+	"lisp/token"
+)
+
+// Severity describes how serious a Diagnostic is.
+type Severity int
+
+const (
+	// The source code is invalid and cannot be run.
+	Error Severity = iota
+	// The source code can be run, but is likely to contain a mistake.
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic describes a problem found in the source code during parsing.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	Pos      token.Position // Where in the source the problem was found.
+	Hint     string         // An optional suggestion for how to fix the problem.
+}
+
+// Return the Diagnostic in the form:
+//
+//	file:line:column: severity: message (hint: hint)
+func (d Diagnostic) String() string {
+	msg := fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Message)
+
+	if d.Hint != "" {
+		msg = fmt.Sprintf("%s (hint: %s)", msg, d.Hint)
+	}
+
+	return msg
+}
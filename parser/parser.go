@@ -12,10 +12,25 @@ import (
 // The Parser type is used to transform the Tokens provided by
 // a Lexer into an AST that can be evaluated.
 type Parser struct {
-	lexer     *lexer.Lexer // The Lexer that provides the Tokens.
-	curToken  token.Token  // The Token currently added to the AST.
-	peekToken token.Token  // The next Token to be parsed, used for look-ahead.
-	Errors    []string     // A collection of Errors encountered during parsing.
+	lexer       *lexer.Lexer // The Lexer that provides the Tokens.
+	curToken    token.Token  // The Token currently added to the AST.
+	peekToken   token.Token  // The next Token to be parsed, used for look-ahead.
+	Diagnostics []Diagnostic // A collection of problems encountered during parsing.
+
+	open   []token.Token // The opening Tokens of the expressions currently being parsed.
+	resync *parserState  // Where to resume parsing if the current top-level form is unclosed.
+}
+
+// A snapshot of the Parser's position in the source, used to resume parsing
+// from an earlier point.
+type parserState struct {
+	lexer     lexer.Lexer
+	curToken  token.Token
+	peekToken token.Token
+	// The number of Diagnostics recorded before this point.
+	diagnosticCount int
+	// The innermost expression that was still open at this point.
+	unclosed token.Token
 }
 
 // Create a new Parser instance that uses the provided Lexer.
@@ -28,15 +43,46 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
+// Return the Diagnostics with Error severity. A program should only be run
+// when this is empty.
+func (p *Parser) Errors() []Diagnostic {
+	errors := []Diagnostic{}
+
+	for _, d := range p.Diagnostics {
+		if d.Severity == Error {
+			errors = append(errors, d)
+		}
+	}
+
+	return errors
+}
+
 // Transform the supplied Token list into an AST representing the program.
 //
-// This also populates the Errors field with parser errors encountered.
+// This also populates the Diagnostics field with problems encountered. A
+// top-level form that contains an error is left out of the AST, and parsing
+// continues with the next top-level form so that every error is reported.
 func (p *Parser) ParseProgram() *ast.Program {
 	expressions := []ast.Expression{}
 
 	p.readToken()
 	for p.curToken.Type != token.EOF {
-		expressions = append(expressions, p.parseExpression())
+		p.resync = nil
+		errorCount := len(p.Errors())
+
+		expression := p.parseExpression()
+
+		if len(p.Errors()) == errorCount {
+			expressions = append(expressions, expression)
+			continue
+		}
+
+		// A form left unclosed consumes the rest of the input. Resume from the
+		// first line inside it that looked like the start of a new top-level
+		// form, so that the forms after it are still checked.
+		if p.curToken.Type == token.EOF && p.resync != nil {
+			p.restore(p.resync)
+		}
 	}
 
 	return &ast.Program{
@@ -45,24 +91,26 @@ func (p *Parser) ParseProgram() *ast.Program {
 }
 
 // Parse Expressions recursively, to allow for nested expressions.
+//
+// When the source is invalid, an error is recorded and a BadExpression is
+// returned in place of the expression.
 func (p *Parser) parseExpression() ast.Expression {
 	switch p.curToken.Type {
 	case token.NUM:
-		float, err := strconv.ParseFloat(p.curToken.Literal, 64)
-
-		if err == nil {
-			tok := p.curToken
-			p.readToken()
-			return &ast.FloatLiteral{
-				Token: tok,
-				Value: float,
-			}
+		tok := p.curToken
+		p.readToken()
+
+		float, err := strconv.ParseFloat(tok.Literal, 64)
+
+		if err != nil {
+			p.addError(tok.Pos, "", "%s is invalid number", tok.Literal)
+			return &ast.BadExpression{Token: tok}
 		}
 
-		errMsg := fmt.Sprintf("%s is invalid number", p.curToken.Literal)
-		p.Errors = append(p.Errors, errMsg)
-		p.readToken()
-		return nil
+		return &ast.FloatLiteral{
+			Token: tok,
+			Value: float,
+		}
 	case token.STRING:
 		string := &ast.StringLiteral{
 			Token: p.curToken,
@@ -78,19 +126,22 @@ func (p *Parser) parseExpression() ast.Expression {
 		return p.parseSExpression()
 	case token.LBRACE:
 		return p.parseDictLiteral()
-	case token.QUOTE:
+	case token.QUOTE, token.BACKQUOTE, token.UNQUOTE, token.UNQUOTE_SPLICING:
 		return p.parseQuoteExpression()
-	case token.EOF:
-		return nil
 	case token.ILLEGAL:
-		p.Errors = append(p.Errors, p.curToken.Literal)
+		tok := p.curToken
+		p.addError(tok.Pos, "", "%s", tok.Literal)
 		p.readToken()
-		return nil
+		return &ast.BadExpression{Token: tok}
+	case token.EOF:
+		p.addError(p.curToken.Pos, "", "unexpected end of input")
+		return &ast.BadExpression{Token: p.curToken}
 	default:
-		errorMessage := fmt.Sprintf("should not reach here:\n\treceived: %+v\n\tpeek: %s", p.curToken, p.peekToken)
-		p.Errors = append(p.Errors, errorMessage)
+		tok := p.curToken
+		p.addError(tok.Pos, fmt.Sprintf("remove the extra '%s'", tok.Literal),
+			"unexpected %s %q", tok.Type, tok.Literal)
 		p.readToken()
-		return nil
+		return &ast.BadExpression{Token: tok}
 	}
 }
 
@@ -98,37 +149,15 @@ func (p *Parser) parseExpression() ast.Expression {
 //
 //	(f a b c)
 func (p *Parser) parseSExpression() ast.Expression {
-	sExpression := &ast.SExpression{}
-
-	p.readToken()
-
-	if p.curToken.Type == token.RPAREN {
-		p.readToken()
-		return sExpression
-	}
+	sExpression := &ast.SExpression{Token: p.curToken}
 
-	sExpression.Fn = p.parseExpression()
+	elements := p.parseElements(sExpression.Token, token.RPAREN)
 
-	if p.curToken.Type == token.RPAREN {
-		p.readToken()
-		return sExpression
-	}
-
-	args := []ast.Expression{}
-
-	for p.curToken.Type != token.RPAREN {
-		if p.curToken.Type == token.EOF {
-			p.Errors = append(
-				p.Errors,
-				"Reached EOF before ')'",
-			)
-			return sExpression
-		}
-		args = append(args, p.parseExpression())
+	if len(elements) > 0 {
+		sExpression.Fn = elements[0]
+		sExpression.Args = elements[1:]
 	}
 
-	p.readToken()
-	sExpression.Args = args
 	return sExpression
 }
 
@@ -136,88 +165,144 @@ func (p *Parser) parseSExpression() ast.Expression {
 //
 //	{ arg1 arg2 arg3 arg4 }
 func (p *Parser) parseDictLiteral() ast.Expression {
-	sExpression := &ast.SExpression{}
+	sExpression := &ast.SExpression{Token: p.curToken}
 	sExpression.Fn = &ast.Identifier{
 		Token: token.Token{
 			Type:    token.IDENT,
 			Literal: "dict",
+			Pos:     p.curToken.Pos,
 		},
 	}
 
+	sExpression.Args = p.parseElements(sExpression.Token, token.RBRACE)
+
+	return sExpression
+}
+
+// Parse the expressions between the opening Token and the closing Token type,
+// leaving the Token after the closing Token as the current Token.
+func (p *Parser) parseElements(open token.Token, closing token.TokenType) []ast.Expression {
+	elements := []ast.Expression{}
+
+	p.open = append(p.open, open)
 	p.readToken()
 
-	if p.curToken.Type == token.RBRACE {
-		p.readToken()
-		return sExpression
+	for p.curToken.Type != closing {
+		switch p.curToken.Type {
+		case token.EOF:
+			p.addError(open.Pos, closingHint(open),
+				"Reached EOF before '%s'", closingLiterals[open.Type])
+			p.open = p.open[:len(p.open)-1]
+			return elements
+		case token.RPAREN, token.RBRACE:
+			// The wrong closing bracket is assumed to close this expression
+			// as well, and is left for an enclosing expression to use.
+			if p.isOpen(p.curToken.Type) {
+				p.addError(p.curToken.Pos, closingHint(open),
+					"expected '%s' but found '%s'", closingLiterals[open.Type], p.curToken.Literal)
+				p.open = p.open[:len(p.open)-1]
+				return elements
+			}
+		}
+
+		elements = append(elements, p.parseExpression())
 	}
 
-	args := []ast.Expression{}
+	// The expression is closed before moving past its closing Token, so that
+	// a new form starting after it is not taken to be inside it.
+	p.open = p.open[:len(p.open)-1]
+	p.readToken()
 
-	for p.curToken.Type != token.RBRACE {
-		if p.curToken.Type == token.EOF {
-			p.Errors = append(
-				p.Errors,
-				"Reached EOF before '}'",
-			)
-			return sExpression
+	return elements
+}
+
+// The closing literal for each Token that opens an expression.
+var closingLiterals = map[token.TokenType]string{
+	token.LPAREN: ")",
+	token.LBRACE: "}",
+}
+
+// The Token type that opens the expression closed by each closing Token type.
+var openingTypes = map[token.TokenType]token.TokenType{
+	token.RPAREN: token.LPAREN,
+	token.RBRACE: token.LBRACE,
+}
+
+// Suggest closing the expression begun by the provided Token.
+func closingHint(open token.Token) string {
+	return fmt.Sprintf("add '%s' to close the '%s' at %s", closingLiterals[open.Type], open.Literal, open.Pos)
+}
+
+// Check whether any expression currently being parsed would be closed by
+// the provided Token type.
+func (p *Parser) isOpen(closing token.TokenType) bool {
+	for _, open := range p.open {
+		if open.Type == openingTypes[closing] {
+			return true
 		}
-		args = append(args, p.parseExpression())
 	}
 
-	p.readToken()
-	sExpression.Args = args
-	return sExpression
+	return false
+}
+
+// The long form names of the expressions produced by each quote prefix.
+var quoteForms = map[token.TokenType]string{
+	token.QUOTE:            "quote",
+	token.BACKQUOTE:        "quasiquote",
+	token.UNQUOTE:          "unquote",
+	token.UNQUOTE_SPLICING: "unquote-splicing",
 }
 
-// Parse an SExpression that begins with a quote.
+// Parse an expression that begins with one of the quote prefixes, producing
+// the equivalent long form expression:
 //
-// Currently this only parses lists of the form '(a b c).
-// This is shorthand for (list a b c).
+//	'x  => (quote x)
+//	`x  => (quasiquote x)
+//	,x  => (unquote x)
+//	,@x => (unquote-splicing x)
 func (p *Parser) parseQuoteExpression() ast.Expression {
-	sExpression := &ast.SExpression{}
+	sExpression := &ast.SExpression{Token: p.curToken}
 
 	p.readToken()
 
-	if p.curToken.Type != token.LPAREN {
-		p.Errors = append(
-			p.Errors,
-			"' not followed by (",
-		)
-		return sExpression
+	switch p.curToken.Type {
+	case token.EOF, token.RPAREN, token.RBRACE:
+		p.addError(sExpression.Pos(), "", "%s not followed by an expression", sExpression.Token.Literal)
+		return &ast.BadExpression{Token: sExpression.Token}
 	}
-	p.readToken()
 
 	sExpression.Fn = &ast.Identifier{
 		Token: token.Token{
 			Type:    token.IDENT,
-			Literal: "list",
+			Literal: quoteForms[sExpression.Token.Type],
+			Pos:     sExpression.Pos(),
 		},
 	}
+	sExpression.Args = []ast.Expression{p.parseExpression()}
 
-	if p.curToken.Type == token.RPAREN {
-		p.readToken()
-		return sExpression
-	}
-
-	args := []ast.Expression{}
+	return sExpression
+}
 
-	for p.curToken.Type != token.RPAREN {
-		if p.curToken.Type == token.EOF {
-			p.Errors = append(
-				p.Errors,
-				"Reached EOF before ')'",
-			)
-			return sExpression
-		}
-		args = append(args, p.parseExpression())
-	}
+// Record a parser error at the given position, with an optional hint for how
+// to fix it.
+func (p *Parser) addError(pos token.Position, hint string, format string, args ...interface{}) {
+	p.addDiagnostic(Error, pos, hint, format, args...)
+}
 
-	p.readToken()
-	sExpression.Args = args
-	return sExpression
+// Record a Diagnostic of the given severity.
+func (p *Parser) addDiagnostic(severity Severity, pos token.Position, hint string, format string, args ...interface{}) {
+	p.Diagnostics = append(p.Diagnostics, Diagnostic{
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Pos:      pos,
+		Hint:     hint,
+	})
 }
 
 // Move to the next Token to parse.
+//
+// Datum comments are handled here so that every caller sees the program as if
+// the commented out expression was never written.
 func (p *Parser) readToken() token.Token {
 	p.curToken = p.peekToken
 
@@ -225,5 +310,60 @@ func (p *Parser) readToken() token.Token {
 		p.peekToken = p.lexer.NextToken()
 	}
 
+	if p.curToken.Type == token.DATUM_COMMENT {
+		p.skipDatumComment()
+	}
+
+	// Remember the first '(' at the start of a line inside a top-level form,
+	// as it is the most likely place for a new form to begin if the current
+	// one turns out to be unclosed.
+	if len(p.open) > 0 && p.resync == nil &&
+		p.curToken.Type == token.LPAREN && p.curToken.Pos.Column == 1 {
+		p.resync = p.save()
+	}
+
 	return p.curToken
 }
+
+// Parse and discard the expression following a '#;' datum comment, leaving
+// the Token after that expression as the current Token.
+func (p *Parser) skipDatumComment() {
+	pos := p.curToken.Pos
+
+	p.readToken()
+
+	switch p.curToken.Type {
+	case token.EOF:
+		p.addDiagnostic(Warning, pos, "remove the '#;'", "#; at end of input has no effect")
+		return
+	case token.RPAREN, token.RBRACE:
+		p.addError(pos, "remove the '#;'", "#; not followed by an expression")
+		return
+	}
+
+	p.parseExpression()
+}
+
+// Take a snapshot of the current parsing position.
+func (p *Parser) save() *parserState {
+	return &parserState{
+		lexer:           *p.lexer,
+		curToken:        p.curToken,
+		peekToken:       p.peekToken,
+		diagnosticCount: len(p.Diagnostics),
+		unclosed:        p.open[len(p.open)-1],
+	}
+}
+
+// Return to a previously saved parsing position inside an unclosed
+// expression. Diagnostics recorded after that point are discarded, as they
+// are replaced by an error for the unclosed expression.
+func (p *Parser) restore(state *parserState) {
+	*p.lexer = state.lexer
+	p.curToken = state.curToken
+	p.peekToken = state.peekToken
+
+	p.Diagnostics = p.Diagnostics[:state.diagnosticCount]
+	p.addError(state.unclosed.Pos, closingHint(state.unclosed),
+		"'%s' is not closed before the next top-level form", state.unclosed.Literal)
+}
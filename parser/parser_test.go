@@ -186,7 +186,17 @@ func TestSExpression(t *testing.T) {
 		},
 		{
 			input:        `'(1 2 3)`,
-			expected:     `(list 1 2 3)`,
+			expected:     `(quote (1 2 3))`,
+			expectedType: "sExpression",
+		},
+		{
+			input:        `'a`,
+			expected:     `(quote a)`,
+			expectedType: "sExpression",
+		},
+		{
+			input:        "`(a ,b ,@(c d))",
+			expected:     `(quasiquote (a (unquote b) (unquote-splicing (c d))))`,
 			expectedType: "sExpression",
 		},
 	}
@@ -216,6 +226,40 @@ func TestParseMultipleExpressions(t *testing.T) {
 	}
 }
 
+// Ensure datum comments remove exactly the expression that follows them,
+// wherever they appear.
+func TestDatumComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#;(def a 1) (def b 2)", "(def b 2)"},
+		{"(list 1 #;2 3)", "(list 1 3)"},
+		{"(list 1 #;(+ 2 (* 3 4)))", "(list 1)"},
+		{"{a #;b c}", "(dict a c)"},
+		{"#; #; 1 2 3", "3"},
+		{"1 #;", "1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program: expected=%q got=%q", tt.expected, program.String())
+		}
+	}
+
+	l := lexer.New("(list 1 #;)")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 1 {
+		t.Fatalf("expected 1 error for dangling datum comment, got=%v", p.Errors())
+	}
+}
+
 func runParserTests(t *testing.T, tests []parserTest) {
 	t.Helper()
 
@@ -290,3 +334,106 @@ func testIdentifier(t *testing.T, expr ast.Expression, expected string) {
 		t.Errorf("expected=%s, got=%s", expected, identifier.String())
 	}
 }
+
+// Ensure parser errors report where in the source they occurred.
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(+ 1\n  (list 2", "test.lsp:2:3: error: Reached EOF before ')' (hint: add ')' to close the '(' at test.lsp:2:3)"},
+		{"\n  {1 2", "test.lsp:2:3: error: Reached EOF before '}' (hint: add '}' to close the '{' at test.lsp:2:3)"},
+		{"(list {1 2)", "test.lsp:1:11: error: expected '}' but found ')' (hint: add '}' to close the '{' at test.lsp:1:7)"},
+		{"(print \"unterminated)", "test.lsp:1:8: error: unterminated string: \"unterminated)"},
+		{"(+ 1 2))", "test.lsp:1:8: error: unexpected rparen \")\" (hint: remove the extra ')')"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.NewWithFile("test.lsp", tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Fatalf("expected parser errors for %q, got none", tt.input)
+		}
+
+		if p.Errors()[0].String() != tt.expected {
+			t.Errorf("wrong error: expected=%q got=%q", tt.expected, p.Errors()[0])
+		}
+	}
+}
+
+// Ensure the parser reports every error in the source, leaves invalid forms
+// out of the program, and resumes after an unclosed form at the next line
+// that starts a top-level form.
+func TestParserRecovery(t *testing.T) {
+	input := `(def a 1.2.3)
+(def b (+ 1
+(def c 3)
+(print "ok"))
+(def d {1 2)
+(def e 5)`
+
+	l := lexer.NewWithFile("test.lsp", input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"test.lsp:1:8: error: 1.2.3 is invalid number",
+		"test.lsp:2:8: error: '(' is not closed before the next top-level form (hint: add ')' to close the '(' at test.lsp:2:8)",
+		"test.lsp:4:13: error: unexpected rparen \")\" (hint: remove the extra ')')",
+		"test.lsp:5:12: error: expected '}' but found ')' (hint: add '}' to close the '{' at test.lsp:5:8)",
+	}
+
+	errors := p.Errors()
+
+	if len(errors) != len(expectedErrors) {
+		t.Fatalf("wrong number of errors: expected=%d got=%d(%v)", len(expectedErrors), len(errors), errors)
+	}
+
+	for i, expected := range expectedErrors {
+		if errors[i].String() != expected {
+			t.Errorf("wrong error %d: expected=%q got=%q", i, expected, errors[i])
+		}
+	}
+
+	expected := `(def c 3)(print ok)(def e 5)`
+
+	if program.String() != expected {
+		t.Errorf("wrong program: expected=%q got=%q", expected, program.String())
+	}
+}
+
+// Ensure an unclosed form is reported at its own opening bracket, rather than
+// at a balanced expression inside it that ends just before the next form.
+func TestParserRecoveryAfterClosedChild(t *testing.T) {
+	l := lexer.NewWithFile("test.lsp", "(def a (+ 1 2)\n(def b 2)")
+	p := New(l)
+	program := p.ParseProgram()
+
+	expected := "test.lsp:1:1: error: '(' is not closed before the next top-level form (hint: add ')' to close the '(' at test.lsp:1:1)"
+
+	if len(p.Errors()) != 1 || p.Errors()[0].String() != expected {
+		t.Fatalf("wrong errors: expected=%q got=%v", expected, p.Errors())
+	}
+
+	if program.String() != "(def b 2)" {
+		t.Errorf("wrong program: expected=%q got=%q", "(def b 2)", program.String())
+	}
+}
+
+// Ensure problems that don't prevent the program from running are reported
+// as warnings rather than errors.
+func TestParserWarnings(t *testing.T) {
+	l := lexer.New("(+ 1 2) #;")
+	p := New(l)
+	p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("expected no errors, got=%v", p.Errors())
+	}
+
+	if len(p.Diagnostics) != 1 || p.Diagnostics[0].Severity != Warning {
+		t.Fatalf("expected 1 warning, got=%v", p.Diagnostics)
+	}
+}
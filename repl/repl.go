@@ -7,6 +7,7 @@ import (
 	"io"
 	"lisp/compiler"
 	"lisp/evaluator"
+	"lisp/expander"
 	"lisp/lexer"
 	"lisp/object"
 	"lisp/parser"
@@ -20,6 +21,7 @@ const PROMPT = ">>> "
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment(nil)
+	exp := expander.New()
 
 	for {
 		fmt.Fprintf(out, PROMPT)
@@ -37,16 +39,27 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 		program := p.ParseProgram()
 
-		if len(p.Errors) > 0 {
-			for _, err := range p.Errors {
-				fmt.Fprintf(out, err)
-			}
+		for _, d := range p.Diagnostics {
+			fmt.Fprintln(out, d)
+		}
 
-			return
+		if len(p.Errors()) > 0 {
+			continue
+		}
+
+		expanded, err := exp.Expand(program)
+
+		if err != nil {
+			fmt.Fprintf(out, "expander error: %s\n", err)
+			continue
 		}
 
-		result := evaluator.Evaluate(program, env)
+		result := evaluator.Evaluate(expanded, env)
 		fmt.Fprintln(out, result.Inspect())
+
+		if err, ok := result.(*object.ErrorObject); ok {
+			fmt.Fprint(out, err.Trace)
+		}
 	}
 }
 
@@ -55,8 +68,9 @@ func Start(in io.Reader, out io.Writer) {
 func StartCompiled(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	constants := []object.Object{}
-	globals := make([]object.Object, vm.GlobalSize)
+	globals := []object.Object{}
 	symbolTable := compiler.NewSymbolTable()
+	exp := expander.New()
 
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
@@ -78,16 +92,23 @@ func StartCompiled(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 		program := p.ParseProgram()
 
-		if len(p.Errors) > 0 {
-			for _, err := range p.Errors {
-				fmt.Fprintf(out, err)
-			}
+		for _, d := range p.Diagnostics {
+			fmt.Fprintln(out, d)
+		}
 
-			return
+		if len(p.Errors()) > 0 {
+			continue
+		}
+
+		expanded, err := exp.Expand(program)
+
+		if err != nil {
+			fmt.Fprintf(out, "expander error: %s\n", err)
+			continue
 		}
 
 		c := compiler.NewWithState(constants, symbolTable)
-		err := c.Compile(program)
+		err = c.Compile(expanded)
 
 		if err != nil {
 			fmt.Fprintf(out, "compiler error: %s\n", err)
@@ -100,8 +121,17 @@ func StartCompiled(in io.Reader, out io.Writer) {
 		v := vm.NewWithState(c.Bytecode(), globals)
 		err = v.Run()
 
+		// preserve globals between commands, including those defined before
+		// an error
+		globals = v.Globals()
+
 		if err != nil {
 			fmt.Fprintf(out, "vm error: %s\n", err)
+
+			if runtimeErr, ok := err.(*vm.RuntimeError); ok {
+				fmt.Fprint(out, runtimeErr.Trace)
+			}
+
 			continue
 		}
 
@@ -2,6 +2,8 @@
 // when tokenising the input string.
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -17,10 +19,41 @@ const (
 	LBRACE = "lbrace"
 	RBRACE = "rbrace"
 
-	QUOTE = "quote"
+	QUOTE            = "quote"
+	BACKQUOTE        = "backquote"
+	UNQUOTE          = "unquote"
+	UNQUOTE_SPLICING = "unquote_splicing"
+
+	// DATUM_COMMENT is the '#;' prefix, which comments out the whole
+	// expression that follows it.
+	DATUM_COMMENT = "datum_comment"
 )
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // Where the first character of the token is in the source.
+}
+
+// Position is a location in the source code. Lines and columns are counted
+// from 1, so the zero value represents an unknown position.
+type Position struct {
+	File   string // The name of the source file, empty when not read from a file.
+	Line   int
+	Column int
+}
+
+// Return true when the Position refers to an actual location in the source.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// Return the Position in the form file:line:column, omitting the file when
+// no file name is known.
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
@@ -0,0 +1,148 @@
+package vm
+
+import (
+	"lisp/object"
+	"lisp/token"
+)
+
+// CallFrame is a function running on the VM, as shown in stack traces.
+//
+// The bodies of expressions such as let run in Frames of their own, for
+// inline lambdas, which are part of the CallFrame of the function the
+// expression is in.
+type CallFrame struct {
+	Name string         // the name of the function, empty for an anonymous lambda
+	Pos  token.Position // the position in the source the function has reached
+	vm   *VM
+	// The Frame of the function, followed by the Frames of the inline
+	// lambdas running in it, innermost last.
+	frames []*Frame
+}
+
+// Variable is a variable visible in a CallFrame, along with its value.
+type Variable struct {
+	Name  string
+	Value object.Object
+}
+
+// Set a function to call before executing an instruction compiled from a
+// different position in the source than the instruction before it, or that
+// starts a call, for debuggers. The state of the VM can be inspected while
+// it runs.
+//
+// Execution stops when the function returns an error, which is returned by
+// Run and is not caught by try expressions.
+func (vm *VM) SetHook(hook func() error) {
+	vm.hook = hook
+}
+
+// Call the hook when the instruction about to be executed by the current
+// Frame is at a new position, stopping execution if it returns an error.
+func (vm *VM) callHook() error {
+	frame := vm.currentFrame()
+	pos := frame.Closure.Lambda.Lines.Lookup(frame.ip)
+
+	if frame == vm.hookFrame && pos == vm.hookPos && frame.ip != 0 {
+		return nil
+	}
+
+	vm.hookFrame, vm.hookPos = frame, pos
+
+	err := vm.hook()
+
+	if err != nil {
+		return vm.halt(err)
+	}
+
+	return nil
+}
+
+// Return the functions running on the VM, innermost first.
+func (vm *VM) CallFrames() []CallFrame {
+	return vm.callFrames(0)
+}
+
+// Return the functions running in the Frames from the provided index of the
+// frame stack upwards, innermost first, each at the position of the
+// instruction it is executing.
+//
+// The Frames of inline lambdas are added to the CallFrame below them, which
+// is given the position they reached.
+func (vm *VM) callFrames(from int) []CallFrame {
+	callFrames := []CallFrame{}
+	inline := []*Frame{}
+	var inner token.Position
+
+	for i := vm.framesIndex - 1; i >= from; i-- {
+		frame := vm.frames[i]
+		pos := frame.Closure.Lambda.Lines.Lookup(frame.ip)
+
+		if inner.IsValid() {
+			pos, inner = inner, token.Position{}
+		}
+
+		lambda := frame.Closure.Lambda
+
+		if frame.replaced != nil {
+			lambda = frame.replaced
+		}
+
+		if lambda.Inline {
+			inner = pos
+			inline = append([]*Frame{frame}, inline...)
+			continue
+		}
+
+		callFrames = append(callFrames, CallFrame{
+			Name:   lambda.Name,
+			Pos:    pos,
+			vm:     vm,
+			frames: append([]*Frame{frame}, inline...),
+		})
+		inline = []*Frame{}
+	}
+
+	return callFrames
+}
+
+// Return the local variables of the function, including those bound by the
+// expressions running in it, in the order they were defined. Only the
+// innermost variable of each name is included, and variables that have not
+// been set yet are left out.
+func (cf CallFrame) Locals() []Variable {
+	locals := []Variable{}
+	index := map[string]int{}
+
+	for _, frame := range cf.frames {
+		for i, name := range frame.Closure.Lambda.LocalNames {
+			value := cf.vm.stack[frame.basePointer+i]
+
+			if name == "" || value == nil {
+				continue
+			}
+
+			if j, ok := index[name]; ok {
+				locals[j].Value = value
+				continue
+			}
+
+			index[name] = len(locals)
+			locals = append(locals, Variable{Name: name, Value: value})
+		}
+	}
+
+	return locals
+}
+
+// Return the variables captured from the enclosing scope by the Closure of
+// the function.
+func (cf CallFrame) Free() []Variable {
+	closure := cf.frames[0].Closure
+	free := []Variable{}
+
+	for i, name := range closure.Lambda.FreeNames {
+		free = append(free, Variable{Name: name, Value: closure.Free[i].Get()})
+	}
+
+	return free
+}
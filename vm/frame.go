@@ -15,6 +15,12 @@ type Frame struct {
 	// Used for interacting with local bindings, which are stored
 	// on top of the stack.
 	basePointer int
+	// Which parameters were not passed an argument, by local slot. nil when
+	// every parameter was passed one.
+	unbound []bool
+	// The lambda whose Frame was taken over by a tail call to the inline
+	// lambda being executed, which is shown in its place in stack traces.
+	replaced *object.CompiledLambda
 }
 
 // Create a new VM with the provided compiled lambda.
@@ -30,3 +36,19 @@ func NewFrame(closure *object.Closure, basePointer int) *Frame {
 func (f *Frame) Instructions() code.Instructions {
 	return f.Closure.Lambda.Instructions
 }
+
+// Record that the parameter in the provided local slot was not passed an
+// argument, out of the total number of parameters.
+func (f *Frame) setUnbound(index int, count int) {
+	if f.unbound == nil {
+		f.unbound = make([]bool, count)
+	}
+
+	f.unbound[index] = true
+}
+
+// Report whether the parameter in the provided local slot was not passed an
+// argument.
+func (f *Frame) isUnbound(index int) bool {
+	return f.unbound != nil && f.unbound[index]
+}
@@ -3,16 +3,30 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"lisp/code"
 	"lisp/compiler"
 	"lisp/object"
+	"lisp/token"
+	"slices"
 )
 
 const (
-	StackSize  = 2048
-	GlobalSize = 65536
-	MaxFrames  = 1024
+	// The most slots the stack grows to, unless a StackSize limit is given.
+	StackSize = 2048
+	// The most Frames the frame stack grows to, unless a CallDepth limit is
+	// given.
+	MaxFrames = 1024
+)
+
+// The number of slots the stack and the frame stack start with, which are
+// enough for most short programs. They are doubled each time they are
+// outgrown.
+const (
+	initialStackSize = 64
+	initialFrames    = 16
 )
 
 // Global references to true, false, and null resolve to a single object for
@@ -25,59 +39,251 @@ var Null = object.NULL
 type VM struct {
 	// Slice of constant values that are referenced in the bytecode instructions
 	constants []object.Object
-	// The active stack used during execution
+	// The active stack used during execution, which grows as it is needed
 	stack []object.Object
+	// The most slots the stack can grow to
+	maxStack int
 	// Pointer next open space on the stack
 	sp int
 	// Stack of global objects in the current program
 	globals []object.Object
-	// Stack of frames for function execution
+	// The name of each global, by index, for errors
+	globalNames []string
+	// Stack of frames for function execution, which grows as it is needed
 	frames []*Frame
+	// The most Frames the frame stack can grow to
+	maxFrames int
 	// Pointer to the next open place on the frames stack
 	framesIndex int
+	// Upvalues that still refer to a slot on the stack, ordered by slot
+	openUpvalues []*object.Upvalue
+	// Stack of the try expressions currently executing, innermost last
+	handlers []handler
+	// The value caught by the most recently handled error
+	caught object.Object
+	// The function called as execution reaches each new position, if any
+	hook func() error
+	// The Frame and position the hook was last called at
+	hookFrame *Frame
+	hookPos   token.Position
+	// The error returned by the hook, or from exceeding a limit, which stops
+	// execution
+	halted error
+	// The limits on the resources used by the program
+	limits object.Limits
+	// Counts the resources used by the program while it runs, if it is
+	// limited by more than the size of the stack and frame stack
+	meter *object.Meter
+}
+
+// Option configures a VM as it is created.
+type Option func(*VM)
+
+// Run the program within the provided Limits. The stack and the frame stack
+// grow to at most StackSize and MaxFrames slots, unless StackSize and
+// CallDepth limit them. The bodies of expressions such as let run in Frames of their
+// own, so they count towards the CallDepth.
+func WithLimits(limits object.Limits) Option {
+	return func(vm *VM) {
+		vm.limits = limits
+	}
+}
+
+// handler records where execution continues when an error occurs in the body
+// of a try expression, and the state of the VM to return to.
+type handler struct {
+	// The number of frames on the frame stack when the try began
+	framesIndex int
+	// The stack pointer when the try began
+	sp int
+	// The instruction index to continue at in the frame of the try
+	pos int
+}
+
+// ThrownError is the error returned by Run when a value thrown by a throw
+// expression is not caught.
+type ThrownError struct {
+	// The value that was thrown.
+	Value object.Object
+}
+
+// Return the message describing the uncaught value.
+func (e *ThrownError) Error() string {
+	return object.ThrowError(e.Value).Error
+}
+
+// RuntimeError is the error returned by Run when an error is not handled,
+// along with the functions that were running when it occurred.
+type RuntimeError struct {
+	Err   error
+	Trace object.StackTrace
+}
+
+// Return the message of the error, without the stack trace.
+func (e *RuntimeError) Error() string {
+	return e.Err.Error()
 }
 
-// Create a new VM instance from the provided bytecode.
-func New(bytecode *compiler.Bytecode) *VM {
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Create a new VM instance from the provided bytecode, configured by the
+// provided options.
+func New(bytecode *compiler.Bytecode, options ...Option) *VM {
 	// Represent the entire program as a Closure so that each level of
 	// execution operate the same.
 	mainLambda := &object.CompiledLambda{
 		Instructions: bytecode.Instructions,
+		Name:         object.MainFunctionName,
+		Lines:        bytecode.Lines,
 	}
 	mainClosure := &object.Closure{Lambda: mainLambda}
 
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
-	frames[0] = mainFrame
-
-	return &VM{
+	vm := &VM{
 		constants:   bytecode.Constants,
-		stack:       make([]object.Object, StackSize),
 		sp:          0,
-		globals:     make([]object.Object, GlobalSize),
-		frames:      frames,
+		globals:     make([]object.Object, len(bytecode.GlobalNames)),
+		globalNames: bytecode.GlobalNames,
+		maxStack:    StackSize,
+		maxFrames:   MaxFrames,
 		framesIndex: 1,
 	}
+
+	for _, option := range options {
+		option(vm)
+	}
+
+	if vm.limits.StackSize > 0 {
+		vm.maxStack = vm.limits.StackSize
+	}
+
+	// The Frame of the main program is not a call.
+	if vm.limits.CallDepth > 0 {
+		vm.maxFrames = vm.limits.CallDepth + 1
+	}
+
+	vm.stack = make([]object.Object, min(initialStackSize, vm.maxStack))
+	vm.frames = make([]*Frame, 1, min(initialFrames, vm.maxFrames))
+	vm.frames[0] = mainFrame
+
+	return vm
 }
 
 // Create a new VM instance from the provided bytecode, along with predefined
 // globals so that state can be maintained between VM instances.
-func NewWithState(bytecode *compiler.Bytecode, globals []object.Object) *VM {
-	vm := New(bytecode)
+//
+// The globals are extended to those the bytecode defines, in place when they
+// have the capacity, so that they are only shared with the VM if they do not
+// need to grow. The globals of the VM are returned by Globals.
+func NewWithState(bytecode *compiler.Bytecode, globals []object.Object, options ...Option) *VM {
+	vm := New(bytecode, options...)
+
+	if len(globals) < len(vm.globals) {
+		globals = append(globals, vm.globals[len(globals):]...)
+	}
+
 	vm.globals = globals
 
 	return vm
 }
 
+// Return the globals of the VM, for a later VM to continue from.
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
 // Execute the bytecode instructions, using a fetch, decode, execute cycle.
 //
 // The top Frame of the VM represents the currently executing state of the VM,
 // including executing instructions in the form of a Closure, the instruction
 // pointer, and the pointer to where the current Frame execution began.
 //
-// Returns an error if something in execution fails.
+// Errors that occur in the body of a try expression are handled by unwinding
+// the frame stack and the stack to where the try began, then continuing at
+// its handler.
+//
+// Returns an error if something in execution fails and is not handled, as a
+// RuntimeError with the stack trace of where it occurred.
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// Execute the bytecode instructions as Run does, in the provided context.
+// Exceeding the Limits of the VM, or the context being done, stops execution
+// with a RuntimeError wrapping a LimitError.
+func (vm *VM) RunContext(ctx context.Context) error {
+	// A program stopped by an error runs again from its start.
+	if vm.halted != nil {
+		vm.closeUpvalues(0)
+		vm.frames[0] = NewFrame(vm.frames[0].Closure, 0)
+		vm.framesIndex = 1
+		vm.sp = 0
+		vm.handlers = vm.handlers[:0]
+	}
+
+	vm.start(ctx)
+	return vm.execute(1, 0)
+}
+
+// Start counting the resources used by the program from now, in the
+// provided context, if it is limited by more than the size of the stack and
+// frame stack. A VM stopped by an earlier error can run again, with the
+// resources it used before forgotten.
+func (vm *VM) start(ctx context.Context) {
+	vm.halted = nil
+	vm.meter = nil
+
+	if ctx.Done() != nil || vm.limits.Steps > 0 || vm.limits.Allocations > 0 || vm.limits.Timeout > 0 {
+		vm.meter = object.NewMeter(ctx, vm.limits)
+	}
+}
+
+// Execute instructions until the program completes, or until the Frame at
+// the provided depth of the frame stack returns. Errors are handled by the
+// try expressions above the provided number of handlers, those below belong
+// to the code that is waiting for the Frame to return.
+//
+// An error that is not handled is returned with the stack trace of the
+// Frames from the one at the provided depth upwards.
+func (vm *VM) execute(depth int, handlers int) error {
+	for {
+		err := vm.run(depth)
+
+		if err == nil {
+			return nil
+		}
+
+		if len(vm.handlers) == handlers || vm.halted != nil {
+			return vm.traceError(err, depth-1)
+		}
+
+		h := vm.handlers[len(vm.handlers)-1]
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		vm.closeUpvalues(h.sp)
+		vm.framesIndex = h.framesIndex
+		vm.sp = h.sp
+
+		var thrown *ThrownError
+
+		if errors.As(err, &thrown) {
+			vm.caught = thrown.Value
+		} else {
+			vm.caught = &object.Exception{Message: err.Error()}
+		}
+
+		// Decrement the new position so that we arrive at the target
+		// position when the cycle increments the instruction pointer.
+		vm.currentFrame().ip = h.pos - 1
+	}
+}
+
+// Execute instructions from the current position until the program
+// completes, the Frame at the provided depth returns, or an error occurs.
+func (vm *VM) run(depth int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
@@ -85,6 +291,23 @@ func (vm *VM) Run() error {
 	// Fetch
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
+
+		if vm.hook != nil {
+			err := vm.callHook()
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if vm.meter != nil {
+			err := vm.meter.Step()
+
+			if err != nil {
+				return vm.halt(err)
+			}
+		}
+
 		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
 		op = code.Opcode(ins[ip])
@@ -141,6 +364,44 @@ func (vm *VM) Run() error {
 				// position when the cycle increments the instruction pointer.
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpWhenFalseOrPop, code.OpJumpWhenTrueOrPop:
+			// Jump to the provided instruction position, keeping the object
+			// on top of the stack, if its truthiness matches the Opcode.
+			// Otherwise remove the object and move to the next instruction.
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			jumpWhen := op == code.OpJumpWhenTrueOrPop
+
+			if isTruthy(vm.StackTop()) == jumpWhen {
+				// Decrement the new position so that we arrive at the target
+				// position when the cycle increments the instruction pointer.
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+		case code.OpJumpWhenEqual:
+			// Compare the object on top of the stack with the constant at the
+			// provided index. When they are equal, remove the object and jump
+			// to the provided instruction position.
+			index := code.ReadUint16(ins[ip+1:])
+			pos := int(code.ReadUint16(ins[ip+3:]))
+			vm.currentFrame().ip += 4
+
+			if object.Equal(vm.StackTop(), vm.constants[index]) {
+				vm.pop()
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpJumpWhenBound:
+			// Jump to the provided instruction position if the parameter in
+			// the provided local slot was passed an argument.
+			index := int(ins[ip+1])
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			vm.currentFrame().ip += 3
+
+			if !vm.currentFrame().isUnbound(index) {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpNull:
 			// Place the value of 'null' of top of the stack.
 			err := vm.push(Null)
@@ -154,12 +415,29 @@ func (vm *VM) Run() error {
 			index := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
+			vm.globals[index] = vm.stack[vm.sp-1]
+		case code.OpAssignGlobal:
+			// Change the value of a global that has been defined to the
+			// object on top of the stack, without removing it from the stack.
+			index := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if vm.globals[index] == nil {
+				return fmt.Errorf("cannot set! undefined variable %s", vm.globalName(int(index)))
+			}
+
 			vm.globals[index] = vm.stack[vm.sp-1]
 		case code.OpGetGlobal:
 			// Place the requested global value onto the top of the stack.
 			index := code.ReadUint16(ins[ip+1:])
 			vm.currentFrame().ip += 2
 
+			// Globals are declared before the program runs, so they can be
+			// used before the expression that defines them is executed.
+			if vm.globals[index] == nil {
+				return fmt.Errorf("undefined variable %s", vm.globalName(int(index)))
+			}
+
 			err := vm.push(vm.globals[index])
 
 			if err != nil {
@@ -179,12 +457,20 @@ func (vm *VM) Run() error {
 			index := int(ins[ip+1])
 			vm.currentFrame().ip += 1
 
-			basePointer := vm.currentFrame().basePointer
+			frame := vm.currentFrame()
 
 			// Local values are retrieved from the 'hole' in the stack
 			// that's reserved for locals, which sits just above the
 			// currently executing Closure.
-			err := vm.push(vm.stack[basePointer+index])
+			value := vm.stack[frame.basePointer+index]
+
+			// The names bound by a letrec are unset until their values
+			// have been evaluated.
+			if value == nil {
+				return fmt.Errorf("undefined variable %s", frame.Closure.Lambda.LocalNames[index])
+			}
+
+			err := vm.push(value)
 
 			if err != nil {
 				return err
@@ -200,7 +486,7 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
-		case code.OpCall:
+		case code.OpCall, code.OpTailCall:
 			// Execute the function at the top of the stack, using the arguments
 			// placed on top of it.
 			argCount := int(ins[ip+1])
@@ -212,44 +498,60 @@ func (vm *VM) Run() error {
 			// the stack.
 			switch fn := vm.stack[vm.sp-argCount-1].(type) {
 			case *object.Closure:
-				// When executing a Closure, a new frame is created and pushed
-				// onto the frame stack, the next loop through Run will use the
-				// instructions and values of the new Frame, which will be
-				// popped off the frame stack when execution completes.
-				if argCount != fn.Lambda.ParameterCount {
-					return fmt.Errorf(
-						"wrong number of arguments: expected=%d got=%d",
-						fn.Lambda.ParameterCount, argCount,
-					)
+				if op == code.OpTailCall {
+					err := vm.tailCall(fn, argCount)
+
+					if err != nil {
+						return err
+					}
+
+					continue
 				}
 
-				frame := NewFrame(fn, vm.sp-argCount)
-
-				vm.pushFrame(frame)
-				// Reserve space on the stack for local bindings:
-				//
-				// The space between frame.basePointer (the current stack pointer)
-				// and fn.LocalsCount reserves fn.LocalsCount number of spaces for
-				// paramaters and local bindings, since parameters are a special
-				// case of local bindings. This allows the stack beyond this point
-				// to be used as normal in instruction execution.
-				vm.sp = frame.basePointer + fn.Lambda.LocalsCount
+				err := vm.callClosure(fn, argCount)
+
+				if err != nil {
+					return err
+				}
 			case *object.FunctionObject:
 				// When executing a builtin function, call the inner function
 				// written in go and push the resulting value onto the stack.
+				// Builtins call functions passed to them through the VM.
 				args := vm.stack[vm.sp-argCount : vm.sp]
 
-				result := fn.Fn(args...)
+				result := fn.Fn(vm, args...)
+
+				if vm.halted != nil {
+					return vm.halted
+				}
 
-				if result.Type() == object.ERROR_OBJ {
-					errObj, _ := result.(*object.ErrorObject)
+				err := vm.allocate(object.Allocated(result, args))
 
-					return fmt.Errorf("%s", errObj.Error)
+				if err != nil {
+					return err
+				}
+
+				if errObj, ok := result.(*object.ErrorObject); ok {
+					var err error = fmt.Errorf("%s", errObj.Error)
+
+					// Values thrown by a closure the builtin called carry on
+					// being thrown.
+					if errObj.Value != nil {
+						err = &ThrownError{Value: errObj.Value}
+					}
+
+					// The trace of the Frames the closure ran in is continued
+					// by the Frames below.
+					if errObj.Trace != nil {
+						err = &RuntimeError{Err: err, Trace: errObj.Trace}
+					}
+
+					return err
 				}
 
 				vm.sp = vm.sp - argCount - 1
 
-				err := vm.push(result)
+				err = vm.push(result)
 
 				if err != nil {
 					return err
@@ -264,6 +566,7 @@ func (vm *VM) Run() error {
 			returnValue := vm.pop()
 
 			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
 			vm.sp = frame.basePointer - 1
 
 			err := vm.push(returnValue)
@@ -271,20 +574,30 @@ func (vm *VM) Run() error {
 			if err != nil {
 				return err
 			}
+
+			// The Frame that was being waited for has returned.
+			if vm.framesIndex < depth {
+				return nil
+			}
 		case code.OpEmptyList:
 			// Place an empty list object on top of the stack.
-			err := vm.push(&object.List{})
+			err := vm.allocate(1)
+
+			if err != nil {
+				return err
+			}
+
+			err = vm.push(&object.List{})
 
 			if err != nil {
 				return err
 			}
 		case code.OpClosure:
 			// Create a Closure object from the CompiledLambda at the provided
-			// index and the free variables from the top of the stack, then
-			// place the new Closure on top of the stack.
+			// index, capturing the variables it describes from the current
+			// Frame, then place the new Closure on top of the stack.
 			index := code.ReadUint16(ins[ip+1:])
-			freeCount := int(ins[ip+3])
-			vm.currentFrame().ip += 3
+			vm.currentFrame().ip += 2
 
 			constant := vm.constants[index]
 			lambda, ok := constant.(*object.CompiledLambda)
@@ -293,15 +606,17 @@ func (vm *VM) Run() error {
 				return fmt.Errorf("object not lambda: %+v", constant)
 			}
 
-			freeVariables := make([]object.Object, freeCount)
+			// Inline lambdas are run as they are created, in place of an
+			// expression, so they are not counted.
+			if !lambda.Inline {
+				err := vm.allocate(1)
 
-			for i := 0; i < freeCount; i++ {
-				freeVariables[i] = vm.stack[vm.sp-freeCount+i]
+				if err != nil {
+					return err
+				}
 			}
 
-			vm.sp -= freeCount
-
-			err := vm.push(&object.Closure{Lambda: lambda, Free: freeVariables})
+			err := vm.push(&object.Closure{Lambda: lambda, Free: vm.capture(lambda.Captures)})
 
 			if err != nil {
 				return err
@@ -312,7 +627,42 @@ func (vm *VM) Run() error {
 			index := int(ins[ip+1])
 			vm.currentFrame().ip += 1
 
-			err := vm.push(vm.currentFrame().Closure.Free[index])
+			closure := vm.currentFrame().Closure
+			value := closure.Free[index].Get()
+
+			if value == nil {
+				return fmt.Errorf("undefined variable %s", closure.Lambda.FreeNames[index])
+			}
+
+			err := vm.push(value)
+
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			// Set the free variable at the provided index to the object on top
+			// of the stack without removing the object from the stack.
+			index := int(ins[ip+1])
+			vm.currentFrame().ip += 1
+
+			vm.currentFrame().Closure.Free[index].Set(vm.stack[vm.sp-1])
+		case code.OpTry:
+			// Record the state of the VM so that an error before the matching
+			// OpEndTry continues at the provided instruction position.
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			vm.handlers = append(vm.handlers, handler{framesIndex: vm.framesIndex, sp: vm.sp, pos: pos})
+		case code.OpEndTry:
+			// Stop handling errors with the innermost try expression.
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		case code.OpThrow:
+			// Remove the object on top of the stack and throw it.
+			return &ThrownError{Value: vm.pop()}
+		case code.OpCaught:
+			// Place the value caught by the most recently handled error on top
+			// of the stack.
+			err := vm.push(vm.caught)
 
 			if err != nil {
 				return err
@@ -333,6 +683,340 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// Call the Closure on the stack below the provided number of arguments,
+// placed on top of it, by pushing a new Frame onto the frame stack. The next
+// loop through run will use the instructions and values of the new Frame,
+// which will be popped off the frame stack when execution completes.
+func (vm *VM) callClosure(fn *object.Closure, argCount int) error {
+	frame := NewFrame(fn, vm.sp-argCount)
+
+	err := vm.bindArguments(frame, fn.Lambda.Signature, argCount)
+
+	if err != nil {
+		return err
+	}
+
+	err = vm.reserve(frame.basePointer + fn.Lambda.LocalsCount)
+
+	if err != nil {
+		return err
+	}
+
+	err = vm.pushFrame(frame)
+
+	if err != nil {
+		return err
+	}
+
+	vm.clearLocals(frame, fn)
+	// Reserve space on the stack for local bindings:
+	//
+	// The space between frame.basePointer (the current stack pointer)
+	// and fn.LocalsCount reserves fn.LocalsCount number of spaces for
+	// paramaters and local bindings, since parameters are a special
+	// case of local bindings. This allows the stack beyond this point
+	// to be used as normal in instruction execution.
+	vm.sp = frame.basePointer + fn.Lambda.LocalsCount
+
+	return nil
+}
+
+// Call the provided function with the arguments and return its result, for
+// builtins that take functions as arguments.
+//
+// A Closure is run in a Frame of its own until it returns, with the errors
+// in it handled only by the try expressions inside it. An error that is not
+// handled is returned as an error object, with the stack trace of the Frames
+// it left, and the VM is left as it was before the call.
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	// A VM stopped by an error is no longer running a program, so the call
+	// is made from outside and starts with its resources unused.
+	if vm.halted != nil {
+		vm.start(context.Background())
+	}
+
+	closure, ok := fn.(*object.Closure)
+
+	if !ok {
+		if builtin, ok := fn.(*object.FunctionObject); ok {
+			result := builtin.Fn(vm, args...)
+
+			err := vm.allocate(object.Allocated(result, args))
+
+			if err != nil {
+				return &object.ErrorObject{Error: err.Error(), Limit: err.(*object.LimitError)}
+			}
+
+			return result
+		}
+
+		return &object.ErrorObject{Error: "calling non-function"}
+	}
+
+	sp, framesIndex, handlers := vm.sp, vm.framesIndex, len(vm.handlers)
+
+	err := vm.push(closure)
+
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+
+	if err == nil {
+		err = vm.callClosure(closure, len(args))
+	}
+
+	if err == nil {
+		err = vm.execute(vm.framesIndex, handlers)
+	}
+
+	if err != nil {
+		vm.closeUpvalues(sp)
+		vm.sp = sp
+		vm.framesIndex = framesIndex
+		vm.handlers = vm.handlers[:handlers]
+
+		var errObj *object.ErrorObject
+		var thrown *ThrownError
+
+		if errors.As(err, &thrown) {
+			errObj = object.ThrowError(thrown.Value)
+		} else {
+			errObj = &object.ErrorObject{Error: err.Error()}
+		}
+
+		var runtimeErr *RuntimeError
+
+		if errors.As(err, &runtimeErr) {
+			errObj.Trace = runtimeErr.Trace
+		}
+
+		var limitErr *object.LimitError
+
+		if errors.As(err, &limitErr) {
+			errObj.Limit = limitErr
+		}
+
+		return errObj
+	}
+
+	return vm.pop()
+}
+
+// Call the provided function as Call does, in the provided context and
+// within the Limits of the VM, for functions called from outside a program.
+func (vm *VM) CallContext(ctx context.Context, fn object.Object, args ...object.Object) object.Object {
+	vm.start(ctx)
+	return vm.Call(fn, args...)
+}
+
+// Call the provided Closure in place of the Closure of the current Frame,
+// whose result is the result of the call, so that tail calls do not grow the
+// frame stack.
+//
+// The Closure and its arguments are moved down to where the current Closure
+// and its locals were, after closing any Upvalues that refer to those locals.
+// The Frame is only given to the new Closure once its arguments are bound,
+// so that an error binding them is traced to the call.
+func (vm *VM) tailCall(fn *object.Closure, argCount int) error {
+	frame := vm.currentFrame()
+
+	vm.closeUpvalues(frame.basePointer)
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-argCount-1:vm.sp])
+
+	frame.unbound = nil
+
+	err := vm.bindArguments(frame, fn.Lambda.Signature, argCount)
+
+	if err != nil {
+		return err
+	}
+
+	err = vm.reserve(frame.basePointer + fn.Lambda.LocalsCount)
+
+	if err != nil {
+		return err
+	}
+
+	// An inline lambda stands in for the lambda it replaced in stack
+	// traces, as it runs part of the body of that lambda.
+	if !fn.Lambda.Inline {
+		frame.replaced = nil
+	} else if !frame.Closure.Lambda.Inline {
+		frame.replaced = frame.Closure.Lambda
+	}
+
+	frame.Closure = fn
+	frame.ip = -1
+
+	vm.clearLocals(frame, fn)
+	vm.sp = frame.basePointer + fn.Lambda.LocalsCount
+
+	return nil
+}
+
+// Place the arguments of a call to a Closure with the provided signature,
+// which sit at the base of the Frame, into the slots of the parameters they
+// are bound to.
+//
+// Parameters that were not passed an argument are set to null, and recorded
+// on the Frame so that their defaults can be applied.
+func (vm *VM) bindArguments(frame *Frame, signature object.Signature, argCount int) error {
+	// Arguments to lambdas with only required parameters are already in the
+	// right place.
+	if signature.IsFixed() && argCount == signature.Required {
+		return nil
+	}
+
+	slots, err := signature.Bind(vm.stack[frame.basePointer : frame.basePointer+argCount])
+
+	if err != nil {
+		return err
+	}
+
+	err = vm.reserve(frame.basePointer + len(slots))
+
+	if err != nil {
+		return err
+	}
+
+	for i, slot := range slots {
+		if slot == nil {
+			frame.setUnbound(i, len(slots))
+			slot = Null
+		}
+
+		vm.stack[frame.basePointer+i] = slot
+	}
+
+	return nil
+}
+
+// Clear the slots of the locals of the Frame's Closure that are not
+// parameters, so that a local is only seen once it has been set.
+func (vm *VM) clearLocals(frame *Frame, fn *object.Closure) {
+	start := frame.basePointer + fn.Lambda.Signature.Len()
+	end := min(frame.basePointer+fn.Lambda.LocalsCount, len(vm.stack))
+
+	if start < end {
+		clear(vm.stack[start:end])
+	}
+}
+
+// Create the free variables of a new Closure, as described by the captures of
+// its CompiledLambda, from the current Frame.
+//
+// Locals of the current Frame are captured through an Upvalue referring to
+// their stack slot, which is shared by every Closure capturing the same slot.
+func (vm *VM) capture(captures []object.Capture) []*object.Upvalue {
+	frame := vm.currentFrame()
+	free := make([]*object.Upvalue, len(captures))
+
+	for i, c := range captures {
+		switch c.Scope {
+		case object.CaptureLocal:
+			free[i] = vm.captureSlot(frame.basePointer + c.Index)
+		case object.CaptureFree:
+			free[i] = frame.Closure.Free[c.Index]
+		case object.CaptureCurrentClosure:
+			free[i] = object.NewClosedUpvalue(frame.Closure)
+		}
+	}
+
+	return free
+}
+
+// Return the open Upvalue for the provided stack slot, creating it if the
+// slot has not been captured yet.
+func (vm *VM) captureSlot(index int) *object.Upvalue {
+	i := 0
+
+	for i < len(vm.openUpvalues) && vm.openUpvalues[i].Index < index {
+		i++
+	}
+
+	if i < len(vm.openUpvalues) && vm.openUpvalues[i].Index == index {
+		return vm.openUpvalues[i]
+	}
+
+	upvalue := object.NewOpenUpvalue(&vm.stack[index], index)
+	vm.openUpvalues = slices.Insert(vm.openUpvalues, i, upvalue)
+
+	return upvalue
+}
+
+// Close every open Upvalue referring to a stack slot at or above the provided
+// index, as those slots are about to be reused.
+func (vm *VM) closeUpvalues(index int) {
+	i := len(vm.openUpvalues)
+
+	for i > 0 && vm.openUpvalues[i-1].Index >= index {
+		i--
+		vm.openUpvalues[i].Close()
+	}
+
+	vm.openUpvalues = vm.openUpvalues[:i]
+}
+
+// Count the provided number of objects created by the program, stopping
+// execution if it has created too many.
+func (vm *VM) allocate(n int) error {
+	if vm.meter == nil {
+		return nil
+	}
+
+	err := vm.meter.Allocate(n)
+
+	if err != nil {
+		return vm.halt(err)
+	}
+
+	return nil
+}
+
+// Stop execution with the provided error, which is not handled by try
+// expressions.
+func (vm *VM) halt(err error) error {
+	vm.halted = err
+	return err
+}
+
+// Add the stack trace of the Frames from the provided index of the frame
+// stack upwards to the provided error, after any trace it already has from
+// Frames above them.
+func (vm *VM) traceError(err error, from int) error {
+	var runtimeErr *RuntimeError
+
+	if errors.As(err, &runtimeErr) {
+		runtimeErr.Trace = append(runtimeErr.Trace, vm.trace(from)...)
+		return runtimeErr
+	}
+
+	return &RuntimeError{Err: err, Trace: vm.trace(from)}
+}
+
+// Return the stack trace of the Frames from the provided index of the frame
+// stack upwards, innermost first.
+func (vm *VM) trace(from int) object.StackTrace {
+	trace := object.StackTrace{}
+
+	for _, frame := range vm.callFrames(from) {
+		trace = append(trace, object.StackFrame{Name: frame.Name, Pos: frame.Pos})
+	}
+
+	return trace
+}
+
+// Return the name of the global at the provided index.
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+
+	return fmt.Sprintf("<global %d>", index)
+}
+
 // Return the item currently at the top of the stack.
 func (vm *VM) StackTop() object.Object {
 	if vm.sp == 0 {
@@ -342,10 +1026,14 @@ func (vm *VM) StackTop() object.Object {
 	return vm.stack[vm.sp-1]
 }
 
-// Add an object onto the stack, return an error if the stack is full.
+// Add an object onto the stack, stopping execution if the stack is full.
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		err := vm.reserve(vm.sp + 1)
+
+		if err != nil {
+			return err
+		}
 	}
 
 	vm.stack[vm.sp] = o
@@ -354,6 +1042,31 @@ func (vm *VM) push(o object.Object) error {
 	return nil
 }
 
+// Make sure the stack has at least the provided number of slots, growing it if
+// it does not, and stopping execution if it cannot grow that large.
+//
+// A grown stack is a copy of the old one, so the open Upvalues are moved to
+// refer to their slots in the copy.
+func (vm *VM) reserve(size int) error {
+	if size <= len(vm.stack) {
+		return nil
+	}
+
+	if size > vm.maxStack {
+		return vm.halt(&object.LimitError{Limit: object.StackSizeLimit})
+	}
+
+	stack := make([]object.Object, min(max(size, 2*len(vm.stack)), vm.maxStack))
+	copy(stack, vm.stack)
+	vm.stack = stack
+
+	for _, upvalue := range vm.openUpvalues {
+		upvalue.Location = &vm.stack[upvalue.Index]
+	}
+
+	return nil
+}
+
 // Return the item from the top of the stack and decrement the stack pointer.
 func (vm *VM) pop() object.Object {
 	o := vm.stack[vm.sp-1]
@@ -361,8 +1074,13 @@ func (vm *VM) pop() object.Object {
 	return o
 }
 
-// Return the item that was last popped from the stack.
+// Return the item that was last popped from the stack, or null if nothing
+// has been, as for a program with only comments.
 func (vm *VM) LastPoppedStackElem() object.Object {
+	if vm.stack[vm.sp] == nil {
+		return Null
+	}
+
 	return vm.stack[vm.sp]
 }
 
@@ -375,9 +1093,22 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
-	vm.frames[vm.framesIndex] = f
+// Add a Frame onto the frame stack, growing it if needed, and stopping
+// execution if the frame stack is full.
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= vm.maxFrames {
+		return vm.halt(&object.LimitError{Limit: object.CallDepthLimit})
+	}
+
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = f
+	} else {
+		vm.frames = append(vm.frames, f)
+	}
+
 	vm.framesIndex++
+
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
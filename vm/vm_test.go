@@ -1,13 +1,17 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"lisp/ast"
 	"lisp/compiler"
 	"lisp/lexer"
 	"lisp/object"
 	"lisp/parser"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Ensure arithmetic functions as expected.
@@ -56,12 +60,144 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// Test that multi-way conditionals choose the right branch, and only evaluate
+// the expressions needed.
+func TestMultiWayConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"(cond)", Null},
+		{"(cond (false 1))", Null},
+		{"(cond (false 1) (true 2) (true 3))", 2},
+		{"(cond (false 1) (else 2 3))", 3},
+		{"(cond (false) (7))", 7},
+		{"(cond ((= 1 2) (len 1)) ((< 1 2) 4))", 4},
+		{"(def sign (lambda (n) (cond ((< n 0) -1) ((> n 0) 1) (else 0)))) (list (sign -5) (sign 0) (sign 3))", []interface{}{-1, 0, 1}},
+		{"(case 3 ((1 2) 'low) ((3 4) 'high))", &object.Symbol{Name: "high"}},
+		{"(case 5 ((1 2) 1) (else 2))", 2},
+		{"(case 5 ((1 2) 1))", Null},
+		{"(case \"b\" ((\"a\") 1) ((\"b\") 2))", 2},
+		{"(case 'x ((y) 1) ((x z) 2))", 2},
+		{"(case null ((1) 1) ((null) 2))", 2},
+		{"(case true ((false) 1) ((true) 2))", 2},
+		{"(def f (lambda (k) (case k ((1) 'one) (else k)))) (list (f 1) (f 2))", []interface{}{&object.Symbol{Name: "one"}, 2}},
+		{"(when true 1 2)", 2},
+		{"(when false 1)", Null},
+		{"(when true)", Null},
+		{"(unless false 1 2)", 2},
+		{"(unless true 1)", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that and/or only evaluate the arguments needed to decide the result,
+// and result in the last value evaluated.
+func TestAndOrExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"(and)", true},
+		{"(and 4)", 4},
+		{"(and 1 2 3)", 3},
+		{"(and 1 null 3)", Null},
+		{"(and true false)", false},
+		{"(or)", false},
+		{"(or false 1)", 1},
+		{"(or null false)", false},
+		{"(def x 0) (and (not (= x 0)) (/ 10 x))", false},
+		{"(def x 0) (or (= x 0) (/ 10 x))", true},
+		{"(def x 5) (and (not (= x 0)) (/ 10 x))", 2},
+		{"(def f (lambda (a b) (or a b))) (f false 7)", 7},
+	}
+
+	runVmTests(t, tests)
+}
+
 // Test globals are created and resolved correctly.
 func TestGlobalDefExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"(def one 1) one", 1},
 		{"(def one 1) (def two 2) one", 1},
 		{"(def one 1) (def two one) two", 1},
+		{"(def two (lambda () (+ one 1))) (def one 1) (two)", 2},
+		{"(def two (lambda () (+ one 1))) (two) (def one 1)", fmt.Errorf("undefined variable one")},
+		{"(def a a)", fmt.Errorf("undefined variable a")},
+		{"(set! a 2) (def a 1)", fmt.Errorf("cannot set! undefined variable a")},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that programs run one after another, as in the repl, can define the
+// globals used by the lambdas of earlier programs, and that a program that
+// fails to compile defines nothing.
+func TestGlobalsAcrossPrograms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"(def f (lambda () (g)))", nil},
+		{"(f)", fmt.Errorf("undefined variable g")},
+		{"(def g (lambda () 1))", nil},
+		{"(f)", 1},
+		{"(def h (lambda () 2)) (if true)", fmt.Errorf("1:23: incorrect number of values in if expression")},
+		{"(h)", fmt.Errorf("1:2: undefined variable h")},
+		{"(def s (lambda () (set! later 1)))", nil},
+		{"(s)", fmt.Errorf("cannot set! undefined variable later")},
+		{"(def later 0) (s) later", 1},
+	}
+
+	constants := []object.Object{}
+	globals := []object.Object{}
+	symbolTable := compiler.NewSymbolTable()
+
+	for i, builtin := range object.Builtins {
+		symbolTable.DefineBuiltin(i, builtin.Name)
+	}
+
+	// Compile and run the input with the state left by those before it.
+	run := func(input string) (object.Object, error) {
+		comp := compiler.NewWithState(constants, symbolTable)
+		err := comp.Compile(parse(input))
+
+		if err != nil {
+			return nil, err
+		}
+
+		constants = comp.Bytecode().Constants
+
+		vm := NewWithState(comp.Bytecode(), globals)
+		err = vm.Run()
+		globals = vm.Globals()
+
+		return vm.LastPoppedStackElem(), err
+	}
+
+	for _, tt := range tests {
+		result, err := run(tt.input)
+
+		if expectedError, ok := tt.expected.(error); ok {
+			if err == nil || err.Error() != expectedError.Error() {
+				t.Errorf("wrong error for %s: want=%q got=%v", tt.input, expectedError, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", tt.input, err)
+		}
+
+		if tt.expected != nil {
+			testExpectedObject(t, tt.expected, result)
+		}
+	}
+}
+
+// Test that a program without expressions, such as one with only comments,
+// results in null.
+func TestEmptyPrograms(t *testing.T) {
+	tests := []vmTestCase{
+		{"", Null},
+		{"; just a comment", Null},
+		{"#| a block comment |# #;(+ 1 2)", Null},
 	}
 
 	runVmTests(t, tests)
@@ -196,6 +332,30 @@ func TestLambdasWithWrongArgCount(t *testing.T) {
 			input:    "((lambda (a b) a b) 1)",
 			expected: "wrong number of arguments: expected=2 got=1",
 		},
+		{
+			input:    "((lambda (a &optional b) a))",
+			expected: "wrong number of arguments: expected=1 to 2 got=0",
+		},
+		{
+			input:    "((lambda (a &optional b) a) 1 2 3)",
+			expected: "wrong number of arguments: expected=1 to 2 got=3",
+		},
+		{
+			input:    "((lambda (a . r) a))",
+			expected: "wrong number of arguments: expected=at least 1 got=0",
+		},
+		{
+			input:    "((lambda (&key a) a) :a)",
+			expected: "keyword arguments must be pairs of keyword and value, got 1 values",
+		},
+		{
+			input:    "((lambda (&key a) a) 1 2)",
+			expected: "expected keyword argument, got 1",
+		},
+		{
+			input:    "((lambda (&key a) a) :b 2)",
+			expected: "unknown keyword argument :b",
+		},
 	}
 
 	for _, tt := range tests {
@@ -226,12 +386,47 @@ func TestLambdasWithWrongArgCount(t *testing.T) {
 	}
 }
 
+// Test that optional, rest and keyword parameters are bound to the arguments
+// of a call, and that defaults are applied to those that were left out.
+func TestLambdaParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"((lambda (a . r) (len r)) 1 2 3)", 2},
+		{"((lambda (a . r) (len r)) 1)", 0},
+		{"((lambda (a &rest r) (first r)) 1 2 3)", 2},
+		{"((lambda (a &optional b) b) 1)", Null},
+		{"((lambda (a &optional (b 2)) (+ a b)) 1)", 3},
+		{"((lambda (a &optional (b 2)) (+ a b)) 1 5)", 6},
+		{"((lambda (a &optional (b (* a 10)) (c (+ b 1))) c) 2)", 21},
+		{"((lambda (a &optional (b 2) &rest r) (+ a b (len r))) 1 1 1 1)", 4},
+		{"((lambda (&key x y) y) :y 3)", 3},
+		{"((lambda (&key x y) x) :y 3)", Null},
+		{"((lambda (a &key (x 10) (y (+ x 1))) (+ a x y)) 1 :x 2)", 6},
+		{"((lambda (&rest r &key x) (len r)) :x 1)", 2},
+		{"(def f (lambda (&key (verbose false)) verbose)) (f :verbose true)", true},
+		{":key", &object.Symbol{Name: ":key"}},
+		{
+			input: `
+            (def make (lambda (&optional (n 0))
+              (lambda () (set! n (+ n 1)))))
+            (def counter (make 10))
+            (counter)
+            (counter)
+            `,
+			expected: 12,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
 // Ensure builtin function can be executed.
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{"(+ 1 2)", 3},
 		{"(+ 1 2 3)", 6},
 		{`(len "hello")`, 5},
+		{`(len "héllo wörld")`, 11},
+		{`(len "tab\tescaped")`, 11},
 		{
 			`(len 1)`,
 			fmt.Errorf(
@@ -315,6 +510,691 @@ func TestClosures(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+// Test that let expressions bind names in their own scope, which closures in
+// their body can capture.
+func TestLetExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"(let () 1)", 1},
+		{"(let ((a 1)))", Null},
+		{"(let ((a 1) (b 2)) (+ a b))", 3},
+		{"(def a 10) (let ((a 1) (b a)) b)", 10},
+		{"(def a 10) (let ((a 1)) a) a", 10},
+		{"(let ((a 1)) (let ((a 2) (b a)) (+ a b)))", 3},
+		{"(let* ((a 1) (b (+ a 1)) (a (* b 10))) a)", 20},
+		{"(def add (let ((n 5)) (lambda (x) (+ x n)))) (add 1)", 6},
+		{"(def f (lambda (x) (let ((y (* x 2))) (lambda () (+ x y))))) ((f 3))", 9},
+		{"(letrec ((a b) (b 1)) a)", fmt.Errorf("undefined variable b")},
+		{"(def b 5) (letrec ((a (+ b 1)) (b 1)) a)", fmt.Errorf("undefined variable b")},
+		{"(letrec ((f (lambda () b)) (a (f)) (b 1)) a)", fmt.Errorf("undefined variable b")},
+		{"(letrec ((a (try b (catch e (str e)))) (b 1)) a)", "undefined variable b"},
+		{
+			input: `
+            (letrec ((fact (lambda (n)
+                             (if (= n 0) 1 (* n (fact (- n 1)))))))
+              (fact 5))
+            `,
+			expected: 120,
+		},
+		{
+			input: `
+            (let loop ((i 0) (acc '()))
+              (if (= i 3)
+                acc
+                (loop (+ i 1) (push acc i))))
+            `,
+			expected: []interface{}{0, 1, 2},
+		},
+		{
+			input: `
+            (def sum (lambda (xs)
+              (let loop ((xs xs) (total 0))
+                (if (= (len xs) 0)
+                  total
+                  (loop (rest xs) (+ total (first xs)))))))
+            (sum '(1 2 3 4))
+            `,
+			expected: 10,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that set! changes variables in every scope, and that closures which
+// captured the same variable share its changes.
+func TestSetExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"(def a 1) (set! a 2) a", 2},
+		{"(def a 1) (set! a (+ a 1))", 2},
+		{"(def f (lambda (a) (set! a (* a 2)) a)) (f 4)", 8},
+		{"(let ((a 1)) (let ((b 2)) (set! a b)) a)", 2},
+		{
+			input: `
+            (def counter (lambda ()
+              (let ((n 0))
+                (lambda () (set! n (+ n 1))))))
+            (def c (counter))
+            (c)
+            (c)
+            (c)
+            `,
+			expected: 3,
+		},
+		{
+			input: `
+            (def counter (lambda ()
+              (let ((n 0))
+                (lambda () (set! n (+ n 1))))))
+            (def a (counter))
+            (def b (counter))
+            (a)
+            (a)
+            (b)
+            (list (a) (b))
+            `,
+			expected: []interface{}{3, 2},
+		},
+		{
+			input: `
+            (def account (lambda (balance)
+              (list (lambda (n) (set! balance (+ balance n)))
+                    (lambda () balance))))
+            (def acc (account 10))
+            ((first acc) 5)
+            ((last acc))
+            `,
+			expected: 15,
+		},
+		{
+			input: `
+            (def f (lambda ()
+              (def x 1)
+              (def get (lambda () (lambda () x)))
+              (def g (get))
+              (set! x 2)
+              (g)))
+            (f)
+            `,
+			expected: 2,
+		},
+		{
+			input: `
+            (letrec ((even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+                     (odd? (lambda (n) (if (= n 0) false (even? (- n 1))))))
+              (list (even? 10) (odd? 7) (even? 3)))
+            `,
+			expected: []interface{}{true, true, false},
+		},
+		{"(def map 1) (set! map 2) map", 2},
+		{"(let ((first 1)) (set! first 2) first)", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that quoted data is never evaluated, and that quasiquote evaluates
+// only the unquoted parts of its argument.
+func TestQuoting(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"'a", "a"},
+		{"'(a b c)", "(a b c)"},
+		{"'(1 (\"two\" three) ())", "(1 (two three) ())"},
+		{"(= 'a 'a)", "true"},
+		{"(= 'a 'b)", "false"},
+		{"(def x 5) `(x ,x)", "(x 5)"},
+		{"(def xs '(1 2)) `(0 ,@xs 3 ,@xs)", "(0 1 2 3 1 2)"},
+		{"`(1 `(2 ,(3 ,(+ 2 2))))", "(1 (quasiquote (2 (unquote (3 4)))))"},
+		{"(def f (lambda (n) `(n is ,n))) (f 3)", "(n is 3)"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+
+		err := comp.Compile(program)
+
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		result := vm.LastPoppedStackElem().Inspect()
+
+		if result != tt.expected {
+			t.Errorf("wrong result for %s: want=%s got=%s", tt.input, tt.expected, result)
+		}
+	}
+}
+
+// Test that calls in tail position reuse the frame of the caller, so that
+// tail recursion, including mutual recursion, is not limited in depth.
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+            (def count (lambda (n acc) (if (= n 0) acc (count (- n 1) (+ acc 1)))))
+            (count 100000 0)
+            `,
+			expected: 100000,
+		},
+		{
+			input: `
+            (letrec ((even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+                     (odd? (lambda (n) (if (= n 0) false (even? (- n 1))))))
+              (even? 100001))
+            `,
+			expected: false,
+		},
+		{
+			input: `
+            (def even? (lambda (n) (if (= n 0) true (odd? (- n 1)))))
+            (def odd? (lambda (n) (if (= n 0) false (even? (- n 1)))))
+            (even? 100001)
+            `,
+			expected: false,
+		},
+		{
+			input: `
+            (def loop (lambda (n)
+              (cond ((= n 0) 'done)
+                    (else (when true (and true (or false (loop (- n 1)))))))))
+            (loop 100000)
+            `,
+			expected: &object.Symbol{Name: "done"},
+		},
+		{
+			input:    "(let loop ((i 0) (acc 0)) (if (= i 100000) acc (loop (+ i 1) (+ acc 2))))",
+			expected: 200000,
+		},
+		{
+			input: `
+            (def collect (lambda (n fns)
+              (if (= n 0) fns (collect (- n 1) (push fns (lambda () n))))))
+            (def fns (collect 3 '()))
+            (+ (* 10 ((first fns))) ((last fns)))
+            `,
+			expected: 31,
+		},
+		{
+			input: `
+            (def f (lambda (a &optional (b 1)) (if (= a 0) b (f (- a 1)))))
+            (f 3 5)
+            `,
+			expected: 1,
+		},
+		{
+			input: `
+            (def sum (lambda (n) (if (= n 0) 0 (+ n (sum (- n 1))))))
+            (sum 100)
+            `,
+			expected: 5050,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that = compares lists and dictionaries by their items and closures and
+// exceptions by identity, and that compare, < and > order numbers, strings and lists.
+func TestEqualityAndOrdering(t *testing.T) {
+	tests := []vmTestCase{
+		{"(= '(1 (2 \"a\")) (list 1 (list 2 \"a\")))", true},
+		{"(= '(1 2) '(1 2) '(1 3))", false},
+		{"(= '(1 2) '(1 2 3))", false},
+		{"(= '() '())", true},
+		{"(= (dict \"a\" '(2)) (dict \"a\" '(2)))", true},
+		{"(= (dict \"a\" 2) (dict \"a\" 3))", false},
+		{"(= (dict \"a\" 2) (dict \"b\" 2))", false},
+		{"(= null null)", true},
+		{"(= null false)", false},
+		{"(= '(1) 1)", false},
+		{"(def f (lambda () 1)) (= f f)", true},
+		{"(= (lambda () 1) (lambda () 1))", false},
+		{"(try (/ 1 0) (catch e (= e e)))", true},
+		{"(def caught (lambda () (try (/ 1 0) (catch e e)))) (= (caught) (caught))", false},
+		{"(case '(1 2) (((1 2)) 'list) (else 'other))", &object.Symbol{Name: "list"}},
+		{"(compare 1 2)", -1},
+		{"(compare \"b\" \"a\")", 1},
+		{"(compare '(1 2) '(1 2))", 0},
+		{"(compare '(1 2) '(1 2 0))", -1},
+		{"(compare '(1 \"b\") '(1 \"a\" 5))", 1},
+		{"(< \"apple\" \"banana\" \"cherry\")", true},
+		{"(> \"b\" \"a\" \"c\")", false},
+		{"(< '(1) '(1 0) '(2))", true},
+		{"(< 1 2 3)", true},
+		{"(compare 1 \"a\")", fmt.Errorf("attempted to call compare with mismatched types NUMBER (1) and STRING (a)")},
+		{"(< 'a 'b)", fmt.Errorf("attempted to call < with unsupported type SYMBOL (a)")},
+		{"(> 2 1 '(1))", fmt.Errorf("attempted to call > with mismatched types NUMBER (1) and LIST ((1))")},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that numbers, symbols, null, booleans and lists and dictionaries of them
+// can be used as dictionary keys, with equal keys finding the same entry.
+func TestDictionaryKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{"(get {1 \"one\" 2 \"two\"} 1)", "one"},
+		{"(get {0 \"zero\"} -0)", "zero"},
+		{"(get {'a 1 \"a\" 2} 'a)", 1},
+		{"(get {'a 1 \"a\" 2} \"a\")", 2},
+		{"(get {null 1} null)", 1},
+		{"(get {true 1 \"true\" 2} true)", 1},
+		{"(get {'(1 (2 \"b\")) 'found} (list 1 (list 2 \"b\")))", &object.Symbol{Name: "found"}},
+		{"(get {'(1 2) 'found} '(1 (2)))", Null},
+		{"(get {'(1) 'found} '((1)))", Null},
+		{"(len {1 \"a\" 1 \"b\" '(1) \"c\" \"1\" \"d\"})", 3},
+		{"(get {1 \"a\" 1 \"b\"} 1)", "b"},
+		{"(len (set (set {} '(x y) 1) (list 'x 'y) 2))", 1},
+		{"(get (set (set {} '(x y) 1) (list 'x 'y) 2) '(x y))", 2},
+		{"(get {{1 2 3 4} 'found} {3 4 1 2})", &object.Symbol{Name: "found"}},
+		{"(get {{1 2} 'found} {1 3})", Null},
+		{"(get {{} 'empty '() 'list} (dict))", &object.Symbol{Name: "empty"}},
+		{"(len {{1 2} 'a (set {} 1 2) 'b})", 1},
+		{"(get {} (list {1 +}))", fmt.Errorf("attempted to use unsupported type as dict key LIST (({1: +}))")},
+		{"(set {} + 1)", fmt.Errorf("attempted to use unsupported type as dict key FUNCTION (+)")},
+		{"(get {1 2})", fmt.Errorf("attempted to call get with incorrect number of arguments: expected 2, got=1")},
+		{"(set {1 2} 1)", fmt.Errorf("attempted to call set with incorrect number of arguments: expected 3, got=2")},
+		{"(set '(1) 1 2)", fmt.Errorf("attempted to set in LIST((1)) instead of dict")},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that push, rest, concat and set leave their arguments unchanged, and
+// that lists and dictionaries keep their items as they grow large.
+func TestPersistentCollections(t *testing.T) {
+	build := `
+(def build (lambda (n) (let loop ((i 0) (l '())) (if (= i n) l (loop (+ i 1) (push l i))))))
+(def ordered? (lambda (l i) (cond ((= (len l) 0) true) ((= (first l) i) (ordered? (rest l) (+ i 1))) (else i))))
+`
+	tests := []vmTestCase{
+		{"(def a '(1 2)) (def b (push a 3)) (def c (push a 4)) (list a b c)", []any{[]any{1, 2}, []any{1, 2, 3}, []any{1, 2, 4}}},
+		{"(def a '(1 2 3)) (def b (push (rest a) 4)) (list a b (rest (rest (rest a))))", []any{[]any{1, 2, 3}, []any{2, 3, 4}, []any{}}},
+		{"(def a '(1)) (list (concat a '(2) '() '(3 4)) a)", []any{[]any{1, 2, 3, 4}, []any{1}}},
+		{"(def d {'a 1}) (def e (set d 'b 2)) (list (len d) (get d 'b) (len e) (get e 'b))", []any{1, Null, 2, 2}},
+		{"(def d {'a 1}) (set d 'a 2) (get d 'a)", 1},
+		{build + "(def l (build 5000)) (list (len l) (first l) (last l) (ordered? l 0))", []any{5000, 0, 4999, true}},
+		{build + "(def l (build 100)) (def m (push l 'x)) (list (ordered? l 0) (last l) (last m))", []any{true, 99, &object.Symbol{Name: "x"}}},
+		{build + `(def fill (lambda (d l) (if (= (len l) 0) d (fill (set d (first l) (str (first l))) (rest l)))))
+(def d (fill {} (build 3000)))
+(list (len d) (get d 0) (get d 1234) (get d 2999) (get d 3000))`, []any{3000, "0", "1234", "2999", Null}},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that dictionaries print their keys in the order they were first set.
+func TestDictionaryOrder(t *testing.T) {
+	descending := []string{}
+
+	for i := 100; i > 0; i-- {
+		descending = append(descending, fmt.Sprintf("%d: %d", i, i*i))
+	}
+
+	tests := []vmTestCase{
+		{`(str {"b" 1 "a" 2 "c" 3})`, "{b: 1, a: 2, c: 3}"},
+		{`(str (set {"b" 1 "a" 2} "b" 3))`, "{b: 3, a: 2}"},
+		{`(str (set (set {} 2 'x) 1 'y))`, "{2: x, 1: y}"},
+		{`(str {'(1 2) 1 null 2 true 3 'a 4})`, "{(1 2): 1, null: 2, true: 3, a: 4}"},
+		{`(str (let loop ((i 100) (d {})) (if (= i 0) d (loop (- i 1) (set d i (* i i))))))`,
+			"{" + strings.Join(descending, ", ") + "}"},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that builtins taking functions can call closures and builtins, with
+// errors and thrown values passing through them.
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"(map (lambda (n) (* n n)) '(1 2 3))", []any{1, 4, 9}},
+		{"(map first '((1 2) (3)))", []any{1, 3}},
+		{"(let ((k 10)) (map (lambda (n) (+ n k)) '(1 2)))", []any{11, 12}},
+		{"(map (lambda (l) (map (lambda (n) (* n 10)) l)) '((1) (2 3)))", []any{[]any{10}, []any{20, 30}}},
+		{"(filter (lambda (n) (> n 2)) '(1 3 2 4))", []any{3, 4}},
+		{"(reduce + 0 '(1 2 3 4))", 10},
+		{"(reduce (lambda (acc n) (push acc (* 2 n))) '() '(1 2))", []any{2, 4}},
+		{"(map last (sort-by first '((3 \"a\") (1 \"b\") (2 \"c\") (1 \"d\"))))", []any{"b", "d", "c", "a"}},
+		{"(sort-by (lambda (s) (len s)) '(\"ccc\" \"a\" \"bb\"))", []any{"a", "bb", "ccc"}},
+		{"(apply + 1 2 '(3 4))", 10},
+		{"(apply (lambda (a &rest r) r) '(1 2 3))", []any{2, 3}},
+		{"(def total 0) (for-each (lambda (n) (set! total (+ total n))) '(1 2 3)) total", 6},
+		{"(for-each print '())", Null},
+		{"(def count (lambda (n) (if (= n 0) 0 (+ 1 (first (map count (list (- n 1)))))))) (count 100)", 100},
+		{"(try (map (lambda (n) (throw n)) '(1 2)) (catch e (list 'caught e)))", []any{&object.Symbol{Name: "caught"}, 1}},
+		{"(map (lambda (n) (try (throw n) (catch e (* e 10)))) '(1 2))", []any{10, 20}},
+		{"(list (try (map (lambda (n) (+ n \"a\")) '(1)) (catch e 'handled)) (+ 1 2))", []any{&object.Symbol{Name: "handled"}, 3}},
+		{"(map (lambda (n) (+ n \"a\")) '(1))", fmt.Errorf("attempted to call + with unsupported type STRING (a)")},
+		{"(for-each (lambda (n) (throw n)) '(5))", fmt.Errorf("uncaught exception: 5")},
+		{"(map 1 '(1))", fmt.Errorf("calling non-function")},
+		{"(map (lambda (n) n) 5)", fmt.Errorf("attempted to call map with unsupported type NUMBER (5)")},
+		{"(sort-by (lambda (n) n) '(1 \"a\"))", fmt.Errorf("attempted to call sort-by with mismatched types STRING (a) and NUMBER (1)")},
+		{"(apply +)", fmt.Errorf("attempted to call apply with incorrect number of arguments: expected at least 2, got=1")},
+	}
+
+	runVmTests(t, tests)
+}
+
+// Test that an error that is not handled has the stack trace of the functions
+// it occurred in, leaving out those replaced by tail calls and the lambdas
+// used to run let and catch bodies.
+func TestStackTraces(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"(def f (lambda (x)\n  (+ x \"a\")))\n(def g (lambda (x)\n  (list (f x))))\n(g 1)",
+			"  at f (2:3)\n  at g (4:9)\n  at <main> (5:1)\n",
+		},
+		{
+			"(def f (lambda (x) (first x)))\n(def g (lambda (x) (f x)))\n(list (g 1))",
+			"  at f (1:20)\n  at <main> (3:7)\n",
+		},
+		{
+			"(def f (lambda (x)\n  (print x)\n  (+ x \"a\")))\n(def g (lambda (x)\n  (list (f x))\n  x))\n(g 1)",
+			"  at f (3:3)\n  at g (5:9)\n  at <main> (7:1)\n",
+		},
+		{
+			"(def f (lambda (x)\n  (let ((y x))\n    (try (first y) (catch e (first e))))))\n(f 1)",
+			"  at f (3:29)\n  at <main> (4:1)\n",
+		},
+		{
+			"(let loop ((i 0))\n  (if (< i 2) (loop (+ i 1)) (first i)))",
+			"  at loop (2:30)\n  at <main> (1:1)\n",
+		},
+		{
+			"(map (lambda (n) (first n)) '(1))",
+			"  at lambda (1:18)\n  at <main> (1:1)\n",
+		},
+		{
+			"(def f (lambda (a b) a))\n(def g (lambda () (f 1)))\n(g)",
+			"  at g (2:19)\n  at <main> (3:1)\n",
+		},
+		{
+			"(def f (lambda () (throw 'oops)))\n(try (f) (finally 1))",
+			"  at <main> (2:1)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+
+		err := comp.Compile(program)
+
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+
+		err = vm.Run()
+
+		var runtimeErr *RuntimeError
+
+		if !errors.As(err, &runtimeErr) {
+			t.Fatalf("expected runtime error for %s, got=%v", tt.input, err)
+		}
+
+		if runtimeErr.Trace.String() != tt.expected {
+			t.Errorf("wrong trace for %s: want=%q got=%q", tt.input, tt.expected, runtimeErr.Trace.String())
+		}
+	}
+}
+
+// Test that the stack and frame stack start small and grow as they are
+// needed, and that variables captured from the stack are still shared once
+// it has grown.
+func TestStackGrowth(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+            (def deep (lambda (n) (if (= n 0) 0 (+ 1 (deep (- n 1))))))
+            (deep 400)
+            `,
+			expected: 400,
+		},
+		{
+			input: `
+            (def deep (lambda (n) (if (= n 0) 0 (+ 1 (deep (- n 1))))))
+            (def twice (lambda (g) (g) (deep 100) (g)))
+            (def f (lambda (x) (twice (lambda () (set! x (+ x 1)))) x))
+            (f 1)
+            `,
+			expected: 3,
+		},
+	}
+
+	runVmTests(t, tests)
+
+	comp := compiler.New()
+
+	err := comp.Compile(parse("(def a 1) (def b 2) (+ a b)"))
+
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode())
+
+	if len(vm.stack) != initialStackSize || len(vm.frames) != 1 || len(vm.globals) != 2 {
+		t.Errorf("wrong initial sizes: stack=%d frames=%d globals=%d", len(vm.stack), len(vm.frames), len(vm.globals))
+	}
+}
+
+// Test that a program exceeding one of its limits stops with a LimitError
+// for that limit, which try expressions do not catch, and that a program
+// within its limits runs to completion.
+func TestLimits(t *testing.T) {
+	loop := "(def loop (lambda (n) (loop (+ n 1))))\n"
+	count := "(def f (lambda (n) (if (= n 0) 0 (+ 1 (f (- n 1))))))\n"
+	build := "(def build (lambda (n l) (if (= n 0) l (build (- n 1) (push l n)))))\n"
+
+	tests := []struct {
+		input    string
+		limits   object.Limits
+		expected string
+	}{
+		{loop + "(loop 0)", object.Limits{Steps: 1000}, object.StepLimit},
+		{loop + "(try (loop 0) (catch e 'caught))", object.Limits{Steps: 1000}, object.StepLimit},
+		{loop + "(loop 0)", object.Limits{Timeout: time.Millisecond}, object.TimeLimit},
+		{count + "(f 20)", object.Limits{CallDepth: 10}, object.CallDepthLimit},
+		{count + "(f 5)", object.Limits{CallDepth: 10, Steps: 1000}, ""},
+		{count + "(f 200)", object.Limits{StackSize: 100}, object.StackSizeLimit},
+		{count + "(f 100000)", object.Limits{}, object.StackSizeLimit},
+		{count + "(f 1000)", object.Limits{StackSize: 10000, CallDepth: 2000}, ""},
+		{build + "(build 1000 '())", object.Limits{Allocations: 100}, object.AllocationLimit},
+		{build + "(build 10 '())", object.Limits{Allocations: 100}, ""},
+		{loop + "(map (lambda (n) (loop n)) '(1))", object.Limits{Steps: 1000}, object.StepLimit},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+
+		err := comp.Compile(program)
+
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode(), WithLimits(tt.limits))
+
+		err = vm.Run()
+
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %s: %s", tt.input, err)
+			}
+
+			continue
+		}
+
+		var limitErr *object.LimitError
+
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("expected limit error for %s, got=%v", tt.input, err)
+		}
+
+		if limitErr.Limit != tt.expected {
+			t.Errorf("wrong limit for %s: want=%q got=%q", tt.input, tt.expected, limitErr.Limit)
+		}
+	}
+}
+
+// Test that a VM stopped by exceeding a limit can still run its program and
+// call functions afterwards, each starting with its resources unused.
+func TestLimitsReset(t *testing.T) {
+	program := parse("(def loop (lambda (n) (loop (+ n 1))))\n(def id (lambda (x) x))\n(loop 0)")
+	comp := compiler.New()
+
+	err := comp.Compile(program)
+
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	vm := New(comp.Bytecode(), WithLimits(object.Limits{Steps: 1000}))
+
+	for i := 0; i < 2; i++ {
+		var limitErr *object.LimitError
+
+		if err := vm.Run(); !errors.As(err, &limitErr) || limitErr.Limit != object.StepLimit {
+			t.Fatalf("run %d: expected step limit error, got=%v", i, err)
+		}
+
+		loop, id := vm.Globals()[0], vm.Globals()[1]
+
+		if err := testIntegerObject(1, vm.Call(id, &object.Number{Value: 1})); err != nil {
+			t.Fatalf("run %d: call after limit error: %s", i, err)
+		}
+
+		result := vm.CallContext(context.Background(), loop, &object.Number{Value: 0})
+
+		if errObj, ok := result.(*object.ErrorObject); !ok || errObj.Limit == nil || errObj.Limit.Limit != object.StepLimit {
+			t.Fatalf("run %d: expected step limit error calling loop, got=%v", i, result)
+		}
+	}
+}
+
+// Test that a program stops when the context it runs in is cancelled.
+func TestCancellation(t *testing.T) {
+	program := parse("(def loop (lambda (n) (loop (+ n 1))))\n(loop 0)")
+	comp := compiler.New()
+
+	err := comp.Compile(program)
+
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond, cancel)
+
+	err = New(comp.Bytecode()).RunContext(ctx)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got=%v", err)
+	}
+
+	if err.Error() != "execution cancelled: context canceled" {
+		t.Errorf("wrong error message: %q", err.Error())
+	}
+}
+
+// Test that errors and thrown values are caught by the innermost try that
+// surrounds them, unwinding any frames in between, and that cleanup runs
+// whether or not the body fails.
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{"(try 1 (catch e 2))", 1},
+		{"(try (throw 1) (catch e (+ e 1)))", 2},
+		{"(try (/ 1 0) (catch e (str e)))", "Attempted to divide by 0"},
+		{"(try (throw 'a) (catch e e))", &object.Symbol{Name: "a"}},
+		{"(try (throw 1) (catch e))", &object.Null{}},
+		{"(+ 1 (try (throw 1) (catch e 10)) 100)", 111},
+		{
+			input: `
+            (def f (lambda (n) (if (= n 0) (throw 'bottom) (+ 1 (f (- n 1))))))
+            (try (f 50) (catch e e))
+            `,
+			expected: &object.Symbol{Name: "bottom"},
+		},
+		{
+			input: `
+            (def log '())
+            (def result (try 1 (finally (set! log (push log 'cleanup)))))
+            (list result log)
+            `,
+			expected: []interface{}{1, []interface{}{&object.Symbol{Name: "cleanup"}}},
+		},
+		{
+			input: `
+            (def log '())
+            (try
+              (try (throw 'inner) (finally (set! log (push log 'cleanup))))
+              (catch e (push log e)))
+            `,
+			expected: []interface{}{&object.Symbol{Name: "cleanup"}, &object.Symbol{Name: "inner"}},
+		},
+		{
+			input: `
+            (def log '())
+            (def result
+              (try (throw 1)
+                (catch e (set! log (push log e)) 'handled)
+                (finally (set! log (push log 2)))))
+            (list result log)
+            `,
+			expected: []interface{}{&object.Symbol{Name: "handled"}, []interface{}{1, 2}},
+		},
+		{"(try (try (throw 1) (catch e (throw (+ e 1)))) (catch e e))", 2},
+		{"(try (try (/ 1 0) (catch e (throw e))) (catch e (str e)))", "Attempted to divide by 0"},
+		{
+			input: `
+            (def f2 (lambda (x) (* x 10)))
+            (def f (lambda () (try (throw 1) (catch e (f2 e)))))
+            (f)
+            `,
+			expected: 10,
+		},
+		{
+			// Closures created within the body keep the values they captured
+			// after the frames they were created in are unwound.
+			input: `
+            (def saved null)
+            (def f (lambda (x) (set! saved (lambda () x)) (throw 'fail)))
+            (try (f 5) (catch e (saved)))
+            `,
+			expected: 5,
+		},
+		{
+			input: `
+            (def loop (lambda (n)
+              (if (= n 0) 'done (try (loop (- n 1)) (catch e e)))))
+            (loop 100)
+            `,
+			expected: &object.Symbol{Name: "done"},
+		},
+		{"(throw 'oops)", fmt.Errorf("uncaught exception: oops")},
+		{"(try (/ 1 0) (finally 1))", fmt.Errorf("Attempted to divide by 0")},
+		{"(try (throw \"a\") (catch e (throw (str e \"b\"))))", fmt.Errorf("uncaught exception: ab")},
+	}
+
+	runVmTests(t, tests)
+}
+
 // Celebtration test case showing that the compiler works well.
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
@@ -486,6 +1366,12 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 			t.Errorf("incorrect error message: want=%q got=%q",
 				expected.Error, errObj.Error)
 		}
+	case *object.Symbol:
+		symbol, ok := actual.(*object.Symbol)
+
+		if !ok || symbol.Name != expected.Name {
+			t.Errorf("object is not symbol %s: %T(%+v)", expected.Name, actual, actual)
+		}
 	case *object.Null:
 		if actual != Null {
 			t.Errorf("object is not null: %T(%+v)", actual, actual)
@@ -496,7 +1382,7 @@ func testExpectedObject(t *testing.T, expected interface{}, actual object.Object
 		if !ok {
 		}
 
-		for i, v := range listObj.Values {
+		for i, v := range listObj.Items() {
 			testExpectedObject(t, expected[i], v)
 		}
 	}